@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/config"
+)
+
+// Config works with the config file.
+var Config = cmd.Sub{
+	Name:  "config",
+	Short: "Check the config for mistakes",
+	Args:  &ConfigArgs{},
+	Run:   ConfigRun,
+}
+
+// ConfigArgs contains the arguments of the config command.
+type ConfigArgs struct {
+	Action string `desc:"check"`
+}
+
+// ConfigRun handles the execution of the config command.
+func ConfigRun(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*ConfigArgs)
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	// Parse Global Config Flag for Custom Config Path
+	configPath := r.Flags.(*GlobalFlags).Config
+	if configPath == "" {
+		configPath = "/etc/hyprspace/" + ifName + ".json"
+	}
+
+	switch args.Action {
+	case "check":
+		problems, err := config.ValidateFile(configPath)
+		checkErr(err)
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", configPath, p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", configPath)
+	default:
+		checkErr(fmt.Errorf("unknown action %q", args.Action))
+	}
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/rpc"
+)
+
+var HolePunch = cmd.Sub{
+	Name:  "holepunch",
+	Alias: "hp",
+	Short: "Show attempts to get direct connections to relayed peers",
+	Run:   HolePunchRun,
+}
+
+func HolePunchRun(r *cmd.Root, c *cmd.Sub) {
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	reply := rpc.HolePunch(ifName)
+	for _, p := range reply.Peers {
+		state := "disconnected"
+		if p.IsRelay {
+			state = "relayed"
+		} else if p.IsConnected {
+			state = "direct"
+		}
+		fmt.Printf("@%s /p2p/%s %s\n", p.Name, p.ID, state)
+		if p.IsRelay && !p.NextRetry.IsZero() {
+			fmt.Printf("    next attempt in %s, %d failed so far\n", time.Until(p.NextRetry).Truncate(time.Second), p.Failures)
+		}
+		for _, a := range p.Attempts {
+			result := "ok"
+			if !a.Success {
+				result = "failed"
+				if a.Error != "" {
+					result += ": " + a.Error
+				}
+			}
+			fmt.Printf("    %s %s (%s) %s\n", a.Time.Format(time.DateTime), a.Kind, a.Duration.Truncate(time.Millisecond), result)
+		}
+	}
+}
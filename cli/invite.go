@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/rpc"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// InviteFlags contains flags for the invite command.
+type InviteFlags struct {
+	TTL     string `long:"ttl" desc:"How long the invite can be redeemed, 1h by default."`
+	Approve bool   `long:"approve" desc:"Hold the join until it is approved."`
+}
+
+// Invite creates a token for a new node to join the network.
+var Invite = cmd.Sub{
+	Name:  "invite",
+	Short: "Invite a new node to the network",
+	Flags: &InviteFlags{},
+	Run:   InviteRun,
+}
+
+// InviteRun handles the execution of the invite command.
+func InviteRun(r *cmd.Root, c *cmd.Sub) {
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	flags := c.Flags.(*InviteFlags)
+	ttl := time.Hour
+	if flags.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(flags.TTL)
+		checkErr(err)
+	}
+
+	reply := rpc.Invite(ifName, rpc.InviteArgs{TTL: ttl, Approve: flags.Approve})
+	fmt.Printf("Run this on the new node within %s:\n", ttl)
+	fmt.Printf("hyprspace join %s\n", reply.Token)
+	if flags.Approve {
+		fmt.Println("Then approve it with `hyprspace approve`.")
+	}
+}
+
+// ApproveFlags contains flags for the approve command.
+type ApproveFlags struct {
+	Deny bool `long:"deny" desc:"Deny the join instead."`
+}
+
+// ApproveArgs contains the arguments of the approve command.
+type ApproveArgs struct {
+	Peer []string `zero:"true" desc:"Peer ID of the joining node. Lists the waiting nodes if left out."`
+}
+
+// Approve decides on joins that wait for approval.
+var Approve = cmd.Sub{
+	Name:  "approve",
+	Short: "Approve or deny nodes joining with an invite",
+	Flags: &ApproveFlags{},
+	Args:  &ApproveArgs{},
+	Run:   ApproveRun,
+}
+
+// ApproveRun handles the execution of the approve command.
+func ApproveRun(r *cmd.Root, c *cmd.Sub) {
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	args := c.Args.(*ApproveArgs)
+	if len(args.Peer) == 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PEER\tNAME\tWAITING")
+		for _, req := range rpc.JoinRequests(ifName).Requests {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", req.ID, orDash(req.Name), time.Since(req.Requested).Truncate(time.Second))
+		}
+		w.Flush()
+		return
+	}
+
+	for _, p := range args.Peer {
+		id, err := peer.Decode(p)
+		checkErr(err)
+		rpc.DecideJoin(ifName, rpc.DecideJoinArgs{Peer: id, Approve: !c.Flags.(*ApproveFlags).Deny})
+	}
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/hyprspace/hyprspace/p2p"
+)
+
+// JoinArgs contains the arguments of the join command.
+type JoinArgs struct {
+	Token string `desc:"Invite created with hyprspace invite."`
+}
+
+// Join redeems an invite and adds the inviting node to the config.
+var Join = cmd.Sub{
+	Name:  "join",
+	Short: "Join a network with an invite",
+	Args:  &JoinArgs{},
+	Run:   JoinRun,
+}
+
+// JoinRun handles the execution of the join command.
+func JoinRun(r *cmd.Root, c *cmd.Sub) {
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	// Parse Global Config Flag for Custom Config Path
+	configPath := r.Flags.(*GlobalFlags).Config
+	if configPath == "" {
+		configPath = "/etc/hyprspace/" + ifName + ".json"
+	}
+
+	cfg, err := config.Read(configPath)
+	checkErr(err)
+
+	token := c.Args.(*JoinArgs).Token
+	_, inv, err := p2p.DecodeInvite(token)
+	checkErr(err)
+	if inv.Approve {
+		fmt.Println("Waiting for the join to be approved...")
+	}
+
+	id, name, err := p2p.Join(context.Background(), cfg, token)
+	checkErr(err)
+	fmt.Printf("Joined the network of @%s /p2p/%s\n", name, id)
+	reloadInterface(ifName)
+}
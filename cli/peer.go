@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/hyprspace/hyprspace/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Peer edits the peers in the config file.
+var Peer = cmd.Sub{
+	Name:  "peer",
+	Short: "Add, remove or rename peers in the config",
+	Args:  &PeerArgs{},
+	Run:   PeerRun,
+}
+
+// PeerArgs contains the arguments of the peer command.
+type PeerArgs struct {
+	Action string   `desc:"add <id> [name], remove <peer> or rename <peer> <name>"`
+	Args   []string `zero:"true" desc:"Peers are given as @name or a peer ID prefix."`
+}
+
+// PeerRun handles the execution of the peer command.
+func PeerRun(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*PeerArgs)
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	// Parse Global Config Flag for Custom Config Path
+	configPath := r.Flags.(*GlobalFlags).Config
+	if configPath == "" {
+		configPath = "/etc/hyprspace/" + ifName + ".json"
+	}
+
+	switch args.Action {
+	case "add":
+		if len(args.Args) < 1 || len(args.Args) > 2 {
+			checkErr(errors.New("expected a peer ID and an optional name"))
+		}
+		id, err := peer.Decode(args.Args[0])
+		checkErr(err)
+		entry := schema.ConfigPeersElem{Id: id.String()}
+		if len(args.Args) == 2 {
+			entry.Name = args.Args[1]
+		}
+		added, err := config.AddPeer(configPath, entry)
+		checkErr(err)
+		if !added {
+			fmt.Printf("/p2p/%s is a peer already\n", id)
+			return
+		}
+		fmt.Printf("Added /p2p/%s\n", id)
+	case "remove":
+		if len(args.Args) != 1 {
+			checkErr(errors.New("expected exactly 1 peer"))
+		}
+		p, err := config.RemovePeer(configPath, args.Args[0])
+		checkErr(err)
+		fmt.Printf("Removed @%s /p2p/%s\n", p.Name, p.ID)
+	case "rename":
+		if len(args.Args) != 2 {
+			checkErr(errors.New("expected a peer and its new name"))
+		}
+		p, err := config.RenamePeer(configPath, args.Args[0], args.Args[1])
+		checkErr(err)
+		fmt.Printf("Renamed /p2p/%s to @%s\n", p.ID, args.Args[1])
+	default:
+		checkErr(fmt.Errorf("unknown action %q", args.Action))
+	}
+	reloadInterface(ifName)
+}
@@ -2,14 +2,24 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/DataDrake/cli-ng/v2/cmd"
 	"github.com/hyprspace/hyprspace/rpc"
 )
 
+// PeersFlags contains flags for the peers command.
+type PeersFlags struct {
+	Network bool `short:"n" long:"network" desc:"Show the state of all VPN peers as announced in the network."`
+}
+
 var Peers = cmd.Sub{
 	Name:  "peers",
 	Short: "List peer connections",
+	Flags: &PeersFlags{},
 	Run:   PeersRun,
 }
 
@@ -19,8 +29,55 @@ func PeersRun(r *cmd.Root, c *cmd.Sub) {
 		ifName = "hyprspace"
 	}
 
+	if c.Flags.(*PeersFlags).Network {
+		printNetwork(rpc.Network(ifName))
+		return
+	}
+
 	peers := rpc.Peers(ifName)
 	for _, ma := range peers.PeerAddrs {
 		fmt.Println(ma)
 	}
 }
+
+func printNetwork(reply rpc.NetworkReply) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tNAME\tSTATUS\tVERSION\tLAST SEEN\tROUTES\tSERVICES")
+	for _, p := range reply.Peers {
+		name := p.Name
+		if p.AdvertisedName != "" && p.AdvertisedName != p.Name {
+			name = fmt.Sprintf("%s (%s)", name, p.AdvertisedName)
+		}
+		status := "unknown"
+		if p.HasState {
+			status = "offline"
+			if p.IsOnline {
+				status = "online"
+			}
+		}
+		if p.IsConnected {
+			status += ", connected"
+		}
+		lastSeen := "-"
+		if p.HasState {
+			lastSeen = time.Since(p.LastSeen).Truncate(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.ID,
+			orDash(name),
+			status,
+			orDash(p.Version),
+			lastSeen,
+			orDash(strings.Join(p.Routes, ",")),
+			orDash(strings.Join(p.Services, ",")),
+		)
+	}
+	w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
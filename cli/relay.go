@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	hsnode "github.com/hyprspace/hyprspace/node"
+	"github.com/hyprspace/hyprspace/p2p"
+	"github.com/ipfs/go-log/v2"
+)
+
+// Relay runs a node without an interface, as relay and bootstrap node.
+var Relay = cmd.Sub{
+	Name:  "relay",
+	Short: "Run a relay and bootstrap node without an interface.",
+	Run:   RelayRun,
+}
+
+// RelayRun handles the execution of the relay command.
+func RelayRun(r *cmd.Root, c *cmd.Sub) {
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	// Parse Global Config Flag for Custom Config Path
+	configPath := r.Flags.(*GlobalFlags).Config
+	if configPath == "" {
+		configPath = "/etc/hyprspace/" + ifName + ".json"
+	}
+
+	log.SetLogLevel("hyprspace", "info")
+	log.SetLogLevelRegex("^hyprspace/", "info")
+
+	p2p.Version = appVersion
+	relay := hsnode.NewRelay(context.Background(), configPath, ifName)
+	checkErr(relay.Run())
+
+	exitCh := make(chan os.Signal, 1)
+	rebootstrapCh := make(chan os.Signal, 1)
+	signal.Notify(exitCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	signal.Notify(rebootstrapCh, syscall.SIGUSR1)
+
+	for {
+		select {
+		case <-rebootstrapCh:
+			logger.Info("Rebootstrapping on SIGUSR1")
+			relay.Rebootstrap()
+		case <-exitCh:
+			logger.Info("Shutting down...")
+			go func() {
+				<-exitCh
+				logger.Fatal("Terminating immediately")
+			}()
+			checkErr(relay.Stop())
+			os.Exit(0)
+		}
+	}
+}
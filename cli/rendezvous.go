@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/p2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multibase"
+)
+
+// RendezvousServerFlags contains flags for the rendezvous-server command.
+type RendezvousServerFlags struct {
+	PrivateKeyFile string `long:"private-key-file" desc:"Path to the private key file, as written by keygen."`
+}
+
+// RendezvousServerArgs contains the arguments of the rendezvous-server command.
+type RendezvousServerArgs struct {
+	ListenAddresses []string `zero:"true" desc:"Addresses to listen on."`
+}
+
+// RendezvousServer runs a standalone rendezvous point.
+var RendezvousServer = cmd.Sub{
+	Name:  "rendezvous-server",
+	Short: "Run a rendezvous point for other nodes",
+	Flags: &RendezvousServerFlags{},
+	Args:  &RendezvousServerArgs{},
+	Run:   RendezvousServerRun,
+}
+
+var defaultRendezvousListenAddrs = []string{
+	"/ip4/0.0.0.0/tcp/8002",
+	"/ip4/0.0.0.0/udp/8002/quic-v1",
+	"/ip6/::/tcp/8002",
+	"/ip6/::/udp/8002/quic-v1",
+}
+
+func RendezvousServerRun(r *cmd.Root, c *cmd.Sub) {
+	flags := c.Flags.(*RendezvousServerFlags)
+	args := c.Args.(*RendezvousServerArgs)
+
+	if flags.PrivateKeyFile == "" {
+		log.Fatal("--private-key-file is required")
+	}
+	encoded, err := os.ReadFile(flags.PrivateKeyFile)
+	checkErr(err)
+	_, keyBytes, err := multibase.Decode(strings.TrimSpace(string(encoded)))
+	checkErr(err)
+	key, err := crypto.UnmarshalPrivateKey(keyBytes)
+	checkErr(err)
+
+	listen := args.ListenAddresses
+	if len(listen) == 0 {
+		listen = defaultRendezvousListenAddrs
+	}
+	var listenAddrs []multiaddr.Multiaddr
+	for _, s := range listen {
+		addr, err := multiaddr.NewMultiaddr(s)
+		checkErr(err)
+		listenAddrs = append(listenAddrs, addr)
+	}
+
+	h, err := p2p.NewRendezvousHost(key, listenAddrs)
+	checkErr(err)
+	defer h.Close()
+
+	fmt.Println("Rendezvous point listening on:")
+	for _, addr := range h.Addrs() {
+		fmt.Printf("  %s/p2p/%s\n", addr, h.ID())
+	}
+
+	exitCh := make(chan os.Signal, 1)
+	signal.Notify(exitCh, syscall.SIGINT, syscall.SIGTERM)
+	<-exitCh
+}
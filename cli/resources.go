@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/rpc"
+)
+
+var Resources = cmd.Sub{
+	Name:  "resources",
+	Short: "Show the resource usage of libp2p",
+	Run:   ResourcesRun,
+}
+
+func ResourcesRun(r *cmd.Root, c *cmd.Sub) {
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	reply := rpc.Resources(ifName)
+	fmt.Printf("Connections: %d (trimmed from %d down to %d)\n", reply.Conns, reply.HighWater, reply.LowWater)
+	for _, s := range reply.Scopes {
+		fmt.Printf("%s\n", s.Name)
+		fmt.Printf("    streams in %d/%d, out %d/%d\n",
+			s.Stat.NumStreamsInbound, s.Limit.StreamsInbound,
+			s.Stat.NumStreamsOutbound, s.Limit.StreamsOutbound)
+		if s.Limit.Conns > 0 {
+			fmt.Printf("    connections in %d/%d, out %d/%d\n",
+				s.Stat.NumConnsInbound, s.Limit.ConnsInbound,
+				s.Stat.NumConnsOutbound, s.Limit.ConnsOutbound)
+		}
+		if s.Limit.FD > 0 {
+			fmt.Printf("    file descriptors %d/%d\n", s.Stat.NumFD, s.Limit.FD)
+		}
+		fmt.Printf("    memory %.1f/%.1f MiB\n", float64(s.Stat.Memory)/(1<<20), float64(s.Limit.Memory)/(1<<20))
+	}
+}
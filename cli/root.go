@@ -31,10 +31,20 @@ func init() {
 	cmd.Register(&cmd.Help)
 	cmd.Register(&Init)
 	cmd.Register(&Keygen)
+	cmd.Register(&Invite)
+	cmd.Register(&Join)
+	cmd.Register(&Approve)
 	cmd.Register(&Up)
+	cmd.Register(&Relay)
 	cmd.Register(&Status)
 	cmd.Register(&Peers)
+	cmd.Register(&Peer)
+	cmd.Register(&Service)
 	cmd.Register(&Route)
+	cmd.Register(&Config)
+	cmd.Register(&HolePunch)
+	cmd.Register(&Resources)
+	cmd.Register(&RendezvousServer)
 	cmd.Register(&cmd.Version)
 }
 
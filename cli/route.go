@@ -1,9 +1,12 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
+	"net"
 
 	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/config"
 	"github.com/hyprspace/hyprspace/rpc"
 )
 
@@ -11,10 +14,16 @@ var Route = cmd.Sub{
 	Name:  "route",
 	Alias: "r",
 	Short: "Control routing",
+	Flags: &RouteFlags{},
 	Args:  &RouteArgs{},
 	Run:   RouteRun,
 }
 
+// RouteFlags contains flags for the route command.
+type RouteFlags struct {
+	Persist bool `long:"persist" desc:"Also add or delete the route in the config file."`
+}
+
 type RouteArgs struct {
 	Action string
 	Args   []string `zero:"true"`
@@ -29,6 +38,18 @@ func RouteRun(r *cmd.Root, c *cmd.Sub) {
 	}
 
 	action := rpc.RouteAction(args.Action)
+	if c.Flags.(*RouteFlags).Persist && action != rpc.Show {
+		// Parse Global Config Flag for Custom Config Path
+		configPath := r.Flags.(*GlobalFlags).Config
+		if configPath == "" {
+			configPath = "/etc/hyprspace/" + ifName + ".json"
+		}
+		checkErr(persistRoute(configPath, action, args.Args))
+		if !rpc.Running(ifName) {
+			fmt.Printf("Interface %s isn't up, the route applies once it is.\n", ifName)
+			return
+		}
+	}
 	rArgs := rpc.RouteArgs{
 		Action: action,
 		Args:   args.Args,
@@ -51,3 +72,31 @@ func RouteRun(r *cmd.Root, c *cmd.Sub) {
 		fmt.Printf("%s via %s%s\n", &r.Network, target, connectStatus)
 	}
 }
+
+// persistRoute makes the same change to the config file that the daemon
+// makes to its route table.
+func persistRoute(configPath string, action rpc.RouteAction, args []string) error {
+	switch action {
+	case rpc.Add:
+		if len(args) != 2 {
+			return errors.New("expected exactly 2 arguments")
+		}
+		_, network, err := net.ParseCIDR(args[0])
+		if err != nil {
+			return err
+		}
+		_, err = config.AddRoute(configPath, args[1], *network)
+		return err
+	case rpc.Del:
+		if len(args) != 1 {
+			return errors.New("expected exactly 1 argument")
+		}
+		_, network, err := net.ParseCIDR(args[0])
+		if err != nil {
+			return err
+		}
+		return config.RemoveRoute(configPath, *network)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
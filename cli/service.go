@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/DataDrake/cli-ng/v2/cmd"
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Service edits the services in the config file.
+var Service = cmd.Sub{
+	Name:  "service",
+	Short: "Add or remove services in the config",
+	Args:  &ServiceArgs{},
+	Run:   ServiceRun,
+}
+
+// ServiceArgs contains the arguments of the service command.
+type ServiceArgs struct {
+	Action string   `desc:"add <name> <target> or remove <name>"`
+	Args   []string `zero:"true" desc:"Targets are multiaddrs like /tcp/8080."`
+}
+
+// ServiceRun handles the execution of the service command.
+func ServiceRun(r *cmd.Root, c *cmd.Sub) {
+	args := c.Args.(*ServiceArgs)
+	ifName := r.Flags.(*GlobalFlags).InterfaceName
+	if ifName == "" {
+		ifName = "hyprspace"
+	}
+
+	// Parse Global Config Flag for Custom Config Path
+	configPath := r.Flags.(*GlobalFlags).Config
+	if configPath == "" {
+		configPath = "/etc/hyprspace/" + ifName + ".json"
+	}
+
+	switch args.Action {
+	case "add":
+		if len(args.Args) != 2 {
+			checkErr(errors.New("expected a name and a target"))
+		}
+		target, err := multiaddr.NewMultiaddr(args.Args[1])
+		checkErr(err)
+		checkErr(config.AddService(configPath, args.Args[0], target))
+		fmt.Printf("Service %s points to %s\n", args.Args[0], target)
+	case "remove":
+		if len(args.Args) != 1 {
+			checkErr(errors.New("expected exactly 1 service"))
+		}
+		checkErr(config.RemoveService(configPath, args.Args[0]))
+		fmt.Printf("Removed service %s\n", args.Args[0])
+	default:
+		checkErr(fmt.Errorf("unknown action %q", args.Action))
+	}
+	reloadInterface(ifName)
+}
@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/DataDrake/cli-ng/v2/cmd"
 	"github.com/hyprspace/hyprspace/rpc"
@@ -38,6 +39,28 @@ func StatusRun(r *cmd.Root, c *cmd.Sub) {
 	fmt.Println("Swarm peers:", status.SwarmPeersCurrent)
 	fmt.Printf("Connected VPN nodes: %d/%d\n", status.NetPeersCurrent, status.NetPeersMax)
 	printListF(status.NetPeerAddrsCurrent, maybeColorMultiaddr)
+	var unreachable []string
+	for _, p := range rpc.Discovery(ifName).Peers {
+		if p.State == "connected" {
+			continue
+		}
+		line := fmt.Sprintf("@%s /p2p/%s %s", p.Name, p.ID, p.State)
+		if p.State == "backoff" {
+			line += fmt.Sprintf(", retry in %s", time.Until(p.NextRetry).Truncate(time.Second))
+		}
+		if p.LastError != "" {
+			line += fmt.Sprintf(" (%d failed, last error %s ago: %s)", p.Failures, time.Since(p.LastErrorTime).Truncate(time.Second), p.LastError)
+		}
+		unreachable = append(unreachable, line)
+	}
+	if len(unreachable) > 0 {
+		fmt.Println("Unreachable VPN nodes:")
+		printListF(unreachable, func(s string) string { return s })
+	}
 	fmt.Println("Addresses:")
 	printListF(status.ListenAddrs, maybeColorMultiaddr)
+	if len(status.Relays) > 0 {
+		fmt.Println("Relays:")
+		printListF(status.Relays, func(s string) string { return s })
+	}
 }
@@ -2,12 +2,14 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/DataDrake/cli-ng/v2/cmd"
 	hsnode "github.com/hyprspace/hyprspace/node"
+	"github.com/hyprspace/hyprspace/p2p"
 	"github.com/ipfs/go-log/v2"
 )
 
@@ -17,28 +19,27 @@ var logger = log.Logger("hyprspace")
 var Up = cmd.Sub{
 	Name:  "up",
 	Alias: "up",
-	Short: "Create and Bring Up a Hyprspace Interface.",
+	Short: "Create and Bring Up Hyprspace Interfaces.",
+	Args:  &UpArgs{},
 	Run:   UpRun,
 }
 
+// UpArgs contains the arguments of the up command.
+type UpArgs struct {
+	Interfaces []string `zero:"true" desc:"Interfaces to bring up in one process, each with its config in /etc/hyprspace."`
+}
+
 // UpRun handles the execution of the up command.
 func UpRun(r *cmd.Root, c *cmd.Sub) {
-	ifName := r.Flags.(*GlobalFlags).InterfaceName
-	if ifName == "" {
-		ifName = "hyprspace"
-	}
-
-	// Parse Global Config Flag for Custom Config Path
-	configPath := r.Flags.(*GlobalFlags).Config
-	if configPath == "" {
-		configPath = "/etc/hyprspace/" + ifName + ".json"
-	}
+	networks, err := upNetworks(r.Flags.(*GlobalFlags), c.Args.(*UpArgs).Interfaces)
+	checkErr(err)
 
 	log.SetLogLevel("hyprspace", "info")
 	log.SetLogLevelRegex("^hyprspace/", "info")
 
-	node := hsnode.New(context.Background(), configPath, ifName)
-	checkErr(node.Run())
+	p2p.Version = appVersion
+	daemon := hsnode.NewDaemon(context.Background(), networks)
+	checkErr(daemon.Run())
 	logger.Info("Node ready")
 
 	exitCh := make(chan os.Signal, 1)
@@ -50,15 +51,48 @@ func UpRun(r *cmd.Root, c *cmd.Sub) {
 		select {
 		case <-rebootstrapCh:
 			logger.Info("Rebootstrapping on SIGUSR1")
-			node.Rebootstrap()
+			daemon.Rebootstrap()
 		case <-exitCh:
 			logger.Info("Shutting down...")
 			go func() {
 				<-exitCh
 				logger.Fatal("Terminating immediately")
 			}()
-			checkErr(node.Stop())
+			checkErr(daemon.Stop())
 			os.Exit(0)
 		}
 	}
 }
+
+// upNetworks returns the networks to bring up: those named as arguments, or
+// else the one of the interface flag.
+func upNetworks(flags *GlobalFlags, ifNames []string) ([]hsnode.Network, error) {
+	if len(ifNames) == 0 {
+		ifName := flags.InterfaceName
+		if ifName == "" {
+			ifName = "hyprspace"
+		}
+		ifNames = []string{ifName}
+	} else if flags.InterfaceName != "" {
+		return nil, errors.New("interfaces can't be given both as arguments and with -i")
+	}
+	if flags.Config != "" && len(ifNames) > 1 {
+		return nil, errors.New("a custom config path can only be given for a single interface")
+	}
+
+	var networks []hsnode.Network
+	for _, ifName := range ifNames {
+		// Parse Global Config Flag for Custom Config Path
+		configPath := flags.Config
+		if configPath == "" {
+			configPath = "/etc/hyprspace/" + ifName + ".json"
+		}
+		for _, n := range networks {
+			if n.Interface == ifName {
+				return nil, errors.New("interface " + ifName + " is given more than once")
+			}
+		}
+		networks = append(networks, hsnode.Network{Interface: ifName, ConfigPath: configPath})
+	}
+	return networks, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/hyprspace/hyprspace/rpc"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/mattn/go-isatty"
@@ -39,3 +40,14 @@ func GenerateKeyPair() ([]byte, peer.ID, error) {
 
 	return keyBytes, peerId, nil
 }
+
+// reloadInterface makes a running daemon pick up the changes to its config.
+// The network restarts for that, which interrupts the tunnels to all peers.
+func reloadInterface(ifName string) {
+	if !rpc.Running(ifName) {
+		fmt.Printf("Interface %s isn't up, the changes apply once it is.\n", ifName)
+		return
+	}
+	rpc.Reload(ifName)
+	fmt.Printf("Restarting interface %s, its connections are interrupted until the peers are found again\n", ifName)
+}
@@ -1,13 +1,14 @@
 package config
 
 import (
-	"encoding/json"
-	"errors"
+	"crypto/tls"
 	"fmt"
-	"log"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/hyprspace/hyprspace/schema"
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -19,30 +20,154 @@ import (
 
 // Config is the main Configuration Struct for Hyprspace.
 type Config struct {
-	Path                   string                `json:"-"`
-	Interface              string                `json:"-"`
-	ListenAddresses        []multiaddr.Multiaddr `json:"-"`
-	BootstrapPeers         []multiaddr.Multiaddr `json:"-"`
-	Peers                  []Peer                `json:"peers"`
-	PeerLookup             PeerLookup            `json:"-"`
-	PrivateKey             crypto.PrivKey        `json:"-"`
-	BuiltinAddr4           net.IP                `json:"-"`
-	BuiltinAddr6           net.IP                `json:"-"`
-	Services               map[string]Service    `json:"-"`
-	FilterPrivateAddresses bool                  `json:"-"`
-	Domain                 string                `json:"-"`
+	Path            string                `json:"-"`
+	Interface       string                `json:"-"`
+	ListenAddresses []multiaddr.Multiaddr `json:"-"`
+	BootstrapPeers  []multiaddr.Multiaddr `json:"-"`
+	Peers           []Peer                `json:"peers"`
+	PeerLookup      PeerLookup            `json:"-"`
+	PrivateKey      crypto.PrivKey        `json:"-"`
+	BuiltinAddr4    net.IP                `json:"-"`
+	BuiltinAddr6    net.IP                `json:"-"`
+	Prefixes        Prefixes              `json:"-"`
+	Services        map[string]Service    `json:"-"`
+	AddressFilters  AddressFilters        `json:"-"`
+	MembersOnly     bool                  `json:"-"`
+	ShareHost       bool                  `json:"-"`
+	MDNS            bool                  `json:"-"`
+	Domain          string                `json:"-"`
+	Name            string                `json:"-"`
+	AdvertiseRoutes []net.IPNet           `json:"-"`
+	Relays          []peer.AddrInfo       `json:"-"`
+	RelayPolicy     RelayPolicy           `json:"-"`
+	RelayService    RelayService          `json:"-"`
+	TLSCertificates []tls.Certificate     `json:"-"`
+	StateFile       string                `json:"-"`
+	Routing         Routing               `json:"-"`
+	Rendezvous      Rendezvous            `json:"-"`
+	Resources       Resources             `json:"-"`
+	ConnManager     ConnManager           `json:"-"`
 }
 
+// Resources holds the limits of the libp2p resource manager. Zero values
+// leave the limit to libp2p.
+type Resources struct {
+	MaxMemory          int64
+	MaxFileDescriptors int
+	Streams            StreamLimits
+}
+
+// StreamLimits caps the open streams of each hyprspace protocol.
+type StreamLimits struct {
+	Hyprspace int
+	PeX       int
+	Service   int
+}
+
+// ConnManager holds the watermarks of the libp2p connection manager.
+type ConnManager struct {
+	LowWater    int
+	HighWater   int
+	GracePeriod time.Duration
+}
+
+// AddressFilters holds the networks that are neither dialed, accepted nor
+// announced. Allowed networks take precedence over denied ones.
+type AddressFilters struct {
+	Deny  []net.IPNet
+	Allow []net.IPNet
+}
+
+// privateNetworks are denied by filterPrivateAddresses.
+var privateNetworks = []string{
+	// IPv4 local
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	// IPv4 link-local
+	"169.254.0.0/16",
+	// IPv4 loopback
+	"127.0.0.0/8",
+	// IPv6 link-local
+	"fe80::/10",
+	// IPv6 loopback
+	"::1/128",
+}
+
+// Rendezvous configures registering with and serving rendezvous points.
+type Rendezvous struct {
+	Points    []peer.AddrInfo
+	Namespace string
+	Serve     bool
+}
+
+// DHTMode selects the DHT used to look up peers.
+type DHTMode string
+
+const (
+	// The public IPFS DHT.
+	DHTPublic DHTMode = "public"
+	// A DHT that only spans VPN peers.
+	DHTPrivate DHTMode = "private"
+	// No DHT at all.
+	DHTNone DHTMode = "none"
+)
+
+// Routing selects the backends used to find the addresses of peers.
+type Routing struct {
+	DHT       DHTMode
+	DHTServer bool
+	DHTPrefix string
+	Delegated []string
+}
+
+// RelayService holds the limits of the circuit relay service this node
+// provides to VPN peers. A zero connection duration or data limit is
+// unlimited, while zero reservations or circuits refuse all relaying.
+type RelayService struct {
+	Strict             bool
+	MaxReservations    int
+	MaxCircuits        int
+	ConnectionDuration time.Duration
+	ConnectionData     int64
+}
+
+// RelayPolicy selects which nodes AutoRelay may use as relays.
+type RelayPolicy string
+
+const (
+	// Any connected libp2p node, after the static relays.
+	RelayPolicyAny RelayPolicy = "any"
+	// Connected VPN peers, after the static relays.
+	RelayPolicyVPN RelayPolicy = "vpn"
+	// Only the static relays.
+	RelayPolicyStatic RelayPolicy = "static"
+)
+
 // Peer defines a peer in the configuration. We might add more to this later.
 type Peer struct {
-	ID           peer.ID `json:"id"`
-	Name         string  `json:"name"`
-	BuiltinAddr4 net.IP  `json:"-"`
-	BuiltinAddr6 net.IP  `json:"-"`
+	ID             peer.ID               `json:"id"`
+	Name           string                `json:"name"`
+	Addrs          []multiaddr.Multiaddr `json:"-"`
+	BuiltinAddr4   net.IP                `json:"-"`
+	BuiltinAddr6   net.IP                `json:"-"`
+	AllowRelay     bool                  `json:"-"`
+	AllowedSources []net.IPNet           `json:"-"`
+}
+
+// NetID identifies this node in the service network.
+func (cfg *Config) NetID() [4]byte {
+	return [4]byte(cfg.BuiltinAddr6[12:16])
+}
+
+// NetID identifies the peer in the service network.
+func (p Peer) NetID() [4]byte {
+	return [4]byte(p.BuiltinAddr6[12:16])
 }
 
 // PeerLookup is a helper struct for quickly looking up a peer based on various parameters
 type PeerLookup struct {
+	ByID    map[peer.ID]Peer
 	ByRoute cidranger.Ranger
 	ByName  map[string]Peer
 	ByNetID map[[4]byte]Peer
@@ -68,75 +193,487 @@ func (rte RouteTableEntry) Network() net.IPNet {
 	return rte.Net
 }
 
-// Read initializes a config from a file.
+// addrClaims finds nodes with the same builtin addresses or network IDs.
+// Derived addresses only have 16 bits for IPv4 and 32 bits for IPv6, so they
+// can collide. Nodes are named by the path of their settings, this node by "".
+type addrClaims map[string]string
+
+// claim records key, an address or network ID, as that of the node at path.
+// It returns the node that has it already, if any.
+func (ac addrClaims) claim(key string, path string) (string, bool) {
+	if other, taken := ac[key]; taken {
+		if other == "" {
+			other = "this node"
+		}
+		return other, true
+	}
+	ac[key] = path
+	return "", false
+}
+
+// builtinAddrs returns the builtin addresses of the node at path, as set in
+// its config or derived from its ID, and claims them. Addresses that are
+// invalid are nil.
+func (px Prefixes) builtinAddrs(problems *Problems, claims addrClaims, path string, id peer.ID, addr4 string, addr6 string) (net.IP, net.IP) {
+	var ip4, ip6 net.IP
+	if id != "" {
+		ip4, ip6 = px.builtinAddr4(id), px.builtinAddr6(id)
+	}
+	for _, a := range []struct {
+		key   string
+		set   string
+		ip    *net.IP
+		parse func(string) (net.IP, error)
+	}{
+		{"address4", addr4, &ip4, px.parseBuiltinAddr4},
+		{"address6", addr6, &ip6, px.parseBuiltinAddr6},
+	} {
+		keyPath := strings.TrimPrefix(path+"."+a.key, ".")
+		if a.set != "" {
+			ip, err := a.parse(a.set)
+			if err != nil {
+				problems.add(keyPath, "%s", err)
+			}
+			*a.ip = ip
+		}
+		if *a.ip == nil {
+			continue
+		}
+		if a.set == "" {
+			keyPath = path
+			if a.key == "address4" {
+				if reserved := reservedAddr4(px.IPv4, *a.ip); reserved != "" {
+					problems.add(keyPath, "address %s is the %s address of %s, set %s", *a.ip, reserved, &px.IPv4, a.key)
+				}
+			}
+		}
+		if other, taken := claims.claim(a.ip.String(), path); taken {
+			problems.add(keyPath, "address %s collides with %s, set %s for one of them", *a.ip, other, a.key)
+		} else if a.key == "address6" {
+			// The service network tells nodes apart by the last 4 bytes of
+			// their IPv6 address alone.
+			netID := []byte((*a.ip)[12:])
+			if other, taken := claims.claim(fmt.Sprintf("netid %x", netID), path); taken {
+				problems.add(keyPath, "network ID %x of address %s collides with %s, set %s for one of them", netID, *a.ip, other, a.key)
+			}
+		}
+	}
+	return ip4, ip6
+}
+
+// parsePrefixes parses the address prefixes of the network. Those with
+// problems are left at their defaults, to check addresses against.
+func parsePrefixes(problems *Problems, input *schema.ConfigPrefixes) Prefixes {
+	px := DefaultPrefixes
+	if input == nil {
+		return px
+	}
+	for _, p := range []struct {
+		key    string
+		set    string
+		prefix *net.IPNet
+		ipv6   bool
+		maxLen int
+	}{
+		{"ipv4", input.Ipv4, &px.IPv4, false, maxPrefixLen4},
+		{"ipv6", input.Ipv6, &px.IPv6, true, maxPrefixLen6},
+		{"service", input.Service, &px.Service, true, maxPrefixLenService},
+	} {
+		if network, err := parsePrefix(p.set, p.ipv6, p.maxLen); err != nil {
+			problems.add("prefixes."+p.key, "%s", err)
+		} else {
+			*p.prefix = network
+		}
+	}
+	if overlap(px.IPv6, px.Service) {
+		problems.add("prefixes.service", "overlaps prefixes.ipv6")
+	}
+	return px
+}
+
+// parseMultiaddrs parses the list of multiaddrs at path, which must include a
+// peer ID if withID is set. Invalid ones are left out.
+func parseMultiaddrs(problems *Problems, path string, addrs []string, withID bool) []multiaddr.Multiaddr {
+	var result []multiaddr.Multiaddr
+	for i, s := range addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			problems.add(fmt.Sprintf("%s[%d]", path, i), "%s", err)
+		} else if _, id := peer.SplitAddr(addr); withID && id == "" {
+			problems.add(fmt.Sprintf("%s[%d]", path, i), "has no peer ID")
+		} else {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+// parseNetworks parses the list of networks in CIDR notation at path. Invalid
+// ones are left out.
+func parseNetworks(problems *Problems, path string, networks []string) []net.IPNet {
+	var result []net.IPNet
+	for i, s := range networks {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			problems.add(fmt.Sprintf("%s[%d]", path, i), "%s", err)
+			continue
+		}
+		result = append(result, *network)
+	}
+	return result
+}
+
+// parsePeerRefs resolves the list of peer references at path, each of which
+// must name exactly one configured peer.
+func (cfg *Config) parsePeerRefs(problems *Problems, path string, refs []string) map[peer.ID]struct{} {
+	result := make(map[peer.ID]struct{})
+	for i, ref := range refs {
+		p, err := cfg.PeerByCLIRef(ref)
+		if err != nil {
+			problems.add(fmt.Sprintf("%s[%d]", path, i), "%s", err)
+		} else if p == nil {
+			problems.add(fmt.Sprintf("%s[%d]", path, i), "unknown peer %s", ref)
+		} else {
+			result[p.ID] = struct{}{}
+		}
+	}
+	return result
+}
+
+// Read initializes a config from a file. If the config has mistakes, the
+// error is the Problems found, all of them at once.
 func Read(path string) (*Config, error) {
 	in, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	input := schema.Config{}
+	result, problems := parse(in, path)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	for _, r := range result.peerRoutes() {
+		fmt.Printf("[+] Route %s via /p2p/%s\n", r.Net.String(), r.Target.ID)
+	}
+	return result, nil
+}
+
+// peerRoutes returns the routes to peers set in the config, leaving out
+// those to their builtin addresses.
+func (cfg *Config) peerRoutes() []*RouteTableEntry {
+	var routes []*RouteTableEntry
+	for _, all := range []*net.IPNet{cidranger.AllIPv4, cidranger.AllIPv6} {
+		entries, _ := cfg.PeerLookup.ByRoute.CoveredNetworks(*all)
+		for _, e := range entries {
+			r := e.(*RouteTableEntry)
+			if ones, bits := r.Net.Mask.Size(); ones == bits && (r.Net.IP.Equal(r.Target.BuiltinAddr4) || r.Net.IP.Equal(r.Target.BuiltinAddr6)) {
+				continue
+			}
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// parse decodes the config in data, read from path, and checks it. The config
+// is only complete if there are no problems.
+func parse(data []byte, path string) (*Config, Problems) {
+	input, problems := decode(data)
+	if input == nil {
+		return nil, problems
+	}
+	result := build(&problems, input, path)
+	return result, problems
+}
+
+// build turns the decoded config into a Config, checking the settings that
+// the schema can't express.
+func build(problems *Problems, input *schema.Config, path string) *Config {
 	result := Config{}
 
-	// Read in config settings from file.
-	err = json.Unmarshal(in, &input)
-	if err != nil {
-		return nil, err
+	var self peer.ID
+	if _, keyBytes, err := multibase.Decode(input.PrivateKey); err != nil {
+		problems.add("privateKey", "%s", err)
+	} else if pk, err := crypto.UnmarshalPrivateKey(keyBytes); err != nil {
+		problems.add("privateKey", "%s", err)
+	} else {
+		result.PrivateKey = pk
+		self, _ = peer.IDFromPrivateKey(pk)
 	}
 
-	_, keyBytes, err := multibase.Decode(input.PrivateKey)
-	if err != nil {
-		return nil, err
+	result.ListenAddresses = parseMultiaddrs(problems, "listenAddresses", input.ListenAddresses, false)
+	result.BootstrapPeers = parseMultiaddrs(problems, "bootstrapPeers", input.BootstrapPeers, false)
+	// Only addresses with a peer ID are left, so grouping them can't fail.
+	result.Relays, _ = addrInfosInOrder(parseMultiaddrs(problems, "relays", input.Relays, true))
+	result.RelayPolicy = RelayPolicy(input.RelayPolicy)
+	if result.RelayPolicy == "" {
+		result.RelayPolicy = RelayPolicyAny
+	}
+	if result.RelayPolicy == RelayPolicyStatic && len(result.Relays) == 0 {
+		problems.add("relayPolicy", "relay policy \"static\" requires at least one relay")
 	}
 
-	pk, err := crypto.UnmarshalPrivateKey(keyBytes)
-	if err != nil {
-		return nil, err
+	result.Prefixes = parsePrefixes(problems, input.Prefixes)
+	claims := make(addrClaims)
+	result.BuiltinAddr4, result.BuiltinAddr6 = result.Prefixes.builtinAddrs(problems, claims, "", self, input.Address4, input.Address6)
+	buildPeers(problems, &result, input.Peers, self, claims)
+
+	result.Services = make(map[string]Service)
+	for _, name := range sortedKeys(input.Services) {
+		service := input.Services[name]
+		path := "services." + name
+		addr, err := multiaddr.NewMultiaddr(service.Target)
+		if err != nil {
+			problems.add(path+".target", "%s", err)
+		}
+		acl := service.Acl
+		if acl == nil {
+			acl = &schema.ConfigServicesValueAcl{}
+		}
+		result.Services[name] = Service{
+			Target:          addr,
+			EnableWhitelist: acl.EnableWhitelist,
+			Whitelist:       result.parsePeerRefs(problems, path+".acl.whitelist", acl.Whitelist),
+			Blacklist:       result.parsePeerRefs(problems, path+".acl.blacklist", acl.Blacklist),
+		}
 	}
 
-	result.PrivateKey = pk
+	if input.FilterPrivateAddresses {
+		for _, f := range privateNetworks {
+			network, _ := parseAddrFilter(f)
+			result.AddressFilters.Deny = append(result.AddressFilters.Deny, network)
+		}
+	}
+	if af := input.AddressFilters; af != nil {
+		for i, f := range af.Deny {
+			if network, err := parseAddrFilter(f); err != nil {
+				problems.add(fmt.Sprintf("addressFilters.deny[%d]", i), "%s", err)
+			} else {
+				result.AddressFilters.Deny = append(result.AddressFilters.Deny, network)
+			}
+		}
+		for i, f := range af.Allow {
+			if network, err := parseAddrFilter(f); err != nil {
+				problems.add(fmt.Sprintf("addressFilters.allow[%d]", i), "%s", err)
+			} else {
+				result.AddressFilters.Allow = append(result.AddressFilters.Allow, network)
+			}
+		}
+	}
 
-	peerID, err := peer.IDFromPrivateKey(result.PrivateKey)
-	if err != nil {
-		return nil, err
+	result.MDNS = !input.FilterPrivateAddresses
+	if input.Mdns != nil {
+		result.MDNS = *input.Mdns
 	}
 
-	result.BuiltinAddr4 = mkBuiltinAddr4(peerID)
-	result.BuiltinAddr6 = mkBuiltinAddr6(peerID)
+	result.Domain = input.Domain
+	if result.Domain == "" {
+		result.Domain = "hyprspace"
+	}
 
-	for _, addrString := range input.ListenAddresses {
-		addr, err := multiaddr.NewMultiaddr(addrString)
+	result.Name = input.Name
+	if result.Name == "" {
+		result.Name, _ = os.Hostname()
+	}
+
+	result.AdvertiseRoutes = parseNetworks(problems, "advertiseRoutes", input.AdvertiseRoutes)
+
+	if (input.TlsCertificateFile == "") != (input.TlsKeyFile == "") {
+		problems.add("tlsKeyFile", "tlsCertificateFile and tlsKeyFile must be set together")
+	} else if input.TlsCertificateFile != "" {
+		cert, err := tls.LoadX509KeyPair(input.TlsCertificateFile, input.TlsKeyFile)
 		if err != nil {
-			return nil, err
+			problems.add("tlsCertificateFile", "%s", err)
+		}
+		result.TLSCertificates = []tls.Certificate{cert}
+	}
+
+	result.RelayService = RelayService{
+		MaxReservations: 128,
+		MaxCircuits:     16,
+	}
+	if rs := input.RelayService; rs != nil {
+		result.RelayService = RelayService{
+			Strict:             rs.Strict,
+			MaxReservations:    rs.MaxReservations,
+			MaxCircuits:        rs.MaxCircuits,
+			ConnectionDuration: time.Duration(rs.ConnectionDuration) * time.Second,
+			ConnectionData:     int64(rs.ConnectionData),
 		}
-		result.ListenAddresses = append(result.ListenAddresses, addr)
 	}
 
+	result.Routing = Routing{
+		DHT:       DHTPublic,
+		DHTPrefix: "/hyprspace",
+		Delegated: []string{"https://p2p.privatevoid.net"},
+	}
+	if r := input.Routing; r != nil {
+		result.Routing = Routing{
+			DHT:       DHTMode(r.Dht),
+			DHTServer: r.DhtServer,
+			DHTPrefix: r.DhtPrefix,
+			Delegated: r.Delegated,
+		}
+	}
+	if result.Routing.DHT == DHTPrivate {
+		if !strings.HasPrefix(result.Routing.DHTPrefix, "/") || result.Routing.DHTPrefix == "/ipfs" {
+			problems.add("routing.dhtPrefix", "invalid private DHT prefix %q", result.Routing.DHTPrefix)
+		}
+	} else if result.Routing.DHTServer && result.Routing.DHT == DHTNone {
+		problems.add("routing.dhtServer", "dhtServer requires a DHT")
+	}
+	for i, endpoint := range result.Routing.Delegated {
+		if u, err := url.Parse(endpoint); err != nil {
+			problems.add(fmt.Sprintf("routing.delegated[%d]", i), "%s", err)
+		} else if u.Scheme != "https" && u.Scheme != "http" {
+			problems.add(fmt.Sprintf("routing.delegated[%d]", i), "%s is not an HTTP URL", endpoint)
+		}
+	}
+	result.MembersOnly = input.MembersOnly
+	result.ShareHost = input.ShareHost
+	if result.MembersOnly && result.Routing.DHT == DHTPublic {
+		problems.add("membersOnly", "membersOnly requires the private DHT or none")
+	}
+
+	if rv := input.Rendezvous; rv != nil {
+		result.Rendezvous.Points, _ = addrInfosInOrder(parseMultiaddrs(problems, "rendezvous.points", rv.Points, true))
+		result.Rendezvous.Namespace = rv.Namespace
+		result.Rendezvous.Serve = rv.Serve
+	}
+	if result.Rendezvous.Namespace == "" {
+		result.Rendezvous.Namespace = MkNetworkNamespace(result.Prefixes, result.Routing.DHTPrefix)
+	}
+
+	if res := input.Resources; res != nil {
+		result.Resources = Resources{
+			MaxMemory:          int64(res.MaxMemory) << 20,
+			MaxFileDescriptors: res.MaxFileDescriptors,
+		}
+		if st := res.Streams; st != nil {
+			result.Resources.Streams = StreamLimits{
+				Hyprspace: st.Hyprspace,
+				PeX:       st.Pex,
+				Service:   st.Service,
+			}
+		}
+	}
+
+	result.ConnManager = ConnManager{
+		LowWater:    160,
+		HighWater:   192,
+		GracePeriod: time.Minute,
+	}
+	if cm := input.ConnectionManager; cm != nil {
+		result.ConnManager = ConnManager{
+			LowWater:    cm.LowWater,
+			HighWater:   cm.HighWater,
+			GracePeriod: time.Duration(cm.GracePeriod) * time.Second,
+		}
+	}
+	if result.ConnManager.LowWater > result.ConnManager.HighWater {
+		problems.add("connectionManager.lowWater", "must not be above highWater")
+	}
+
+	// Remember peer addresses next to the config by default.
+	result.StateFile = input.StateFile
+	if result.StateFile == "" {
+		result.StateFile = strings.TrimSuffix(path, filepath.Ext(path)) + ".state.json"
+	}
+
+	// Overwrite path of config to input.
+	result.Path = path
+	return &result
+}
+
+// buildPeers adds the peers in entries to result. Entries without a valid,
+// unique ID are left out.
+func buildPeers(problems *Problems, result *Config, entries []schema.ConfigPeersElem, self peer.ID, claims addrClaims) {
+	result.PeerLookup.ByID = make(map[peer.ID]Peer, len(entries))
 	result.PeerLookup.ByRoute = cidranger.NewPCTrieRanger()
-	result.PeerLookup.ByName = make(map[string]Peer)
-	result.PeerLookup.ByNetID = make(map[[4]byte]Peer)
-	result.Peers = make([]Peer, len(input.Peers))
+	result.PeerLookup.ByName = make(map[string]Peer, len(entries))
+	result.PeerLookup.ByNetID = make(map[[4]byte]Peer, len(entries))
+	ids := make(map[peer.ID]int)
+	names := make(map[string]int)
+	type route struct {
+		path string
+		net  *net.IPNet
+	}
+	var routes []route
 
-	for i, configPeer := range input.Peers {
-		p := Peer{}
-		p.ID, err = peer.Decode(configPeer.Id)
-		if err != nil {
-			return nil, err
+	for i, entry := range entries {
+		path := fmt.Sprintf("peers[%d]", i)
+		p := Peer{
+			Name:       entry.Name,
+			AllowRelay: entry.AllowRelay,
 		}
-		p.Name = configPeer.Name
-		p.BuiltinAddr4 = mkBuiltinAddr4(p.ID)
-		p.BuiltinAddr6 = mkBuiltinAddr6(p.ID)
-		for _, r := range configPeer.Routes {
-			_, network, err := net.ParseCIDR(r.Net)
+		id, idErr := peer.Decode(entry.Id)
+		valid := false
+		switch {
+		case idErr != nil:
+			problems.add(path+".id", "%s", idErr)
+		case id == self:
+			problems.add(path+".id", "is the ID of this node")
+		default:
+			if j, dup := ids[id]; dup {
+				problems.add(path+".id", "duplicate of peers[%d]", j)
+			} else {
+				ids[id] = i
+				p.ID = id
+				valid = true
+			}
+		}
+		if entry.Name != "" {
+			name := strings.ToLower(entry.Name)
+			if j, dup := names[name]; dup {
+				problems.add(path+".name", "duplicate of peers[%d]", j)
+			} else {
+				names[name] = i
+			}
+		}
+
+		p.AllowedSources = parseNetworks(problems, path+".allowedSources", entry.AllowedSources)
+		for k, s := range entry.Addresses {
+			addrPath := fmt.Sprintf("%s.addresses[%d]", path, k)
+			addr, err := multiaddr.NewMultiaddr(s)
 			if err != nil {
-				log.Fatal("[!] Invalid network:", r.Net)
+				problems.add(addrPath, "%s", err)
+				continue
 			}
+			transport, addrID := peer.SplitAddr(addr)
+			if addrID != "" && idErr == nil && addrID != id {
+				problems.add(addrPath, "belongs to %s", addrID)
+			} else if transport == nil {
+				problems.add(addrPath, "has no transport")
+			} else {
+				p.Addrs = append(p.Addrs, transport)
+			}
+		}
+		if valid {
+			p.BuiltinAddr4, p.BuiltinAddr6 = result.Prefixes.builtinAddrs(problems, claims, path, p.ID, entry.Address4, entry.Address6)
+		}
 
+		for k, r := range entry.Routes {
+			routePath := fmt.Sprintf("%s.routes[%d].net", path, k)
+			_, network, err := net.ParseCIDR(r.Net)
+			if err != nil {
+				problems.add(routePath, "%s", err)
+				continue
+			}
+			for _, other := range routes {
+				if other.net.Contains(network.IP) || network.Contains(other.net.IP) {
+					problems.add(routePath, "overlaps %s (%s)", other.path, other.net)
+				}
+			}
+			routes = append(routes, route{routePath, network})
+			if !valid {
+				continue
+			}
 			result.PeerLookup.ByRoute.Insert(&RouteTableEntry{
 				Net:    *network,
 				Target: p,
 			})
-
-			fmt.Printf("[+] Route %s via /p2p/%s\n", network.String(), p.ID)
+		}
+		if !valid || p.BuiltinAddr4 == nil || p.BuiltinAddr6 == nil {
+			continue
 		}
 		result.PeerLookup.ByRoute.Insert(&RouteTableEntry{
 			Net: net.IPNet{
@@ -152,67 +689,109 @@ func Read(path string) (*Config, error) {
 			},
 			Target: p,
 		})
-		if configPeer.Name != "" {
-			result.PeerLookup.ByName[strings.ToLower(configPeer.Name)] = p
+		result.PeerLookup.ByID[p.ID] = p
+		if name := strings.ToLower(p.Name); name != "" && names[name] == i {
+			result.PeerLookup.ByName[name] = p
 		}
-		result.PeerLookup.ByNetID[[4]byte(p.BuiltinAddr6[12:16])] = p
-		result.Peers[i] = p
+		if _, taken := result.PeerLookup.ByNetID[p.NetID()]; !taken {
+			result.PeerLookup.ByNetID[p.NetID()] = p
+		}
+		result.Peers = append(result.Peers, p)
 	}
+}
 
-	result.Services = make(map[string]Service)
-	for name, service := range input.Services {
-		addr, err := multiaddr.NewMultiaddr(service.Target)
-		if err != nil {
-			return nil, err
+// addrInfosInOrder groups addresses by peer like peer.AddrInfosFromP2pAddrs,
+// but keeps the peers in the order they are first listed.
+func addrInfosInOrder(addrs []multiaddr.Multiaddr) ([]peer.AddrInfo, error) {
+	var infos []peer.AddrInfo
+	index := make(map[peer.ID]int)
+	for _, addr := range addrs {
+		transport, id := peer.SplitAddr(addr)
+		if id == "" {
+			return nil, peer.ErrInvalidAddr
 		}
-		whitelist := make(map[peer.ID]struct{})
-		blacklist := make(map[peer.ID]struct{})
-		for _, p := range service.Acl.Whitelist {
-			cfgPeer, err := FindPeerByCLIRef(result.Peers, p)
-			if err != nil {
-				return nil, err
-			}
-			if cfgPeer == nil {
-				return nil, errors.New("unknown peer: " + p)
-			}
-			whitelist[cfgPeer.ID] = struct{}{}
+		i, ok := index[id]
+		if !ok {
+			i = len(infos)
+			index[id] = i
+			infos = append(infos, peer.AddrInfo{ID: id})
 		}
-		for _, peerStr := range service.Acl.Blacklist {
-			cfgPeer, err := FindPeerByCLIRef(result.Peers, peerStr)
-			if err != nil {
-				return nil, err
-			}
-			if cfgPeer == nil {
-				return nil, errors.New("unknown peer: " + peerStr)
-			}
-			blacklist[cfgPeer.ID] = struct{}{}
+		if transport != nil {
+			infos[i].Addrs = append(infos[i].Addrs, transport)
 		}
-		result.Services[name] = Service{
-			Target:          addr,
-			EnableWhitelist: service.Acl.EnableWhitelist,
-			Whitelist:       whitelist,
-			Blacklist:       blacklist,
+	}
+	return infos, nil
+}
+
+// parseAddrFilter parses a network given in CIDR notation or as multiaddr
+// filter, like /ip4/10.0.0.0/ipcidr/8.
+func parseAddrFilter(s string) (net.IPNet, error) {
+	if !strings.HasPrefix(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return net.IPNet{}, err
+		}
+		return *network, nil
+	}
+	addr, err := multiaddr.NewMultiaddr(s)
+	if err != nil {
+		return net.IPNet{}, err
+	}
+	var ip net.IP
+	var bits int
+	ones := -1
+	multiaddr.ForEach(addr, func(c multiaddr.Component) bool {
+		switch c.Protocol().Code {
+		case multiaddr.P_IP4, multiaddr.P_IP6:
+			ip = net.IP(c.RawValue())
+			bits = len(ip) * 8
+		case multiaddr.P_IPCIDR:
+			ones = int(c.RawValue()[0])
 		}
+		return true
+	})
+	if ip == nil || ones < 0 || ones > bits || len(addr) != 2 {
+		return net.IPNet{}, fmt.Errorf("invalid address filter %s", s)
 	}
+	mask := net.CIDRMask(ones, bits)
+	return net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
 
-	result.FilterPrivateAddresses = input.FilterPrivateAddresses
+// PeerByID looks up a configured peer by its ID using the peer index.
+func (cfg *Config) PeerByID(needle peer.ID) (*Peer, bool) {
+	if p, ok := cfg.PeerLookup.ByID[needle]; ok {
+		return &p, true
+	}
+	return nil, false
+}
 
-	result.Domain = input.Domain
-	if result.Domain == "" {
-		result.Domain = "hyprspace"
+// PeerByName looks up a configured peer by its name using the peer index.
+// Unlike FindPeerByName, names are matched case-insensitively, the same way
+// DNS lookups work.
+func (cfg *Config) PeerByName(needle string) (*Peer, bool) {
+	if p, ok := cfg.PeerLookup.ByName[strings.ToLower(needle)]; ok {
+		return &p, true
 	}
+	return nil, false
+}
 
-	for _, addrString := range input.BootstrapPeers {
-		addr, err := multiaddr.NewMultiaddr(addrString)
-		if err != nil {
-			return nil, err
+// PeerByCLIRef resolves a CLI peer reference like FindPeerByCLIRef, but looks
+// up "@name" references and full peer IDs in the peer index. Names are matched
+// case-insensitively, like in PeerByName.
+func (cfg *Config) PeerByCLIRef(needle string) (*Peer, error) {
+	if needle == "" {
+		return nil, nil
+	}
+	if name, ok := strings.CutPrefix(needle, "@"); ok {
+		p, _ := cfg.PeerByName(name)
+		return p, nil
+	}
+	if id, err := peer.Decode(needle); err == nil {
+		if p, ok := cfg.PeerByID(id); ok {
+			return p, nil
 		}
-		result.BootstrapPeers = append(result.BootstrapPeers, addr)
 	}
-
-	// Overwrite path of config to input.
-	result.Path = path
-	return &result, nil
+	return FindPeerByIDPrefix(cfg.Peers, needle)
 }
 
 func FindPeer(peers []Peer, needle peer.ID) (*Peer, bool) {
@@ -1,11 +1,18 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -202,3 +209,581 @@ func Test_FindPeerByCLIRef(t *testing.T) {
 		assert.Nil(t, target, "FindPeerByCLIRef('random') should return nil, nil")
 	})
 }
+
+type testConfigPeer struct {
+	Id             string   `json:"id"`
+	Name           string   `json:"name"`
+	Addresses      []string `json:"addresses,omitempty"`
+	AllowedSources []string `json:"allowedSources,omitempty"`
+}
+
+// writeTestConfig writes a config with n synthetic peers to a temporary file
+// and returns its path along with the generated peers.
+func writeTestConfig(tb testing.TB, n int) (string, []testConfigPeer) {
+	return writeTestConfigWith(tb, n, nil)
+}
+
+// writeTestConfigWith is like writeTestConfig, with extra top-level settings.
+func writeTestConfigWith(tb testing.TB, n int, extra map[string]any) (string, []testConfigPeer) {
+	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(tb, err)
+	keyBytes, err := crypto.MarshalPrivateKey(pk)
+	require.NoError(tb, err)
+
+	peers := make([]testConfigPeer, n)
+	for i := range n {
+		pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+		require.NoError(tb, err)
+		pid, err := peer.IDFromPrivateKey(pk)
+		require.NoError(tb, err)
+		peers[i] = testConfigPeer{
+			Id:   pid.String(),
+			Name: fmt.Sprintf("peer%d", i),
+		}
+	}
+	settings := map[string]any{
+		"privateKey": multibase.MustNewEncoder(multibase.Base58BTC).Encode(keyBytes),
+		"peers":      peers,
+	}
+	maps.Copy(settings, extra)
+	out, err := json.Marshal(settings)
+	require.NoError(tb, err)
+
+	path := filepath.Join(tb.TempDir(), "hyprspace.json")
+	require.NoError(tb, os.WriteFile(path, out, 0o600))
+	return path, peers
+}
+
+func Test_PeerByID(t *testing.T) {
+	path, peers := writeTestConfig(t, 3)
+	cfg, err := Read(path)
+	require.NoError(t, err)
+
+	t.Run("exact match", func(t *testing.T) {
+		pid, err := peer.Decode(peers[1].Id)
+		require.NoError(t, err)
+		target, found := cfg.PeerByID(pid)
+		require.True(t, found)
+		assert.Equal(t, pid, target.ID)
+		assert.Equal(t, "peer1", target.Name)
+		assert.Equal(t, DefaultPrefixes.builtinAddr4(pid), target.BuiltinAddr4)
+	})
+	t.Run("no match", func(t *testing.T) {
+		_, found := cfg.PeerByID(makeTestPeers(t)[0].ID)
+		assert.False(t, found)
+	})
+}
+
+func Test_PeerByName(t *testing.T) {
+	path, peers := writeTestConfig(t, 3)
+	cfg, err := Read(path)
+	require.NoError(t, err)
+
+	t.Run("found one", func(t *testing.T) {
+		target, found := cfg.PeerByName("peer2")
+		require.True(t, found)
+		assert.Equal(t, peers[2].Id, target.ID.String())
+	})
+	t.Run("case mismatch", func(t *testing.T) {
+		target, found := cfg.PeerByName("PEER2")
+		require.True(t, found, "should be case-insensitive")
+		assert.Equal(t, peers[2].Id, target.ID.String())
+	})
+	t.Run("no match", func(t *testing.T) {
+		_, found := cfg.PeerByName("charlie")
+		assert.False(t, found)
+	})
+}
+
+func Test_PeerByCLIRef(t *testing.T) {
+	path, peers := writeTestConfig(t, 3)
+	cfg, err := Read(path)
+	require.NoError(t, err)
+
+	t.Run("name", func(t *testing.T) {
+		target, err := cfg.PeerByCLIRef("@PEER1")
+		require.NoError(t, err)
+		require.NotNil(t, target)
+		assert.Equal(t, peers[1].Id, target.ID.String())
+	})
+	t.Run("name not found", func(t *testing.T) {
+		target, err := cfg.PeerByCLIRef("@charlie")
+		assert.NoError(t, err)
+		assert.Nil(t, target)
+	})
+	t.Run("full id", func(t *testing.T) {
+		target, err := cfg.PeerByCLIRef(peers[2].Id)
+		require.NoError(t, err)
+		require.NotNil(t, target)
+		assert.Equal(t, peers[2].Id, target.ID.String())
+	})
+	t.Run("id prefix collision", func(t *testing.T) {
+		target, err := cfg.PeerByCLIRef(peers[0].Id[:6])
+		assert.Error(t, err)
+		assert.Nil(t, target)
+	})
+	t.Run("empty", func(t *testing.T) {
+		target, err := cfg.PeerByCLIRef("")
+		assert.NoError(t, err)
+		assert.Nil(t, target)
+	})
+}
+
+const benchPeers = 5000
+
+func Test_Read_StateFile(t *testing.T) {
+	t.Run("next to the config", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(filepath.Dir(path), "hyprspace.state.json"), cfg.StateFile)
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{"stateFile": "/var/lib/hyprspace/state.json"})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, "/var/lib/hyprspace/state.json", cfg.StateFile)
+	})
+}
+
+func Test_Read_PeerAddresses(t *testing.T) {
+	_, peers := writeTestConfig(t, 2)
+	readWith := func(addrs ...string) (*Config, error) {
+		peers[0].Addresses = addrs
+		path, _ := writeTestConfigWith(t, 0, map[string]any{"peers": peers})
+		return Read(path)
+	}
+
+	t.Run("transport addresses", func(t *testing.T) {
+		cfg, err := readWith("/ip4/203.0.113.1/udp/8001/quic-v1", "/dns4/vpn.example.com/tcp/8001")
+		require.NoError(t, err)
+		require.Len(t, cfg.Peers[0].Addrs, 2)
+		assert.Equal(t, "/ip4/203.0.113.1/udp/8001/quic-v1", cfg.Peers[0].Addrs[0].String())
+		assert.Empty(t, cfg.Peers[1].Addrs)
+	})
+
+	t.Run("own peer ID is stripped", func(t *testing.T) {
+		cfg, err := readWith("/ip4/203.0.113.1/tcp/8001/p2p/" + peers[0].Id)
+		require.NoError(t, err)
+		require.Len(t, cfg.Peers[0].Addrs, 1)
+		assert.Equal(t, "/ip4/203.0.113.1/tcp/8001", cfg.Peers[0].Addrs[0].String())
+	})
+
+	t.Run("peer ID of someone else", func(t *testing.T) {
+		_, err := readWith("/ip4/203.0.113.1/tcp/8001/p2p/" + peers[1].Id)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := readWith("/ip4/nope")
+		assert.Error(t, err)
+	})
+}
+
+func Test_Read_PeerAllowedSources(t *testing.T) {
+	_, peers := writeTestConfig(t, 2)
+	peers[0].AllowedSources = []string{"203.0.113.0/24", "2001:db8::/32"}
+	path, _ := writeTestConfigWith(t, 0, map[string]any{"peers": peers})
+	cfg, err := Read(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Peers[0].AllowedSources, 2)
+	assert.Equal(t, "2001:db8::/32", cfg.Peers[0].AllowedSources[1].String())
+	assert.Empty(t, cfg.Peers[1].AllowedSources)
+
+	peers[0].AllowedSources = []string{"203.0.113.1"}
+	path, _ = writeTestConfigWith(t, 0, map[string]any{"peers": peers})
+	_, err = Read(path)
+	assert.Error(t, err)
+}
+
+func Test_Read_Routing(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, DHTPublic, cfg.Routing.DHT)
+		assert.Equal(t, []string{"https://p2p.privatevoid.net"}, cfg.Routing.Delegated)
+	})
+
+	t.Run("private", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"routing": map[string]any{"dht": "private", "dhtServer": true, "delegated": []string{}},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, DHTPrivate, cfg.Routing.DHT)
+		assert.True(t, cfg.Routing.DHTServer)
+		assert.Equal(t, "/hyprspace", cfg.Routing.DHTPrefix)
+		assert.Empty(t, cfg.Routing.Delegated)
+	})
+
+	t.Run("public server", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"routing": map[string]any{"dhtServer": true},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.True(t, cfg.Routing.DHTServer)
+	})
+
+	t.Run("server without DHT", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"routing": map[string]any{"dht": "none", "dhtServer": true},
+		})
+		_, err := Read(path)
+		assert.Error(t, err)
+	})
+
+	t.Run("public prefix", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"routing": map[string]any{"dht": "private", "dhtPrefix": "/ipfs"},
+		})
+		_, err := Read(path)
+		assert.Error(t, err)
+	})
+
+	t.Run("members only", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"membersOnly": true,
+			"routing":     map[string]any{"dht": "private"},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.True(t, cfg.MembersOnly)
+	})
+
+	t.Run("members only with public DHT", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{"membersOnly": true})
+		_, err := Read(path)
+		assert.Error(t, err)
+	})
+
+	t.Run("delegated endpoint is not a URL", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"routing": map[string]any{"delegated": []string{"p2p.privatevoid.net"}},
+		})
+		_, err := Read(path)
+		assert.Error(t, err)
+	})
+}
+
+func Test_Read_Relays(t *testing.T) {
+	relay1 := "/ip4/203.0.113.1/udp/8001/quic-v1/p2p/12D3KooWQWsHPUUeFhe4b6pyCaD1hBoj8j6Z7S7kTznRTh1p1eVt"
+	relay1TCP := "/ip4/203.0.113.1/tcp/8001/p2p/12D3KooWQWsHPUUeFhe4b6pyCaD1hBoj8j6Z7S7kTznRTh1p1eVt"
+	relay2 := "/ip4/203.0.113.2/tcp/8001/p2p/12D3KooWL84sAtq1QTYwb7gVbhSNX5ZUfVt4kgYKz8pdif1zpGUh"
+
+	t.Run("default policy", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, RelayPolicyAny, cfg.RelayPolicy)
+		assert.Empty(t, cfg.Relays)
+	})
+
+	t.Run("addresses are grouped by relay", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"relays":      []string{relay1, relay2, relay1TCP},
+			"relayPolicy": "static",
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, RelayPolicyStatic, cfg.RelayPolicy)
+		require.Len(t, cfg.Relays, 2)
+		assert.Len(t, cfg.Relays[0].Addrs, 2)
+		assert.Len(t, cfg.Relays[1].Addrs, 1)
+	})
+
+	t.Run("static policy without relays", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{"relayPolicy": "static"})
+		_, err := Read(path)
+		assert.Error(t, err)
+	})
+
+	t.Run("relay without peer ID", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{"relays": []string{"/ip4/203.0.113.1/tcp/8001"}})
+		_, err := Read(path)
+		assert.Error(t, err)
+	})
+}
+
+func Benchmark_Read(b *testing.B) {
+	path, _ := writeTestConfig(b, benchPeers)
+	b.ResetTimer()
+	for b.Loop() {
+		_, err := Read(path)
+		require.NoError(b, err)
+	}
+}
+
+func Test_Read_Resources(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Zero(t, cfg.Resources)
+		assert.Equal(t, ConnManager{LowWater: 160, HighWater: 192, GracePeriod: time.Minute}, cfg.ConnManager)
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"resources": map[string]any{
+				"maxMemory": 64,
+				"streams":   map[string]any{"pex": 16},
+			},
+			"connectionManager": map[string]any{"lowWater": 20, "highWater": 40},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.EqualValues(t, 64<<20, cfg.Resources.MaxMemory)
+		assert.Equal(t, StreamLimits{PeX: 16}, cfg.Resources.Streams)
+		assert.Equal(t, ConnManager{LowWater: 20, HighWater: 40, GracePeriod: time.Minute}, cfg.ConnManager)
+	})
+
+	t.Run("low water above high water", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"connectionManager": map[string]any{"lowWater": 200},
+		})
+		_, err := Read(path)
+		assert.Error(t, err)
+	})
+}
+
+func Test_Read_AddressFilters(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Empty(t, cfg.AddressFilters.Deny)
+		assert.True(t, cfg.MDNS)
+	})
+
+	t.Run("private addresses", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"filterPrivateAddresses": true,
+			"addressFilters":         map[string]any{"deny": []string{"100.64.0.0/10"}},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Len(t, cfg.AddressFilters.Deny, len(privateNetworks)+1)
+		assert.False(t, cfg.MDNS)
+	})
+
+	t.Run("mdns override", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"filterPrivateAddresses": true,
+			"mdns":                   true,
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.True(t, cfg.MDNS)
+	})
+
+	t.Run("formats", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"addressFilters": map[string]any{
+				"deny":  []string{"172.16.0.0/12", "/ip6/fd00::/ipcidr/8"},
+				"allow": []string{"/ip4/172.20.1.2/ipcidr/16"},
+			},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"172.16.0.0/12", "fd00::/8"}, []string{cfg.AddressFilters.Deny[0].String(), cfg.AddressFilters.Deny[1].String()})
+		assert.Equal(t, "172.20.0.0/16", cfg.AddressFilters.Allow[0].String())
+	})
+
+	for _, f := range []string{"172.16.0.0", "/ip4/10.0.0.0", "/ip4/10.0.0.0/ipcidr/33", "/ip4/10.0.0.0/ipcidr/8/tcp/1"} {
+		t.Run("invalid "+f, func(t *testing.T) {
+			path, _ := writeTestConfigWith(t, 1, map[string]any{
+				"addressFilters": map[string]any{"deny": []string{f}},
+			})
+			_, err := Read(path)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// collidingPeerIDs returns two peer IDs with the same derived IPv4 address.
+func collidingPeerIDs(t *testing.T) (peer.ID, peer.ID) {
+	seen := make(map[string]peer.ID)
+	for {
+		pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+		pid, err := peer.IDFromPrivateKey(pk)
+		require.NoError(t, err)
+		addr := DefaultPrefixes.builtinAddr4(pid).String()
+		if other, ok := seen[addr]; ok {
+			return other, pid
+		}
+		seen[addr] = pid
+	}
+}
+
+// networkAddrPeerID returns a peer ID whose builtin IPv4 address in the
+// default prefix is its network address.
+func networkAddrPeerID(t *testing.T) peer.ID {
+	// A sha256 multihash, with the last bytes of the digest chosen to fold
+	// the host bits to zero.
+	data := make([]byte, 34)
+	data[0], data[1] = 0x12, 0x20
+	data[32], data[33] = 0x13, 0x22
+	pid, err := peer.IDFromBytes(data)
+	require.NoError(t, err)
+	require.Equal(t, "100.64.0.0", DefaultPrefixes.builtinAddr4(pid).String())
+	return pid
+}
+
+func Test_Read_BuiltinAddrs(t *testing.T) {
+	t.Run("overrides", func(t *testing.T) {
+		_, peers := writeTestConfig(t, 2)
+		path, _ := writeTestConfigWith(t, 0, map[string]any{
+			"address4": "100.64.10.1",
+			"peers": []map[string]any{
+				{"id": peers[0].Id, "address4": "100.64.10.2", "address6": "fd00:6879:7072:7370:6163:6500:a:2"},
+				{"id": peers[1].Id},
+			},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, "100.64.10.1", cfg.BuiltinAddr4.String())
+		assert.Equal(t, "100.64.10.2", cfg.Peers[0].BuiltinAddr4.String())
+		assert.Equal(t, "fd00:6879:7072:7370:6163:6500:a:2", cfg.Peers[0].BuiltinAddr6.String())
+		assert.Equal(t, [4]byte{0, 0x0a, 0, 0x02}, cfg.Peers[0].NetID())
+		assert.Equal(t, cfg.Peers[0].ID, cfg.PeerLookup.ByNetID[[4]byte{0, 0x0a, 0, 0x02}].ID)
+		route, found := cfg.FindRouteForIP(net.ParseIP("100.64.10.2"))
+		require.True(t, found)
+		assert.Equal(t, cfg.Peers[0].ID, route.Target.ID)
+		assert.Equal(t, DefaultPrefixes.builtinAddr4(cfg.Peers[1].ID), cfg.Peers[1].BuiltinAddr4)
+	})
+
+	for _, override := range []map[string]any{
+		{"address4": "10.0.0.1"},
+		{"address4": "fd00:6879:7072:7370:6163:6500:a:2"},
+		{"address6": "fd00::1"},
+		{"address6": "100.64.10.2"},
+	} {
+		t.Run(fmt.Sprint("invalid ", override), func(t *testing.T) {
+			path, _ := writeTestConfigWith(t, 1, override)
+			_, err := Read(path)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("collision", func(t *testing.T) {
+		a, b := collidingPeerIDs(t)
+		path, _ := writeTestConfigWith(t, 0, map[string]any{
+			"peers": []map[string]any{{"id": a.String()}, {"id": b.String()}},
+		})
+		_, err := Read(path)
+		assert.ErrorContains(t, err, "set address4")
+
+		path, _ = writeTestConfigWith(t, 0, map[string]any{
+			"peers": []map[string]any{{"id": a.String()}, {"id": b.String(), "address4": "100.64.10.2"}},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, "100.64.10.2", cfg.Peers[1].BuiltinAddr4.String())
+	})
+
+	t.Run("collision with this node", func(t *testing.T) {
+		_, peers := writeTestConfig(t, 1)
+		id, err := peer.Decode(peers[0].Id)
+		require.NoError(t, err)
+		path, _ := writeTestConfigWith(t, 0, map[string]any{
+			"address4": DefaultPrefixes.builtinAddr4(id).String(),
+			"peers":    peers,
+		})
+		_, err = Read(path)
+		assert.ErrorContains(t, err, "this node")
+	})
+}
+
+func Test_Read_Prefixes(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, DefaultPrefixes, cfg.Prefixes)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		_, peers := writeTestConfig(t, 2)
+		path, _ := writeTestConfigWith(t, 0, map[string]any{
+			"prefixes": map[string]any{"ipv4": "10.99.0.0/24", "ipv6": "fd12:3456::/64"},
+			"peers": []map[string]any{
+				{"id": peers[0].Id, "address4": "10.99.0.10"},
+				{"id": peers[1].Id},
+			},
+		})
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		assert.Equal(t, "10.99.0.0/24", cfg.Prefixes.IPv4.String())
+		assert.Equal(t, DefaultPrefixes.Service, cfg.Prefixes.Service)
+		assert.True(t, cfg.Prefixes.IPv4.Contains(cfg.BuiltinAddr4))
+		assert.True(t, cfg.Prefixes.IPv6.Contains(cfg.BuiltinAddr6))
+		assert.Equal(t, "10.99.0.10", cfg.Peers[0].BuiltinAddr4.String())
+		assert.True(t, cfg.Prefixes.IPv4.Contains(cfg.Peers[1].BuiltinAddr4))
+	})
+
+	for _, prefixes := range []map[string]any{
+		{"ipv4": "10.99.0.0/31"},
+		{"ipv4": "fd00::/8"},
+		{"ipv6": "fd12:3456::/112"},
+		{"service": "fd00:6879:7072:7370::/64"},
+	} {
+		t.Run(fmt.Sprint("invalid ", prefixes), func(t *testing.T) {
+			path, _ := writeTestConfigWith(t, 1, map[string]any{"prefixes": prefixes})
+			_, err := Read(path)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("address outside prefix", func(t *testing.T) {
+		path, _ := writeTestConfigWith(t, 1, map[string]any{
+			"prefixes": map[string]any{"ipv4": "10.99.0.0/24"},
+			"address4": "100.64.10.1",
+		})
+		_, err := Read(path)
+		assert.Error(t, err)
+	})
+}
+
+func Benchmark_PeerByID(b *testing.B) {
+	path, _ := writeTestConfig(b, benchPeers)
+	cfg, err := Read(path)
+	require.NoError(b, err)
+	needle := cfg.Peers[benchPeers-1].ID
+	for b.Loop() {
+		cfg.PeerByID(needle)
+	}
+}
+
+func Benchmark_PeerByName(b *testing.B) {
+	path, _ := writeTestConfig(b, benchPeers)
+	cfg, err := Read(path)
+	require.NoError(b, err)
+	needle := cfg.Peers[benchPeers-1].Name
+	for b.Loop() {
+		cfg.PeerByName(needle)
+	}
+}
+
+func Benchmark_PeerByCLIRef(b *testing.B) {
+	path, _ := writeTestConfig(b, benchPeers)
+	cfg, err := Read(path)
+	require.NoError(b, err)
+	needle := "@" + cfg.Peers[benchPeers-1].Name
+	for b.Loop() {
+		cfg.PeerByCLIRef(needle)
+	}
+}
+
+func Benchmark_FindRouteForIP(b *testing.B) {
+	path, _ := writeTestConfig(b, benchPeers)
+	cfg, err := Read(path)
+	require.NoError(b, err)
+	needle := cfg.Peers[benchPeers-1].BuiltinAddr6
+	for b.Loop() {
+		cfg.FindRouteForIP(needle)
+	}
+}
@@ -0,0 +1,245 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/hyprspace/hyprspace/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// errUnchanged is returned by edits that leave the config as it is.
+var errUnchanged = errors.New("config unchanged")
+
+// editFile applies edit to the JSON document in the config file at path.
+// The result must pass Read before it replaces the file, in one rename.
+// Settings the edit doesn't touch are kept as they are, apart from the order
+// of keys.
+func editFile(path string, edit func(doc map[string]any) error) error {
+	in, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	doc := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	if err := edit(doc); errors.Is(err, errUnchanged) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(out)
+	err = errors.Join(err, tmp.Chmod(info.Mode().Perm()), tmp.Close())
+	if err != nil {
+		return err
+	}
+	if _, err := Read(tmp.Name()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// AddPeer adds a peer entry to the config file at path. It reports false if
+// a peer with the same ID is configured already.
+func AddPeer(path string, entry schema.ConfigPeersElem) (bool, error) {
+	added := false
+	id, err := peer.Decode(entry.Id)
+	if err != nil {
+		return false, err
+	}
+	err = editFile(path, func(doc map[string]any) error {
+		peers, i := findPeerEntry(doc, id)
+		if i >= 0 {
+			return errUnchanged
+		}
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		var p map[string]any
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return err
+		}
+		doc["peers"] = append(peers, p)
+		added = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return added, nil
+}
+
+// findPeerEntry returns the index of the entry of id in the peers of doc.
+func findPeerEntry(doc map[string]any, id peer.ID) ([]any, int) {
+	peers, _ := doc["peers"].([]any)
+	for i, p := range peers {
+		if p, ok := p.(map[string]any); ok && p["id"] == id.String() {
+			return peers, i
+		}
+	}
+	return peers, -1
+}
+
+// resolvePeer looks up a CLI peer reference in the config file at path.
+func resolvePeer(path string, ref string) (*Peer, error) {
+	cfg, err := Read(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := cfg.PeerByCLIRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, fmt.Errorf("no such peer: %s", ref)
+	}
+	return p, nil
+}
+
+// RemovePeer removes the peer referenced by ref from the config file at path,
+// and returns it.
+func RemovePeer(path string, ref string) (*Peer, error) {
+	p, err := resolvePeer(path, ref)
+	if err != nil {
+		return nil, err
+	}
+	return p, editFile(path, func(doc map[string]any) error {
+		peers, i := findPeerEntry(doc, p.ID)
+		if i < 0 {
+			return errUnchanged
+		}
+		doc["peers"] = slices.Delete(peers, i, i+1)
+		return nil
+	})
+}
+
+// RenamePeer changes the name of the peer referenced by ref in the config file
+// at path.
+func RenamePeer(path string, ref string, name string) (*Peer, error) {
+	p, err := resolvePeer(path, ref)
+	if err != nil {
+		return nil, err
+	}
+	return p, editFile(path, func(doc map[string]any) error {
+		peers, i := findPeerEntry(doc, p.ID)
+		if i < 0 {
+			return errUnchanged
+		}
+		entry := peers[i].(map[string]any)
+		if name == "" {
+			delete(entry, "name")
+		} else {
+			entry["name"] = name
+		}
+		return nil
+	})
+}
+
+// AddRoute routes network to the peer referenced by ref in the config file at
+// path.
+func AddRoute(path string, ref string, network net.IPNet) (*Peer, error) {
+	p, err := resolvePeer(path, ref)
+	if err != nil {
+		return nil, err
+	}
+	return p, editFile(path, func(doc map[string]any) error {
+		peers, i := findPeerEntry(doc, p.ID)
+		if i < 0 {
+			return errUnchanged
+		}
+		entry := peers[i].(map[string]any)
+		routes, _ := entry["routes"].([]any)
+		for _, r := range routes {
+			if r, ok := r.(map[string]any); ok && r["net"] == network.String() {
+				return errUnchanged
+			}
+		}
+		entry["routes"] = append(routes, map[string]any{"net": network.String()})
+		return nil
+	})
+}
+
+// RemoveRoute removes the route to network from the config file at path,
+// whichever peer it leads to.
+func RemoveRoute(path string, network net.IPNet) error {
+	return editFile(path, func(doc map[string]any) error {
+		removed := false
+		peers, _ := doc["peers"].([]any)
+		for _, p := range peers {
+			entry, ok := p.(map[string]any)
+			if !ok {
+				continue
+			}
+			routes, _ := entry["routes"].([]any)
+			n := len(routes)
+			routes = slices.DeleteFunc(routes, func(r any) bool {
+				route, ok := r.(map[string]any)
+				return ok && route["net"] == network.String()
+			})
+			if len(routes) != n {
+				entry["routes"] = routes
+				removed = true
+			}
+		}
+		if !removed {
+			return fmt.Errorf("no route to %s", &network)
+		}
+		return nil
+	})
+}
+
+// AddService points the service name in the config file at path to target.
+// The access lists of an existing service are kept.
+func AddService(path string, name string, target multiaddr.Multiaddr) error {
+	return editFile(path, func(doc map[string]any) error {
+		services, _ := doc["services"].(map[string]any)
+		if services == nil {
+			services = map[string]any{}
+			doc["services"] = services
+		}
+		service, _ := services[name].(map[string]any)
+		if service == nil {
+			service = map[string]any{}
+			services[name] = service
+		}
+		service["target"] = target.String()
+		return nil
+	})
+}
+
+// RemoveService removes the service name from the config file at path.
+func RemoveService(path string, name string) error {
+	return editFile(path, func(doc map[string]any) error {
+		services, _ := doc["services"].(map[string]any)
+		if _, ok := services[name]; !ok {
+			return fmt.Errorf("no such service: %s", name)
+		}
+		delete(services, name)
+		return nil
+	})
+}
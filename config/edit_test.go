@@ -0,0 +1,169 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hyprspace/hyprspace/schema"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_AddPeer(t *testing.T) {
+	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(pk)
+	require.NoError(t, err)
+
+	t.Run("appends the peer", func(t *testing.T) {
+		path, peers := writeTestConfigWith(t, 2, map[string]any{"mdns": false, "domain": "example"})
+		added, err := AddPeer(path, schema.ConfigPeersElem{Id: pid.String(), Name: "new"})
+		require.NoError(t, err)
+		assert.True(t, added)
+
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		require.Len(t, cfg.Peers, 3)
+		assert.Equal(t, peers[0].Id, cfg.Peers[0].ID.String())
+		assert.Equal(t, pid, cfg.Peers[2].ID)
+		assert.Equal(t, "new", cfg.Peers[2].Name)
+		assert.False(t, cfg.MDNS)
+		assert.Equal(t, "example", cfg.Domain)
+	})
+
+	t.Run("keeps the file mode", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		_, err := AddPeer(path, schema.ConfigPeersElem{Id: pid.String()})
+		require.NoError(t, err)
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+
+	t.Run("known peers are left alone", func(t *testing.T) {
+		path, peers := writeTestConfig(t, 2)
+		before, err := os.ReadFile(path)
+		require.NoError(t, err)
+		added, err := AddPeer(path, schema.ConfigPeersElem{Id: peers[1].Id, Name: "other"})
+		require.NoError(t, err)
+		assert.False(t, added)
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("invalid results aren't written", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		before, err := os.ReadFile(path)
+		require.NoError(t, err)
+		_, err = AddPeer(path, schema.ConfigPeersElem{Id: "not a peer ID"})
+		assert.Error(t, err)
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "temporary file left behind")
+	})
+
+}
+
+func Test_RemovePeer(t *testing.T) {
+	path, peers := writeTestConfig(t, 3)
+	p, err := RemovePeer(path, "@peer1")
+	require.NoError(t, err)
+	assert.Equal(t, peers[1].Id, p.ID.String())
+
+	cfg, err := Read(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Peers, 2)
+	assert.Equal(t, peers[0].Id, cfg.Peers[0].ID.String())
+	assert.Equal(t, peers[2].Id, cfg.Peers[1].ID.String())
+
+	_, err = RemovePeer(path, "@peer1")
+	assert.Error(t, err)
+}
+
+func Test_RenamePeer(t *testing.T) {
+	path, peers := writeTestConfig(t, 2)
+	_, err := RenamePeer(path, peers[0].Id[:20], "renamed")
+	require.NoError(t, err)
+
+	cfg, err := Read(path)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", cfg.Peers[0].Name)
+	assert.Equal(t, "peer1", cfg.Peers[1].Name)
+}
+
+func Test_AddRoute(t *testing.T) {
+	_, network, err := net.ParseCIDR("10.1.0.0/16")
+	require.NoError(t, err)
+
+	t.Run("adds the route once", func(t *testing.T) {
+		path, peers := writeTestConfig(t, 2)
+		_, err := AddRoute(path, "@peer1", *network)
+		require.NoError(t, err)
+		_, err = AddRoute(path, "@peer1", *network)
+		require.NoError(t, err)
+
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		route, found := cfg.FindRouteForIP(net.ParseIP("10.1.2.3"))
+		require.True(t, found)
+		assert.Equal(t, peers[1].Id, route.Target.ID.String())
+		out, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(out), "10.1.0.0/16"))
+	})
+
+	t.Run("unknown peer", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		_, err := AddRoute(path, "@nobody", *network)
+		assert.Error(t, err)
+	})
+
+	t.Run("removes the route", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 1)
+		_, err := AddRoute(path, "@peer0", *network)
+		require.NoError(t, err)
+		require.NoError(t, RemoveRoute(path, *network))
+
+		cfg, err := Read(path)
+		require.NoError(t, err)
+		_, found := cfg.FindRouteForIP(net.ParseIP("10.1.2.3"))
+		assert.False(t, found)
+		assert.Error(t, RemoveRoute(path, *network))
+	})
+}
+
+func Test_AddService(t *testing.T) {
+	path, _ := writeTestConfigWith(t, 1, map[string]any{
+		"services": map[string]any{
+			"www": map[string]any{
+				"target": "/tcp/8080",
+				"acl":    map[string]any{"enableWhitelist": true, "whitelist": []string{"@peer0"}},
+			},
+		},
+	})
+
+	require.NoError(t, AddService(path, "www", multiaddr.StringCast("/tcp/8081")))
+	require.NoError(t, AddService(path, "ssh", multiaddr.StringCast("/tcp/22")))
+	cfg, err := Read(path)
+	require.NoError(t, err)
+	require.Len(t, cfg.Services, 2)
+	assert.Equal(t, "/tcp/8081", cfg.Services["www"].Target.String())
+	assert.True(t, cfg.Services["www"].EnableWhitelist, "ACL of the replaced service is kept")
+	assert.Equal(t, "/tcp/22", cfg.Services["ssh"].Target.String())
+
+	require.NoError(t, RemoveService(path, "www"))
+	cfg, err = Read(path)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Services, 1)
+	assert.Error(t, RemoveService(path, "www"))
+}
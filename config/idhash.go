@@ -1,36 +1,156 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"net"
+	"strings"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
-func mkBuiltinAddr4(p peer.ID) net.IP {
-	builtinAddr := []byte{100, 64, 1, 2}
-	for i, b := range []byte(p) {
-		builtinAddr[(i%2)+2] ^= b
+// Prefixes are the networks that the addresses in the VPN are taken from.
+type Prefixes struct {
+	// Builtin IPv4 addresses, with the peer ID folded into the host bits.
+	IPv4 net.IPNet
+	// Builtin IPv6 addresses, with the net ID in the last 32 bits.
+	IPv6 net.IPNet
+	// The service network, with the net ID and service ID in the last 48
+	// bits.
+	Service net.IPNet
+}
+
+// DefaultPrefixes are used for the prefixes a config doesn't set.
+var DefaultPrefixes = Prefixes{
+	IPv4:    net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(16, 32)},
+	IPv6:    net.IPNet{IP: net.IP("\xfd\x00hyprspace\x00\x00\x00\x00\x00"), Mask: net.CIDRMask(96, 128)},
+	Service: net.IPNet{IP: net.IP("\xfd\x00hyprspsv\x00\x00\x00\x00\x00\x00"), Mask: net.CIDRMask(80, 128)},
+}
+
+// Longest prefixes that leave room for the host part. An IPv4 /30 still has
+// two addresses besides the network and broadcast address.
+const (
+	maxPrefixLen4       = 30
+	maxPrefixLen6       = 96
+	maxPrefixLenService = 80
+)
+
+// parsePrefix parses a prefix in CIDR notation, of at most maxLen bits.
+func parsePrefix(s string, ipv6 bool, maxLen int) (net.IPNet, error) {
+	ip, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return net.IPNet{}, err
+	}
+	if (ip.To4() == nil) != ipv6 {
+		if ipv6 {
+			return net.IPNet{}, fmt.Errorf("%s is not an IPv6 network", s)
+		}
+		return net.IPNet{}, fmt.Errorf("%s is not an IPv4 network", s)
+	}
+	if ones, _ := network.Mask.Size(); ones > maxLen {
+		return net.IPNet{}, fmt.Errorf("%s is longer than /%d", s, maxLen)
 	}
-	return net.IP(builtinAddr).To4()
+	return *network, nil
 }
 
-func mkBuiltinAddr6(p peer.ID) net.IP {
-	builtinAddr := []byte("\xfd\x00hyprspace\x00\x00\x00\x00\x00")
+// overlap reports whether two networks share addresses.
+func overlap(a net.IPNet, b net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// withHost returns the address in network with the host bits from host.
+func withHost(network net.IPNet, host []byte) net.IP {
+	addr := make(net.IP, len(host))
+	for i := range addr {
+		addr[i] = network.IP[i]&network.Mask[i] | host[i]&^network.Mask[i]
+	}
+	return addr
+}
+
+func (px Prefixes) builtinAddr4(p peer.ID) net.IP {
+	ones, bits := px.IPv4.Mask.Size()
+	n := (bits - ones + 7) / 8
+	host := []byte{0, 0, 1, 2}
 	for i, b := range []byte(p) {
-		builtinAddr[(i%4)+12] ^= b
+		host[len(host)-n+i%n] ^= b
 	}
-	netId := MkNetID(p)
-	builtinAddr[12], builtinAddr[13], builtinAddr[14], builtinAddr[15] = netId[0], netId[1], netId[2], netId[3]
-	return net.IP(builtinAddr).To16()
+	addr := withHost(px.IPv4, host)
+	// Move off the network and broadcast address of a configured prefix to
+	// their neighbour. The addresses in the default prefix stay as they
+	// always were, one there is reported so it can be set by hand instead.
+	if px.IPv4.String() != DefaultPrefixes.IPv4.String() && reservedAddr4(px.IPv4, addr) != "" {
+		addr[len(addr)-1] ^= 1
+	}
+	return addr
+}
+
+// reservedAddr4 returns which of the network and broadcast address of network
+// ip is, if any. Neither can be given to a node.
+func reservedAddr4(network net.IPNet, ip net.IP) string {
+	zeros, ones := true, true
+	for i := range ip {
+		host := ip[i] &^ network.Mask[i]
+		zeros = zeros && host == 0
+		ones = ones && host == ^network.Mask[i]
+	}
+	switch {
+	case zeros:
+		return "network"
+	case ones:
+		return "broadcast"
+	}
+	return ""
 }
 
-func MkServiceAddr6(p peer.ID, serviceName string) net.IP {
-	serviceAddr := []byte("\xfd\x00hyprspsv\x00\x00\x00\x00\x00\x00")
+func (px Prefixes) builtinAddr6(p peer.ID) net.IP {
 	netId := MkNetID(p)
-	serviceAddr[10], serviceAddr[11], serviceAddr[12], serviceAddr[13] = netId[0], netId[1], netId[2], netId[3]
+	host := make([]byte, net.IPv6len)
+	copy(host[12:], netId[:])
+	return withHost(px.IPv6, host)
+}
+
+// parseBuiltinAddr4 parses an address set in place of the builtin IPv4
+// address derived from a peer ID.
+func (px Prefixes) parseBuiltinAddr4(s string) (net.IP, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil || !px.IPv4.Contains(ip) {
+		return nil, fmt.Errorf("%s is not an address in %s", s, &px.IPv4)
+	}
+	if reserved := reservedAddr4(px.IPv4, ip); reserved != "" {
+		return nil, fmt.Errorf("%s is the %s address of %s", s, reserved, &px.IPv4)
+	}
+	return ip, nil
+}
+
+// parseBuiltinAddr6 parses an address set in place of the builtin IPv6
+// address derived from a peer ID.
+func (px Prefixes) parseBuiltinAddr6(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil || !px.IPv6.Contains(ip) {
+		return nil, fmt.Errorf("%s is not an address in %s", s, &px.IPv6)
+	}
+	return ip.To16(), nil
+}
+
+// ServiceAddr6 returns the address of a service of the node with netId.
+func (px Prefixes) ServiceAddr6(netId [4]byte, serviceName string) net.IP {
 	svcId := MkServiceID(serviceName)
-	serviceAddr[14], serviceAddr[15] = svcId[0], svcId[1]
-	return net.IP(serviceAddr).To16()
+	host := make([]byte, net.IPv6len)
+	copy(host[10:], netId[:])
+	copy(host[14:], svcId[:])
+	return withHost(px.Service, host)
+}
+
+// ServiceRoute returns the part of the service network of the node with
+// netId.
+func (px Prefixes) ServiceRoute(netId [4]byte) net.IPNet {
+	host := make([]byte, net.IPv6len)
+	copy(host[10:], netId[:])
+	return net.IPNet{
+		IP:   withHost(px.Service, host),
+		Mask: net.CIDRMask(112, 128),
+	}
 }
 
 func MkNetID(p peer.ID) [4]byte {
@@ -48,3 +168,13 @@ func MkServiceID(serviceName string) [2]byte {
 	}
 	return id
 }
+
+// MkNetworkNamespace derives a name for the network from the settings that
+// all of its nodes must share: the address prefixes and the private DHT
+// prefix. Networks that use the defaults for both end up in the same
+// namespace.
+func MkNetworkNamespace(px Prefixes, dhtPrefix string) string {
+	parts := []string{px.IPv4.String(), px.IPv6.String(), px.Service.String(), dhtPrefix}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return "hyprspace/" + hex.EncodeToString(sum[:16])
+}
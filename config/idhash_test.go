@@ -47,7 +47,7 @@ func Test_MkBuiltinAddr6_DifferentPeers(t *testing.T) {
 
 	addrs := make(map[string]bool)
 	for _, pid := range ids {
-		addr := mkBuiltinAddr6(pid).To16()
+		addr := DefaultPrefixes.builtinAddr6(pid).To16()
 		addrStr := addr.String()
 		assert.False(t, addrs[addrStr], "mkBuiltinAddr6 collision for peer %s: %s", pid, addrStr)
 		addrs[addrStr] = true
@@ -56,19 +56,19 @@ func Test_MkBuiltinAddr6_DifferentPeers(t *testing.T) {
 	assert.Equal(t, 10, len(addrs), "All 10 peers should have unique IPv6 addresses")
 }
 
-func Test_MkServiceAddr6_DifferentServices(t *testing.T) {
+func Test_ServiceAddr6_DifferentServices(t *testing.T) {
 	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
 	require.NoError(t, err)
 	pid, err := peer.IDFromPrivateKey(pk)
 	require.NoError(t, err)
 
-	addrHTTP := MkServiceAddr6(pid, "http")
-	addrSSH := MkServiceAddr6(pid, "ssh")
+	addrHTTP := DefaultPrefixes.ServiceAddr6(MkNetID(pid), "http")
+	addrSSH := DefaultPrefixes.ServiceAddr6(MkNetID(pid), "ssh")
 
 	assert.NotEqual(t, addrHTTP, addrSSH, "Different services should produce different addresses")
 }
 
-func Test_MkServiceAddr6_CollisionResistance(t *testing.T) {
+func Test_ServiceAddr6_CollisionResistance(t *testing.T) {
 	ids := make([]peer.ID, 10)
 	for i := range 10 {
 		pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
@@ -81,7 +81,7 @@ func Test_MkServiceAddr6_CollisionResistance(t *testing.T) {
 	addrs := make(map[string]bool)
 	for _, pid := range ids {
 		for _, svc := range services {
-			addr := MkServiceAddr6(pid, svc).String()
+			addr := DefaultPrefixes.ServiceAddr6(MkNetID(pid), svc).String()
 			assert.False(t, addrs[addr], "Collision: peer %s with service %s: %s", pid, svc, addr)
 			addrs[addr] = true
 		}
@@ -89,3 +89,101 @@ func Test_MkServiceAddr6_CollisionResistance(t *testing.T) {
 
 	assert.Equal(t, 30, len(addrs), "All 30 addresses should be unique")
 }
+
+func Test_MkNetworkNamespace(t *testing.T) {
+	a := MkNetworkNamespace(DefaultPrefixes, "/hyprspace")
+	assert.Equal(t, a, MkNetworkNamespace(DefaultPrefixes, "/hyprspace"))
+	assert.NotEqual(t, a, MkNetworkNamespace(DefaultPrefixes, "/other"))
+
+	px := DefaultPrefixes
+	var err error
+	px.IPv4, err = parsePrefix("10.1.0.0/16", false, maxPrefixLen4)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, MkNetworkNamespace(px, "/hyprspace"))
+}
+
+func Test_Prefixes_Defaults(t *testing.T) {
+	// The addresses derived with the default prefixes must stay the same.
+	pid, err := peer.Decode("12D3KooWQWsHPUUeFhe4b6pyCaD1hBoj8j6Z7S7kTznRTh1p1eVt")
+	require.NoError(t, err)
+	assert.Equal(t, "100.64.219.109", DefaultPrefixes.builtinAddr4(pid).String())
+	assert.Equal(t, "fd00:6879:7072:7370:6163:6500:f46d:4e40", DefaultPrefixes.builtinAddr6(pid).String())
+	assert.Equal(t, "fd00:6879:7072:7370:7376:f46d:4e40:17da", DefaultPrefixes.ServiceAddr6(MkNetID(pid), "http").String())
+	route := DefaultPrefixes.ServiceRoute(MkNetID(pid))
+	assert.Equal(t, "fd00:6879:7072:7370:7376:f46d:4e40:0/112", route.String())
+}
+
+func Test_Prefixes_Custom(t *testing.T) {
+	px := Prefixes{}
+	var err error
+	px.IPv4, err = parsePrefix("10.99.0.0/24", false, maxPrefixLen4)
+	require.NoError(t, err)
+	px.IPv6, err = parsePrefix("fd12:3456::/64", true, maxPrefixLen6)
+	require.NoError(t, err)
+	px.Service, err = parsePrefix("fd12:3457::/48", true, maxPrefixLenService)
+	require.NoError(t, err)
+
+	for range 20 {
+		pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+		pid, err := peer.IDFromPrivateKey(pk)
+		require.NoError(t, err)
+		netId := MkNetID(pid)
+
+		assert.True(t, px.IPv4.Contains(px.builtinAddr4(pid)))
+		addr6 := px.builtinAddr6(pid)
+		assert.True(t, px.IPv6.Contains(addr6))
+		assert.Equal(t, netId[:], []byte(addr6[12:]))
+		svc := px.ServiceAddr6(netId, "http")
+		route := px.ServiceRoute(netId)
+		assert.True(t, route.Contains(svc))
+		assert.True(t, px.Service.Contains(svc))
+	}
+}
+
+func Test_builtinAddr4_Reserved(t *testing.T) {
+	px := DefaultPrefixes
+	var err error
+	px.IPv4, err = parsePrefix("10.99.0.4/30", false, maxPrefixLen4)
+	require.NoError(t, err)
+
+	for range 20 {
+		pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+		pid, err := peer.IDFromPrivateKey(pk)
+		require.NoError(t, err)
+		assert.Contains(t, []string{"10.99.0.5", "10.99.0.6"}, px.builtinAddr4(pid).String())
+	}
+
+	for addr, valid := range map[string]bool{
+		"10.99.0.4": false,
+		"10.99.0.5": true,
+		"10.99.0.6": true,
+		"10.99.0.7": false,
+	} {
+		_, err := px.parseBuiltinAddr4(addr)
+		assert.Equal(t, valid, err == nil, addr)
+	}
+}
+
+func Test_parsePrefix(t *testing.T) {
+	for _, tt := range []struct {
+		prefix string
+		ipv6   bool
+		maxLen int
+		valid  bool
+	}{
+		{"10.99.0.0/16", false, maxPrefixLen4, true},
+		{"10.99.0.1/30", false, maxPrefixLen4, true},
+		{"10.99.0.0/31", false, maxPrefixLen4, false},
+		{"fd00::/8", false, maxPrefixLen4, false},
+		{"10.99.0.0", false, maxPrefixLen4, false},
+		{"fd00::/96", true, maxPrefixLen6, true},
+		{"fd00::/97", true, maxPrefixLen6, false},
+		{"fd00::/81", true, maxPrefixLenService, false},
+		{"10.0.0.0/8", true, maxPrefixLen6, false},
+	} {
+		_, err := parsePrefix(tt.prefix, tt.ipv6, tt.maxLen)
+		assert.Equal(t, tt.valid, err == nil, tt.prefix)
+	}
+}
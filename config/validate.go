@@ -0,0 +1,202 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/hyprspace/hyprspace/schema"
+)
+
+// Problem is a mistake in a config, at the JSON path of the setting, like
+// peers[2].routes[0].net. The path is empty for the config as a whole.
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) Error() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+// Problems is every mistake found in a config, in the order of the settings.
+type Problems []Problem
+
+func (ps Problems) Error() string {
+	lines := make([]string, len(ps))
+	for i, p := range ps {
+		lines[i] = p.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ps *Problems) add(path string, format string, args ...any) {
+	*ps = append(*ps, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateFile checks the config file at path the same way Read does, and
+// returns all problems found. The error is only set if the file can't be read.
+func ValidateFile(path string) (Problems, error) {
+	in, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	_, problems := parse(in, path)
+	return problems, nil
+}
+
+// Validate checks the config in data the same way Read does, and returns all
+// problems found.
+func Validate(data []byte) Problems {
+	_, problems := parse(data, "")
+	return problems
+}
+
+// decode parses data against the schema. Type errors are attributed to the
+// top-level setting, peer or service they are in. The config is nil if it
+// doesn't match the schema.
+func decode(data []byte) (*schema.Config, Problems) {
+	var problems Problems
+	var syntaxErr *json.SyntaxError
+	var input schema.Config
+	err := json.Unmarshal(data, &input)
+	if errors.As(err, &syntaxErr) {
+		line, col := position(data, syntaxErr.Offset)
+		problems.add("", "line %d, column %d: %s", line, col, syntaxErr)
+		return nil, problems
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		problems.add("", "config must be a JSON object")
+		return nil, problems
+	}
+	if _, ok := doc["privateKey"]; !ok {
+		problems.add("privateKey", "required")
+	}
+	known := jsonKeys(reflect.TypeFor[schema.Config]())
+	for _, key := range sortedKeys(doc) {
+		raw := doc[key]
+		switch {
+		case !known[key]:
+			problems.add(key, "unknown setting")
+		case key == "peers":
+			var elems []json.RawMessage
+			if err := json.Unmarshal(raw, &elems); err != nil {
+				problems = append(problems, schemaProblem("peers", err))
+				continue
+			}
+			peerKeys := jsonKeys(reflect.TypeFor[schema.ConfigPeersElem]())
+			for i, elem := range elems {
+				path := fmt.Sprintf("peers[%d]", i)
+				var entry map[string]json.RawMessage
+				if json.Unmarshal(elem, &entry) == nil {
+					for _, k := range sortedKeys(entry) {
+						if !peerKeys[k] {
+							problems.add(path+"."+k, "unknown setting")
+						}
+					}
+				}
+				if err := json.Unmarshal(elem, &schema.ConfigPeersElem{}); err != nil {
+					problems = append(problems, peerProblems(path, entry, err)...)
+				}
+			}
+		case key == "services":
+			var services map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &services); err != nil {
+				problems = append(problems, schemaProblem("services", err))
+				continue
+			}
+			for _, name := range sortedKeys(services) {
+				single, _ := json.Marshal(map[string]json.RawMessage{name: services[name]})
+				if err := json.Unmarshal(single, &schema.ConfigServices{}); err != nil {
+					problems = append(problems, schemaProblem("services", err))
+				}
+			}
+		default:
+			single, _ := json.Marshal(map[string]json.RawMessage{"privateKey": []byte(`""`), key: raw})
+			if err := json.Unmarshal(single, &schema.Config{}); err != nil {
+				problems = append(problems, schemaProblem(key, err))
+			}
+		}
+	}
+	if err != nil {
+		if len(problems) == 0 {
+			problems.add("", "%s", err)
+		}
+		return nil, problems
+	}
+	return &input, problems
+}
+
+// schemaProblem turns an error from the schema types into a problem below
+// path.
+func schemaProblem(path string, err error) Problem {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		// The field is relative to the object being decoded, which may be
+		// the whole config.
+		switch field := typeErr.Field; {
+		case field == path || strings.HasPrefix(field, path+"."):
+			path = field
+		case field != "":
+			path += "." + field
+		}
+		return Problem{Path: path, Message: fmt.Sprintf("expected %s, found %s", typeErr.Type, typeErr.Value)}
+	}
+	return Problem{Path: path, Message: err.Error()}
+}
+
+// peerProblems attributes an error decoding the peer entry at path to its
+// routes where possible, since they are decoded on their own.
+func peerProblems(path string, entry map[string]json.RawMessage, err error) Problems {
+	var problems Problems
+	var routes []json.RawMessage
+	if json.Unmarshal(entry["routes"], &routes) == nil {
+		for k, r := range routes {
+			if err := json.Unmarshal(r, &schema.ConfigPeersElemRoutesElem{}); err != nil {
+				problems = append(problems, schemaProblem(fmt.Sprintf("%s.routes[%d]", path, k), err))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		problems = append(problems, schemaProblem(path, err))
+	}
+	return problems
+}
+
+// jsonKeys returns the JSON keys of the fields of struct type t.
+func jsonKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for f := range t.Fields() {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		keys[name] = true
+	}
+	return keys
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// position returns the line and column of the byte before offset in data,
+// where decoding failed, counting from 1.
+func position(data []byte, offset int64) (int, int) {
+	before := data[:min(max(int(offset)-1, 0), len(data))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func problemPaths(problems Problems) []string {
+	paths := make([]string, len(problems))
+	for i, p := range problems {
+		paths[i] = p.Path
+	}
+	return paths
+}
+
+func Test_ValidateFile(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		path, _ := writeTestConfig(t, 3)
+		problems, err := ValidateFile(path)
+		require.NoError(t, err)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := ValidateFile(filepath.Join(t.TempDir(), "nope.json"))
+		assert.Error(t, err)
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hyprspace.json")
+		require.NoError(t, os.WriteFile(path, []byte("{\n  \"peers\": [\n}\n"), 0o600))
+		problems, err := ValidateFile(path)
+		require.NoError(t, err)
+		require.Len(t, problems, 1)
+		assert.Contains(t, problems[0].Message, "line 3, column 1")
+	})
+
+	t.Run("all mistakes", func(t *testing.T) {
+		_, peers := writeTestConfig(t, 3)
+		path, _ := writeTestConfigWith(t, 0, map[string]any{
+			"peers": []map[string]any{
+				{"id": peers[0].Id, "name": "a", "routes": []map[string]any{{"net": "10.0.0.0/16"}}},
+				{"id": peers[1].Id, "name": "A", "routes": []map[string]any{{"net": "10.0.1.0/24"}, {"net": "10.1.0.0"}}},
+				{"id": peers[0].Id, "addresses": []string{"/ip4/203.0.113.1/tcp/8001/p2p/" + peers[1].Id}},
+				{"id": "nope"},
+			},
+			"services": map[string]any{
+				"web": map[string]any{"target": "/tcp/80", "acl": map[string]any{"whitelist": []string{"@a", "@c"}}},
+			},
+			"listenAddresses": []string{"/ip4/0.0.0.0/tcp/8001", "/ip4/nope"},
+			"relays":          []string{"/ip4/203.0.113.1/tcp/8001"},
+			"membersOnly":     true,
+			"advertiseRoutes": []string{"10.0.0.0/33"},
+		})
+		problems, err := ValidateFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"listenAddresses[1]",
+			"relays[0]",
+			"peers[1].name",
+			"peers[1].routes[0].net",
+			"peers[1].routes[1].net",
+			"peers[2].id",
+			"peers[2].addresses[0]",
+			"peers[3].id",
+			"services.web.acl.whitelist[1]",
+			"advertiseRoutes[0]",
+			"membersOnly",
+		}, problemPaths(problems))
+		assert.Equal(t, "peers[2].id: duplicate of peers[0]", problems[5].Error())
+	})
+
+	t.Run("schema", func(t *testing.T) {
+		path, peers := writeTestConfigWith(t, 1, map[string]any{
+			"mdns":    "yes",
+			"peer":    []string{},
+			"routing": map[string]any{"dht": "bogus"},
+		})
+		problems, err := ValidateFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"mdns", "peer", "routing"}, problemPaths(problems))
+
+		path, _ = writeTestConfigWith(t, 0, map[string]any{
+			"peers": []map[string]any{
+				{"id": peers[0].Id, "routes": []map[string]any{{"net": 8}}},
+			},
+		})
+		problems, err = ValidateFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"peers[0].routes[0].net"}, problemPaths(problems))
+	})
+
+	t.Run("missing private key", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hyprspace.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"peers": []}`), 0o600))
+		problems, err := ValidateFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"privateKey"}, problemPaths(problems))
+	})
+}
+
+func Test_Validate_BuiltinAddrs(t *testing.T) {
+	a, b := collidingPeerIDs(t)
+	_, peers := writeTestConfig(t, 3)
+	path, _ := writeTestConfigWith(t, 0, map[string]any{
+		"address6": "fd00::1",
+		"peers": []map[string]any{
+			{"id": a.String()},
+			{"id": b.String()},
+			{"id": peers[0].Id, "address4": "100.65.0.1"},
+			{"id": peers[1].Id, "address4": DefaultPrefixes.builtinAddr4(a).String()},
+			{"id": peers[2].Id, "address4": "100.64.255.255"},
+		},
+	})
+	problems, err := ValidateFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"address6", "peers[1]", "peers[2].address4", "peers[3].address4", "peers[4].address4"}, problemPaths(problems))
+	assert.Equal(t, "peers[4].address4: 100.64.255.255 is the broadcast address of 100.64.0.0/16", problems[4].Error())
+	assert.Equal(t, fmt.Sprintf("peers[1]: address %s collides with peers[0], set address4 for one of them", DefaultPrefixes.builtinAddr4(a)), problems[1].Error())
+}
+
+func Test_Validate_ReservedAddrs(t *testing.T) {
+	id := networkAddrPeerID(t)
+	path, _ := writeTestConfigWith(t, 0, map[string]any{
+		"peers": []map[string]any{{"id": id.String()}},
+	})
+	problems, err := ValidateFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"peers[0]"}, problemPaths(problems))
+	assert.Equal(t, "peers[0]: address 100.64.0.0 is the network address of 100.64.0.0/16, set address4", problems[0].Error())
+
+	path, _ = writeTestConfigWith(t, 0, map[string]any{
+		"peers": []map[string]any{{"id": id.String(), "address4": "100.64.0.1"}},
+	})
+	problems, err = ValidateFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	path, _ = writeTestConfigWith(t, 0, map[string]any{
+		"prefixes": map[string]any{"ipv4": "100.64.0.0/16"},
+		"peers":    []map[string]any{{"id": id.String()}},
+	})
+	problems, err = ValidateFile(path)
+	require.NoError(t, err)
+	assert.Len(t, problems, 1, "the default prefix set explicitly keeps its addresses too")
+
+	path, _ = writeTestConfigWith(t, 0, map[string]any{
+		"prefixes": map[string]any{"ipv4": "100.64.0.0/17"},
+		"peers":    []map[string]any{{"id": id.String()}},
+	})
+	problems, err = ValidateFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, problems, "addresses in other prefixes move off the network address")
+}
+
+func Test_Validate_NetIDs(t *testing.T) {
+	_, peers := writeTestConfig(t, 3)
+	path, _ := writeTestConfigWith(t, 0, map[string]any{
+		"prefixes": map[string]any{"ipv6": "fd00:1::/64"},
+		"address6": "fd00:1::1:0:5",
+		"peers": []map[string]any{
+			{"id": peers[0].Id, "address6": "fd00:1::2:0:5"},
+			{"id": peers[1].Id, "address6": "fd00:1::2:0:6"},
+			{"id": peers[2].Id, "address6": "fd00:1::3:0:6"},
+		},
+	})
+	problems, err := ValidateFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"peers[0].address6", "peers[2].address6"}, problemPaths(problems))
+	assert.Equal(t, "peers[2].address6: network ID 00000006 of address fd00:1::3:0:6 collides with peers[1], set address6 for one of them", problems[1].Error())
+}
+
+func Test_Validate_Prefixes(t *testing.T) {
+	path, _ := writeTestConfigWith(t, 1, map[string]any{
+		"prefixes": map[string]any{"ipv4": "10.99.0.0/31", "service": "fd00:6879:7072:7370::/64"},
+		"address4": "10.99.0.1",
+	})
+	problems, err := ValidateFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prefixes.ipv4", "prefixes.service", "address4"}, problemPaths(problems))
+}
+
+func Test_Read_Problems(t *testing.T) {
+	_, peers := writeTestConfig(t, 2)
+	path, _ := writeTestConfigWith(t, 0, map[string]any{
+		"peers": []map[string]any{
+			{"id": peers[0].Id, "name": "a", "routes": []map[string]any{{"net": "10.0.0.0/16"}}},
+			{"id": peers[1].Id, "name": "A", "routes": []map[string]any{{"net": "10.0.1.0/24"}}},
+			{"id": peers[0].Id},
+		},
+		"services": map[string]any{
+			"web": map[string]any{"target": "/tcp/80", "acl": map[string]any{"whitelist": []string{"@nope"}}},
+		},
+		"bogus": true,
+	})
+	_, err := Read(path)
+	var problems Problems
+	require.ErrorAs(t, err, &problems)
+	assert.Equal(t, []string{
+		"bogus",
+		"peers[1].name",
+		"peers[1].routes[0].net",
+		"peers[2].id",
+		"services.web.acl.whitelist[0]",
+	}, problemPaths(problems))
+	validated, err := ValidateFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, problems, validated)
+}
+
+func Test_Read_InvalidRoute(t *testing.T) {
+	_, peers := writeTestConfig(t, 1)
+	path, _ := writeTestConfigWith(t, 0, map[string]any{
+		"peers": []map[string]any{
+			{"id": peers[0].Id, "routes": []map[string]any{{"net": "10.0.0.0"}}},
+		},
+	})
+	_, err := Read(path)
+	assert.Error(t, err)
+}
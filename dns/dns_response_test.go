@@ -43,7 +43,7 @@ func Test_withDomainSuffix(t *testing.T) {
 }
 
 func Test_mkAliasRecord_emptyService(t *testing.T) {
-	cfg := config.Config{Interface: "hs0", Domain: "hyprspace"}
+	cfg := config.Config{Interface: "hs0", Domain: "hyprspace", Prefixes: config.DefaultPrefixes}
 	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
 	require.NoError(t, err)
 	pid, err := peer.IDFromPrivateKey(pk)
@@ -60,7 +60,7 @@ func Test_mkAliasRecord_emptyService(t *testing.T) {
 }
 
 func Test_mkAliasRecord_withService(t *testing.T) {
-	cfg := config.Config{Interface: "hs0", Domain: "hyprspace"}
+	cfg := config.Config{Interface: "hs0", Domain: "hyprspace", Prefixes: config.DefaultPrefixes}
 	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
 	require.NoError(t, err)
 	pid, err := peer.IDFromPrivateKey(pk)
@@ -74,7 +74,7 @@ func Test_mkAliasRecord_withService(t *testing.T) {
 }
 
 func Test_mkAliasRecord_emptyName(t *testing.T) {
-	cfg := config.Config{Interface: "hs0", Domain: "hyprspace"}
+	cfg := config.Config{Interface: "hs0", Domain: "hyprspace", Prefixes: config.DefaultPrefixes}
 	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
 	require.NoError(t, err)
 	pid, err := peer.IDFromPrivateKey(pk)
@@ -103,10 +103,12 @@ func Test_mkIDRecord(t *testing.T) {
 		fn     func(config.Config, peer.ID, string, net.IP) dns.RR
 		wantRr uint16
 		addr   net.IP
+		want   net.IP
 		is4    bool
 		hasSvc bool
 	}
 
+	netId := config.MkNetID(pid)
 	tests := []testCase{
 		{
 			fn:     func(cfg config.Config, id peer.ID, _ string, addr net.IP) dns.RR { return mkIDRecord4(cfg, id, addr) },
@@ -126,7 +128,8 @@ func Test_mkIDRecord(t *testing.T) {
 				return mkIDRecord6(cfg, id, "http", addr)
 			},
 			wantRr: dns.TypeAAAA,
-			addr:   config.MkServiceAddr6(pid, "http"),
+			addr:   append(net.IP("\xfd\x00hyprspace\x00"), netId[:]...),
+			want:   config.DefaultPrefixes.ServiceAddr6(netId, "http"),
 			hasSvc: true,
 		},
 	}
@@ -134,7 +137,7 @@ func Test_mkIDRecord(t *testing.T) {
 	for i, tt := range tests {
 		name := []string{"ipv4", "ipv6", "ipv6-svc"}[i]
 		t.Run(name, func(t *testing.T) {
-			cfg := config.Config{Interface: "hs0", Domain: "hyprspace"}
+			cfg := config.Config{Interface: "hs0", Domain: "hyprspace", Prefixes: config.DefaultPrefixes}
 			record := tt.fn(cfg, pid, "", tt.addr)
 
 			hdr := record.Header()
@@ -150,7 +153,11 @@ func Test_mkIDRecord(t *testing.T) {
 			} else {
 				aaaaRecord, ok := record.(*dns.AAAA)
 				require.True(t, ok)
-				assert.Equal(t, tt.addr.To16(), aaaaRecord.AAAA)
+				want := tt.addr
+				if tt.want != nil {
+					want = tt.want
+				}
+				assert.Equal(t, want.To16(), aaaaRecord.AAAA)
 			}
 			if tt.hasSvc {
 				assert.Contains(t, hdr.Name, "http.")
@@ -160,7 +167,7 @@ func Test_mkIDRecord(t *testing.T) {
 }
 
 func Test_mkIDRecord_nil_addr(t *testing.T) {
-	cfg := config.Config{Interface: "hs0", Domain: "hyprspace"}
+	cfg := config.Config{Interface: "hs0", Domain: "hyprspace", Prefixes: config.DefaultPrefixes}
 	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
 	require.NoError(t, err)
 	pid, err := peer.IDFromPrivateKey(pk)
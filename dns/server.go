@@ -75,7 +75,7 @@ func mkIDRecord6(cfg config.Config, p peer.ID, serviceName string, addr net.IP)
 		addrWithSvc = addr
 		cidWithSvc = cid
 	} else {
-		addrWithSvc = config.MkServiceAddr6(p, serviceName)
+		addrWithSvc = cfg.Prefixes.ServiceAddr6([4]byte(addr.To16()[12:16]), serviceName)
 		cidWithSvc = serviceName + "." + cid
 	}
 	return &dns.AAAA{
@@ -115,7 +115,10 @@ func MagicDnsServer(ctx context.Context, wg *sync.WaitGroup, config config.Confi
 	wg.Add(1)
 	defer wg.Done()
 
-	dns.HandleFunc(domainSuffix(config), func(w dns.ResponseWriter, r *dns.Msg) {
+	// Each network serves its own suffix, even when another network in the
+	// same process uses the same one.
+	mux := dns.NewServeMux()
+	mux.HandleFunc(domainSuffix(config), func(w dns.ResponseWriter, r *dns.Msg) {
 		m := new(dns.Msg)
 		m.SetReply(r)
 
@@ -143,14 +146,11 @@ func MagicDnsServer(ctx context.Context, wg *sync.WaitGroup, config config.Confi
 						}
 						m.Answer = append(m.Answer, mkIDRecord6(config, node.ID(), qServiceName, config.BuiltinAddr6))
 					} else {
-						for _, p := range config.Peers {
-							if p.ID == qpeer {
-								if !isService {
-									m.Answer = append(m.Answer, mkIDRecord4(config, p.ID, p.BuiltinAddr4))
-								}
-								m.Answer = append(m.Answer, mkIDRecord6(config, p.ID, qServiceName, p.BuiltinAddr6))
-								break
+						if p, found := config.PeerByID(qpeer); found {
+							if !isService {
+								m.Answer = append(m.Answer, mkIDRecord4(config, p.ID, p.BuiltinAddr4))
 							}
+							m.Answer = append(m.Answer, mkIDRecord6(config, p.ID, qServiceName, p.BuiltinAddr6))
 						}
 					}
 				} else {
@@ -193,6 +193,7 @@ func MagicDnsServer(ctx context.Context, wg *sync.WaitGroup, config config.Confi
 		sv := &dns.Server{
 			Addr:      fmt.Sprintf("%s:%d", dnsServerAddr, dnsServerPort),
 			Net:       netType,
+			Handler:   mux,
 			ReusePort: true,
 		}
 		logger.With(zap.String("serverAddr", dnsServerAddr.String()),
@@ -214,7 +215,8 @@ func MagicDnsServer(ctx context.Context, wg *sync.WaitGroup, config config.Confi
 
 	<-ctx.Done()
 	for _, s := range servers {
-		shutdownCtx, _ := context.WithDeadline(ctx, time.Now().Add(5*time.Second))
+		shutdownCtx, cancel := context.WithDeadline(ctx, time.Now().Add(5*time.Second))
 		s.ShutdownContext(shutdownCtx)
+		cancel()
 	}
 }
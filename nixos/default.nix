@@ -73,6 +73,8 @@ in
   };
 
   config = mkIf cfg.enable {
+    services.hyprspace.settings.stateFile = lib.mkDefault "/var/lib/hyprspace/${cfg.interface}.state.json";
+
     systemd.services.hyprspace = {
       description = "Hyprspace Distributed Network";
       after = [ "network-online.target" ];
@@ -95,6 +97,7 @@ in
           if usePrivateKeyFromFile then runConfig else configFile
         } -i ${escapeShellArg cfg.interface}";
         ExecStopPost = "${lib.getExe' pkgs.coreutils "rm"} -f ${escapeShellArg "/run/hyprspace-rpc.${cfg.interface}.sock"}";
+        StateDirectory = "hyprspace";
         ExecReload = "${lib.getExe' pkgs.coreutils "kill"} -USR1 $MAINPID";
       };
 
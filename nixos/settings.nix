@@ -40,6 +40,50 @@ let
           default = [ ];
           example = [ { net = "10.10.0.0/16"; } ];
         };
+
+        addresses = mkOption {
+          type = types.listOf t.multiAddr;
+          description = "Fixed addresses of this peer, dialed before looking it up. (optional)";
+          default = [ ];
+          example = [ "/ip4/203.0.113.1/udp/8001/quic-v1" ];
+        };
+
+        allowRelay = mkOption {
+          type = types.bool;
+          description = "Whether this peer may use this node as a circuit relay.";
+          default = true;
+        };
+
+        allowedSources = mkOption {
+          type = types.listOf t.ipnet;
+          description = ''
+            Networks this peer may connect from. Connections from other addresses, and relayed
+            connections, are rejected. Empty means anywhere. (optional)
+          '';
+          default = [ ];
+          example = [ "203.0.113.0/24" ];
+        };
+
+        address4 = mkOption {
+          type = types.str;
+          description = ''
+            IPv4 address of this peer in the network, instead of the one derived from its PeerID.
+            Must match `address4` in the peer's own configuration. (optional)
+          '';
+          default = "";
+          example = "100.64.10.1";
+        };
+
+        address6 = mkOption {
+          type = types.str;
+          description = ''
+            IPv6 address of this peer in the network, instead of the one derived from its PeerID.
+            Its last 32 bits also identify the peer's services. Must match `address6` in the
+            peer's own configuration. (optional)
+          '';
+          default = "";
+          example = "fd00:6879:7072:7370:6163:6500:a:1";
+        };
       };
     };
 
@@ -81,7 +125,36 @@ in
 
 {
   options = {
-    filterPrivateAddresses = mkEnableOption "filtering of private/link-local addresses from peer discovery. When enabled, the node will not attempt to connect to RFC1918, link-local, or loopback addresses advertised by other peers";
+    filterPrivateAddresses = mkEnableOption "filtering of private/link-local addresses. A shortcut that adds the RFC1918, link-local and loopback networks to `addressFilters.deny` and turns off mDNS, unless `mdns` is set";
+
+    membersOnly = mkEnableOption "members-only mode. Only VPN peers, `bootstrapPeers`, `relays` and rendezvous points can connect to this node or be connected to. This requires the private DHT or none at all";
+
+    shareHost = mkEnableOption "sharing the libp2p host with the other networks in the same process that use the same `privateKey` and enable this as well. The first of them sets the host up, and the networks can't have peers in common. Networks with the same `privateKey` must all enable this";
+
+    addressFilters = {
+      deny = mkOption {
+        type = types.listOf types.str;
+        description = ''
+          Networks to neither dial, accept connections from nor announce, as CIDR (`172.17.0.0/16`)
+          or multiaddr filter (`/ip4/172.17.0.0/ipcidr/16`).
+        '';
+        default = [ ];
+        example = [ "172.16.0.0/12" ];
+      };
+
+      allow = mkOption {
+        type = types.listOf types.str;
+        description = "Networks to use even though they are covered by `deny`, in the same format.";
+        default = [ ];
+        example = [ "172.20.0.0/16" ];
+      };
+    };
+
+    mdns = mkOption {
+      type = types.nullOr types.bool;
+      description = "Whether to discover peers on the local network with mDNS. Defaults to enabled, unless `filterPrivateAddresses` is set.";
+      default = null;
+    };
 
     domain = mkOption {
       type = types.str;
@@ -90,6 +163,55 @@ in
       example = "vpn.internal";
     };
 
+    name = mkOption {
+      type = types.str;
+      description = "Name this node advertises to the rest of the network. Defaults to the hostname.";
+      default = "";
+      example = "laptop";
+    };
+
+    address4 = mkOption {
+      type = types.str;
+      description = "IPv4 address of this node in the network, instead of the one derived from its PeerID. Use it to resolve collisions, and set the same `address4` in the peer entries for this node.";
+      default = "";
+      example = "100.64.10.1";
+    };
+
+    address6 = mkOption {
+      type = types.str;
+      description = "IPv6 address of this node in the network, instead of the one derived from its PeerID. Use it to resolve collisions, and set the same `address6` in the peer entries for this node.";
+      default = "";
+      example = "fd00:6879:7072:7370:6163:6500:a:1";
+    };
+
+    prefixes = {
+      ipv4 = mkOption {
+        type = t.ipnet;
+        description = "Network that IPv4 addresses are taken from, at most a /30. The PeerID is folded into the host bits. All nodes in the network must use the same prefixes.";
+        default = "100.64.0.0/16";
+        example = "10.99.0.0/16";
+      };
+
+      ipv6 = mkOption {
+        type = t.ipnet;
+        description = "Network that IPv6 addresses are taken from, at most a /96. The last 32 bits identify the node.";
+        default = "fd00:6879:7072:7370:6163:6500::/96";
+      };
+
+      service = mkOption {
+        type = t.ipnet;
+        description = "Network of the service network, at most a /80. The last 48 bits identify the node and the service. Must not overlap the IPv6 prefix.";
+        default = "fd00:6879:7072:7370:7376::/80";
+      };
+    };
+
+    advertiseRoutes = mkOption {
+      type = types.listOf t.ipnet;
+      description = "Networks this node advertises to the rest of the network as reachable through it. These are informational, peers still need a matching `routes` entry to use them.";
+      default = [ ];
+      example = [ "10.10.0.0/16" ];
+    };
+
     bootstrapPeers = mkOption {
       type = types.listOf t.multiAddr;
       description = "List of libp2p bootstrap node multiaddresses for initial network discovery.";
@@ -117,9 +239,66 @@ in
       ];
     };
 
+    relays = mkOption {
+      type = types.listOf t.multiAddr;
+      description = "Relay nodes to use when this node can't be reached directly. Each address must include the relay's PeerID.";
+      default = [ ];
+      example = [ "/ip4/203.0.113.1/udp/8001/quic-v1/p2p/12D3KooWQWiPeNvXFdHFRelay" ];
+    };
+
+    relayPolicy = mkOption {
+      type = types.enum [
+        "any"
+        "vpn"
+        "static"
+      ];
+      description = ''
+        Which nodes may be used as relays. `static` only uses the nodes listed in `relays`,
+        `vpn` also uses connected VPN peers, and `any` also uses any other connected libp2p node.
+      '';
+      default = "any";
+    };
+
+    relayService = {
+      strict = mkEnableOption "strict relaying. When enabled, both ends of a relayed connection must be VPN peers that are allowed to relay. Otherwise, one of them is enough";
+
+      maxReservations = mkOption {
+        type = types.ints.unsigned;
+        description = "Maximum number of peers that can hold a relay reservation with this node at the same time. 0 refuses all reservations.";
+        default = 128;
+      };
+
+      maxCircuits = mkOption {
+        type = types.ints.unsigned;
+        description = "Maximum number of relayed connections per peer. 0 refuses all relayed connections.";
+        default = 16;
+      };
+
+      connectionDuration = mkOption {
+        type = types.ints.unsigned;
+        description = ''
+          Time limit in seconds for a single relayed connection. 0 means unlimited.
+          Peers only use limited relayed connections for hole punching, so any limit
+          stops VPN traffic from flowing through this relay.
+        '';
+        default = 0;
+      };
+
+      connectionData = mkOption {
+        type = types.ints.unsigned;
+        description = "Limit in bytes for the data relayed in each direction of a single relayed connection. 0 means unlimited.";
+        default = 0;
+      };
+    };
+
     listenAddresses = mkOption {
       type = types.listOf t.multiAddr;
-      description = "List of addresses to listen on for libp2p traffic.";
+      description = ''
+        List of addresses to listen on for libp2p traffic. TCP and QUIC are always available for
+        outgoing connections. The WebSocket (`/ws`, `/wss`), WebTransport (`/webtransport`) and
+        WebRTC (`/webrtc-direct`) transports are only enabled, for both listening and dialing,
+        when at least one listen address uses them.
+      '';
       default = [
         "/ip4/0.0.0.0/tcp/8001"
         "/ip4/0.0.0.0/udp/8001/quic-v1"
@@ -128,6 +307,125 @@ in
       ];
     };
 
+    routing = {
+      dht = mkOption {
+        type = types.enum [
+          "public"
+          "private"
+          "none"
+        ];
+        description = "Which DHT to look up peers in. The private DHT only spans VPN peers and needs at least one of them with `dhtServer` enabled.";
+        default = "public";
+      };
+
+      dhtServer = mkEnableOption "serving the DHT to other nodes. Only enable this on nodes that other nodes can reach. With the private DHT, they need to be listed in `bootstrapPeers` or with fixed `addresses`";
+
+      dhtPrefix = mkOption {
+        type = types.str;
+        description = "Protocol prefix of the private DHT.";
+        default = "/hyprspace";
+      };
+
+      delegated = mkOption {
+        type = types.listOf types.str;
+        description = "Delegated routing endpoints to look up peers with. Set to an empty list to only use our own infrastructure.";
+        default = [ "https://p2p.privatevoid.net" ];
+      };
+    };
+
+    rendezvous = {
+      points = mkOption {
+        type = types.listOf t.multiAddr;
+        description = "Rendezvous points to register with and look up peers at, including their `/p2p/` peer IDs.";
+        default = [ ];
+        example = [ "/ip4/203.0.113.1/udp/8002/quic-v1/p2p/12D3KooWQWsHPUUeFhe4b6pyCaD1hBoj8j6Z7S7kTznRTh1p1eVt" ];
+      };
+
+      namespace = mkOption {
+        type = types.str;
+        description = "Namespace to register under. Defaults to one derived from `prefixes` and `routing.dhtPrefix`, so set it if other networks with the same settings use the same rendezvous points.";
+        default = "";
+      };
+
+      serve = mkEnableOption "serving as a rendezvous point for other nodes";
+    };
+
+    resources = {
+      maxMemory = mkOption {
+        type = types.ints.unsigned;
+        description = "Memory in MiB that libp2p may use for connections and streams. The other resource limits scale with it. 0 means an eighth of the system memory.";
+        default = 0;
+        example = 64;
+      };
+
+      maxFileDescriptors = mkOption {
+        type = types.ints.unsigned;
+        description = "Number of file descriptors that libp2p may use. 0 means half of the process limit.";
+        default = 0;
+      };
+
+      streams = {
+        hyprspace = mkOption {
+          type = types.ints.unsigned;
+          description = "Maximum number of open streams for VPN traffic. 0 means the libp2p default.";
+          default = 0;
+        };
+
+        pex = mkOption {
+          type = types.ints.unsigned;
+          description = "Maximum number of open streams for peer exchange. 0 means the libp2p default.";
+          default = 0;
+        };
+
+        service = mkOption {
+          type = types.ints.unsigned;
+          description = "Maximum number of open streams for the service network. 0 means the libp2p default.";
+          default = 0;
+        };
+      };
+    };
+
+    connectionManager = {
+      lowWater = mkOption {
+        type = types.ints.unsigned;
+        description = "Number of connections that the connection manager trims down to. Connections to VPN peers are never trimmed.";
+        default = 160;
+      };
+
+      highWater = mkOption {
+        type = types.ints.unsigned;
+        description = "Number of connections at which the connection manager starts trimming.";
+        default = 192;
+      };
+
+      gracePeriod = mkOption {
+        type = types.ints.unsigned;
+        description = "Time in seconds before a new connection may be trimmed.";
+        default = 60;
+      };
+    };
+
+    tlsCertificateFile = mkOption {
+      type = types.str;
+      description = "Path to a PEM encoded certificate chain, used when listening on `/wss` addresses.";
+      default = "";
+      example = "/var/lib/acme/vpn.example.com/fullchain.pem";
+    };
+
+    tlsKeyFile = mkOption {
+      type = types.str;
+      description = "Path to the PEM encoded private key for `tlsCertificateFile`.";
+      default = "";
+      example = "/var/lib/acme/vpn.example.com/key.pem";
+    };
+
+    stateFile = mkOption {
+      type = types.str;
+      description = "Where to remember the addresses of peers between restarts. Defaults to a file next to the configuration file.";
+      default = "";
+      example = "/var/lib/hyprspace/hyprspace.state.json";
+    };
+
     privateKey = mkOption {
       type = types.str;
       description = "This node's private key.";
@@ -0,0 +1,204 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/hyprspace/hyprspace/p2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/zap"
+)
+
+// Network is an interface to bring up and the path of its config.
+type Network struct {
+	Interface  string
+	ConfigPath string
+}
+
+// Daemon runs several networks in one process. Every network keeps its own
+// interface, peers, DNS server, RPC socket and libp2p host. Networks can only
+// use the same key if they opt into sharing their host.
+type Daemon struct {
+	ctx   context.Context
+	lock  sync.Mutex
+	nodes []*Node
+	hosts *sharedHosts
+}
+
+func NewDaemon(ctx context.Context, networks []Network) *Daemon {
+	d := &Daemon{
+		ctx:   ctx,
+		hosts: &sharedHosts{},
+	}
+	for _, n := range networks {
+		d.nodes = append(d.nodes, d.newNode(n.ConfigPath, n.Interface))
+	}
+	return d
+}
+
+func (d *Daemon) newNode(configPath string, ifName string) *Node {
+	node := New(d.ctx, configPath, ifName)
+	node.hosts = d.hosts
+	node.reload = func() {
+		if err := d.Reload(ifName); err != nil {
+			logger.With(zap.String("interface", ifName), zap.Error(err)).Error("Failed to reload network")
+		}
+	}
+	return &node
+}
+
+// Run brings the networks up one after the other. If one fails, it cleans up
+// after itself and those that are up already are stopped again.
+func (d *Daemon) Run() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i, node := range d.nodes {
+		if err := node.Run(); err != nil {
+			d.nodes = d.nodes[:i]
+			return errors.Join(err, d.stop(d.nodes))
+		}
+		logger.With(zap.String("interface", node.interfaceName)).Info("Network ready")
+	}
+	return nil
+}
+
+func (d *Daemon) Rebootstrap() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, node := range d.nodes {
+		node.Rebootstrap()
+	}
+}
+
+// Reload restarts the network of ifName with its current config, along with
+// the networks that use its host.
+func (d *Daemon) Reload(ifName string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	i := slices.IndexFunc(d.nodes, func(node *Node) bool {
+		return node.interfaceName == ifName
+	})
+	if i < 0 {
+		return errors.New("no such network: " + ifName)
+	}
+
+	var group []int
+	for j, node := range d.nodes {
+		if j == i || j > i && node.shared != nil && d.hosts.owner(node.shared) == d.nodes[i] {
+			group = append(group, j)
+		}
+	}
+	var nodes []*Node
+	for _, j := range group {
+		nodes = append(nodes, d.nodes[j])
+	}
+	logger.With(zap.String("interface", ifName)).Info("Reloading network")
+	// Stop tears down as much as it can even when it fails, so the networks
+	// are started again anyway.
+	errs := []error{d.stop(nodes)}
+	failed := map[*Node]bool{}
+	for _, j := range group {
+		old := d.nodes[j]
+		d.nodes[j] = d.newNode(old.configPath, old.interfaceName)
+		if err := d.nodes[j].Run(); err != nil {
+			errs = append(errs, err)
+			failed[d.nodes[j]] = true
+		}
+	}
+	// Networks that failed to come up again have cleaned up after themselves
+	// in Run, and are left out from now on.
+	d.nodes = slices.DeleteFunc(d.nodes, func(node *Node) bool {
+		return failed[node]
+	})
+	return errors.Join(errs...)
+}
+
+// Stop stops the networks in reverse order, so shared hosts go last.
+func (d *Daemon) Stop() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.stop(d.nodes)
+}
+
+func (d *Daemon) stop(nodes []*Node) error {
+	var errs []error
+	for _, node := range slices.Backward(nodes) {
+		if err := node.Stop(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type daemonHost struct {
+	key   crypto.PrivKey
+	host  *p2p.SharedHost
+	owner *Node
+}
+
+// sharedHosts keeps track of the hosts of the networks in the daemon, by
+// key. It hands the host of a network that opted into sharing it out to the
+// networks started after it with the same key that opted in as well. Other
+// networks can't use the same key, remote peers couldn't tell their hosts
+// apart.
+type sharedHosts struct {
+	lock  sync.Mutex
+	hosts []daemonHost
+}
+
+// get returns the shared host to join for cfg, if any.
+func (s *sharedHosts) get(cfg *config.Config) (*p2p.SharedHost, error) {
+	if s == nil {
+		return nil, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, dh := range s.hosts {
+		if !dh.key.Equals(cfg.PrivateKey) {
+			continue
+		}
+		if dh.host == nil || !cfg.ShareHost {
+			return nil, fmt.Errorf("network %s uses the same key, set shareHost in both to share one host", dh.owner.interfaceName)
+		}
+		return dh.host, nil
+	}
+	return nil, nil
+}
+
+// add records the host of owner, h if it can be shared.
+func (s *sharedHosts) add(owner *Node, h *p2p.SharedHost) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hosts = append(s.hosts, daemonHost{owner.cfg.PrivateKey, h, owner})
+}
+
+// owner returns the network that created h.
+func (s *sharedHosts) owner(h *p2p.SharedHost) *Node {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, dh := range s.hosts {
+		if dh.host == h {
+			return dh.owner
+		}
+	}
+	return nil
+}
+
+// drop forgets the host of owner.
+func (s *sharedHosts) drop(owner *Node) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hosts = slices.DeleteFunc(s.hosts, func(dh daemonHost) bool {
+		return dh.owner == owner
+	})
+}
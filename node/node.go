@@ -27,6 +27,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
@@ -43,6 +44,20 @@ type Node struct {
 	cfg               *config.Config
 	p2p               host.Host
 	dht               *dht.IpfsDHT
+	pex               *p2p.PeX
+	gossip            *p2p.Gossip
+	upgrader          *p2p.Upgrader
+	discovery         *p2p.Discovery
+	prober            *p2p.Prober
+	book              *p2p.AddrBook
+	rendezvous        *p2p.Rendezvous
+	invites           *p2p.Invites
+	mdns              mdns.Service
+	reload            func()
+	hosts             *sharedHosts
+	shared            *p2p.SharedHost
+	guest             bool
+	notifiee          network.Notifiee
 	tunDev            *tun.TUN
 	activeStreams     map[peer.ID]SharedStream
 	activeStreamsLock sync.RWMutex
@@ -68,7 +83,19 @@ func New(ctx context.Context, configPath string, ifName string) Node {
 	}
 }
 
+// Run brings the network up. If that fails halfway, whatever was set up
+// already is torn down again.
 func (node *Node) Run() error {
+	err := node.run()
+	if err != nil {
+		if stopErr := node.Stop(); stopErr != nil {
+			logger.With(stopErr).Warn("Failed to clean up after failed start")
+		}
+	}
+	return err
+}
+
+func (node *Node) run() error {
 	// Read in configuration from file.
 	cfg2, err := config.Read(node.configPath)
 	if err != nil {
@@ -79,15 +106,24 @@ func (node *Node) Run() error {
 	cfg2.Interface = node.interfaceName
 	node.cfg = cfg2
 
+	shared, err := node.hosts.get(node.cfg)
+	if err != nil {
+		logger.With(err).Error("Failed to set up Libp2p node")
+		return err
+	}
+
 	logger.Info("Creating TUN Device")
 
 	// Create new TUN device
-	node.tunDev, err = tun.New(
+	tunDev, err := tun.New(
 		node.cfg.Interface,
 		tun.Address(node.cfg.BuiltinAddr4.String()+"/32"),
 		tun.Address(node.cfg.BuiltinAddr6.String()+"/128"),
 		tun.MTU(1420),
 	)
+	if tunDev != nil {
+		node.tunDev = tunDev
+	}
 	if err != nil {
 		logger.With(err).Error("Failed to create TUN Device")
 		return err
@@ -111,50 +147,56 @@ func (node *Node) Run() error {
 		routeOpts = append(routeOpts, tun.Route(r.Network()))
 	}
 
-	recursionGater := p2p.NewRecursionGater(node.cfg)
-	var gater connmgr.ConnectionGater
-	if node.cfg.FilterPrivateAddresses {
-		gater = p2p.NewMultiGater(
-			recursionGater,
-			p2p.NewFilterGater(
-				// IPv4 local
-				parseCIDR("10.0.0.0/8"),
-				parseCIDR("172.16.0.0/12"),
-				parseCIDR("192.168.0.0/16"),
-				// IPv4 link-local
-				parseCIDR("169.254.0.0/16"),
-				// IPv4 loopback
-				parseCIDR("127.0.0.0/8"),
-				// IPv6 link-local
-				parseCIDR("fe80::/10"),
-				// IPv6 loopback
-				parseCIDR("::1/128"),
-			),
-		)
-	} else {
-		gater = recursionGater
-	}
+	gater := newConnectionGater(node.cfg, p2p.NewRecursionGater(node.cfg))
 
 	logger.Info("Creating LibP2P node")
 
 	// Create P2P Node
-	node.p2p, node.dht, err = p2p.CreateNode(
-		node.ctx,
-		node.cfg.PrivateKey,
-		node.cfg.ListenAddresses,
-		node.cfg.BootstrapPeers,
-		node.streamHandler,
-		p2p.NewClosedCircuitRelayFilter(node.cfg.Peers),
-		gater,
-		node.cfg.Peers,
-	)
-	if err != nil {
-		logger.With(err).Error("Failed to create Libp2p node")
-		return err
+	node.pex = p2p.NewPeX(node.cfg)
+	node.upgrader = p2p.NewUpgrader(node.cfg)
+	node.book = p2p.NewAddrBook(node.cfg)
+	node.rendezvous = p2p.NewRendezvous(node.cfg, node.pex)
+	if shared != nil {
+		node.p2p, node.dht, err = p2p.JoinSharedHost(
+			shared,
+			node.cfg,
+			node.streamHandler,
+			node.pex,
+			node.upgrader,
+			node.book,
+			node.rendezvous,
+			p2p.NewClosedCircuitRelayFilter(node.cfg),
+			gater,
+		)
+		if err != nil {
+			logger.With(err).Error("Failed to join shared Libp2p node")
+			return err
+		}
+		node.shared = shared
+		node.guest = true
+	} else {
+		if node.cfg.ShareHost && node.hosts != nil {
+			node.shared = p2p.NewSharedHost()
+		}
+		node.p2p, node.dht, err = p2p.CreateNode(
+			node.ctx,
+			node.cfg,
+			node.streamHandler,
+			node.pex,
+			node.upgrader,
+			node.book,
+			node.rendezvous,
+			p2p.NewClosedCircuitRelayFilter(node.cfg),
+			gater,
+			node.shared,
+		)
+		if err != nil {
+			logger.With(err).Error("Failed to create Libp2p node")
+			return err
+		}
+		node.hosts.add(node, node.shared)
 	}
 
-	node.p2p.SetStreamHandler(p2p.PeXProtocol, p2p.NewPeXStreamHandler(node.p2p, node.cfg))
-
 	for _, p := range node.cfg.Peers {
 		node.p2p.ConnManager().Protect(p.ID, "/hyprspace/peer")
 	}
@@ -164,26 +206,60 @@ func (node *Node) Run() error {
 	logger.Debug("Setting up Node discovery via DHT")
 
 	// Setup DHT Discovery
-	go p2p.Discover(node.ctx, node.wg, node.p2p, node.dht, node.cfg.Peers)
+	node.discovery = p2p.NewDiscovery(node.p2p, node.dht, node.cfg.Peers)
+	go node.discovery.Service(node.ctx, node.wg)
 
 	// Setup mDNS Discovery for LAN peers
-	if !node.cfg.FilterPrivateAddresses {
-		err = p2p.SetupMDNS(node.p2p, node.cfg.Peers)
+	if node.cfg.MDNS {
+		node.mdns, err = p2p.SetupMDNS(node.p2p, node.cfg)
 		if err != nil {
 			logger.With(err).Warn("Failed to start mDNS discovery")
 		}
 	}
 
 	// Configure path for lock
-	node.lockPath = filepath.Join(filepath.Dir(node.cfg.Path), node.cfg.Interface+".lock")
+	lockPath := filepath.Join(filepath.Dir(node.cfg.Path), node.cfg.Interface+".lock")
 
 	logger.Debug("Starting Peer-Exchange service")
 	// PeX
-	go p2p.PeXService(node.ctx, node.wg, node.p2p, node.cfg)
+	go node.pex.Service(node.ctx, node.wg)
+
+	logger.Debug("Starting rendezvous service")
+	// Rendezvous points
+	go node.rendezvous.Service(node.ctx, node.wg)
+
+	logger.Debug("Starting address book service")
+	// Remember peer addresses across restarts
+	go node.book.Service(node.ctx, node.wg)
 
 	logger.Debug("Starting Route Metrics service")
-	// Route metrics and latency
-	go p2p.RouteMetricsService(node.ctx, node.wg, node.p2p, node.cfg)
+	// Latency, jitter and loss of every connection to peers
+	node.prober = p2p.NewProber(node.p2p, node.cfg)
+	go node.prober.Service(node.ctx, node.wg)
+
+	logger.Debug("Starting connection upgrade service")
+	// Hole punching retries for relayed peers
+	go node.upgrader.Service(node.ctx, node.wg)
+	node.notifiee = &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			if _, found := node.cfg.PeerByID(c.RemotePeer()); found && !p2p.IsRelayedAddr(c.RemoteMultiaddr()) {
+				go node.migrateStream(c.RemotePeer())
+			}
+		},
+	}
+	node.p2p.Network().Notify(node.notifiee)
+
+	logger.Debug("Starting Gossip service")
+	// Network-wide state
+	node.gossip, err = p2p.NewGossip(node.ctx, node.p2p, node.cfg)
+	if err != nil {
+		logger.With(err).Error("Failed to set up gossip")
+		return err
+	}
+	go node.gossip.Service(node.ctx, node.wg)
+
+	// Invites for new nodes
+	node.invites = p2p.NewInvites(node.p2p, node.cfg, node.reload)
 
 	// Log about various events
 	err = node.eventLogger(node.ctx, node.p2p)
@@ -194,23 +270,14 @@ func (node *Node) Run() error {
 
 	logger.Debug("Starting RPC server")
 	// RPC server
-	go hsrpc.RpcServer(node.ctx, node.wg, multiaddr.StringCast(fmt.Sprintf("/unix/run/hyprspace-rpc.%s.sock", node.cfg.Interface)), node.p2p, *node.cfg, *node.tunDev)
+	go hsrpc.RpcServer(node.ctx, node.wg, multiaddr.StringCast(fmt.Sprintf("/unix/run/hyprspace-rpc.%s.sock", node.cfg.Interface)), node.p2p, *node.cfg, *node.tunDev, node.gossip, node.upgrader, node.discovery, node.prober, node.invites, node.reload)
 
 	logger.Debug("Starting DNS server")
 	// Magic DNS server
 	go hsdns.MagicDnsServer(node.ctx, node.wg, *node.cfg, node.p2p)
 
 	// metrics endpoint
-	metricsPort, ok := os.LookupEnv("HYPRSPACE_METRICS_PORT")
-	if ok {
-		metricsTuple := fmt.Sprintf("127.0.0.1:%s", metricsPort)
-		http.Handle("/metrics", promhttp.Handler())
-		go func() {
-			logger.Debug("Starting metrics API server")
-			http.ListenAndServe(metricsTuple, nil)
-		}()
-		logger.Info(fmt.Sprintf("Listening for metrics scrape requests on http://%s/metrics", metricsTuple))
-	}
+	serveMetrics()
 
 	serviceNet := svc.NewServiceNetwork(node.p2p, node.cfg, node.tunDev)
 
@@ -227,25 +294,19 @@ func (node *Node) Run() error {
 
 	var svcNetIds [][4]byte
 	for _, p := range node.cfg.Peers {
-		svcNetIds = append(svcNetIds, config.MkNetID(p.ID))
+		svcNetIds = append(svcNetIds, p.NetID())
 	}
-	svcNetIds = append(svcNetIds, config.MkNetID(node.p2p.ID()))
+	svcNetIds = append(svcNetIds, node.cfg.NetID())
 	for _, netId := range svcNetIds {
-		addr := make([]byte, 16)
-		copy(addr, serviceNet.NetworkRange.IP)
-		copy(addr[10:], netId[:])
-		mask1, mask0 := serviceNet.NetworkRange.Mask.Size()
-		routeOpts = append(routeOpts, tun.Route(net.IPNet{
-			IP:   addr,
-			Mask: net.CIDRMask(mask1+32, mask0),
-		}))
+		routeOpts = append(routeOpts, tun.Route(node.cfg.Prefixes.ServiceRoute(netId)))
 	}
 
 	// Write lock to filesystem to indicate an existing running daemon.
-	err = os.WriteFile(node.lockPath, fmt.Append(nil, os.Getpid()), os.ModePerm)
+	err = os.WriteFile(lockPath, fmt.Append(nil, os.Getpid()), os.ModePerm)
 	if err != nil {
 		return err
 	}
+	node.lockPath = lockPath
 
 	logger.Debug("Bringing up TUN device")
 	// Bring Up TUN Device
@@ -337,34 +398,82 @@ func (node *Node) insertActiveStream(pid peer.ID, ss SharedStream) bool {
 	return true
 }
 
-func (node *Node) expireActiveStream(pid peer.ID) {
+// expireActiveStream removes the active stream of pid, unless it has been
+// replaced by another stream in the meantime.
+func (node *Node) expireActiveStream(pid peer.ID, stream *network.Stream) {
 	node.activeStreamsLock.Lock()
 	defer node.activeStreamsLock.Unlock()
-	delete(node.activeStreams, pid)
+	if s, ok := node.activeStreams[pid]; ok && s.Stream == stream {
+		delete(node.activeStreams, pid)
+	}
+}
+
+// migrateStream moves the active stream of pid off a relayed connection, once
+// we have a direct connection to pid that isn't worse.
+func (node *Node) migrateStream(pid peer.ID) {
+	ms, ok := node.getActiveStream(pid)
+	if !ok || !p2p.IsRelayedAddr((*ms.Stream).Conn().RemoteMultiaddr()) {
+		return
+	}
+	if !node.prober.PreferDirect(pid) {
+		logger.With(zap.String("peer", pid.String())).Info("Keeping tunnel on relayed connection, direct connection is lossy")
+		return
+	}
+	stream, err := node.p2p.NewStream(network.WithNoDial(node.ctx, "migrate stream"), pid, p2p.Protocols...)
+	if err != nil {
+		logger.With(zap.String("peer", pid.String()), zap.Error(err)).Warn("Failed to open direct stream")
+		return
+	}
+	if p2p.IsRelayedAddr(stream.Conn().RemoteMultiaddr()) || stream.Protocol() == p2p.ProtocolV0 {
+		// Either the direct connection is gone already, or the peer
+		// can't use a stream opened by us in both directions.
+		stream.Close()
+		return
+	}
+
+	// Swap the streams while holding the lock of the old one, so no packet is
+	// written to it halfway.
+	ms.Lock.Lock()
+	node.activeStreamsLock.Lock()
+	node.activeStreams[pid] = SharedStream{
+		Stream: &stream,
+		Lock:   new(sync.Mutex),
+	}
+	node.activeStreamsLock.Unlock()
+	(*ms.Stream).Close()
+	ms.Lock.Unlock()
+
+	logger.With(zap.String("peer", pid.String())).Info("Moved tunnel from relayed to direct connection")
+	defer node.expireActiveStream(pid, &stream)
+	node.readStream(stream)
 }
 
 func (node *Node) streamHandler(stream network.Stream) {
 	remotePeerID := stream.Conn().RemotePeer()
 
 	// If the remote node ID isn't in the list of known nodes don't respond.
-	if _, ok := config.FindPeer(node.cfg.Peers, remotePeerID); !ok {
+	if _, ok := node.cfg.PeerByID(remotePeerID); !ok {
 		stream.Reset()
 		return
 	}
 
-	var streamLock = new(sync.Mutex)
-
 	// Version 0 nodes don't read from this stream, so we can't reuse it.
 	if stream.Protocol() != p2p.ProtocolV0 {
 		inserted := node.insertActiveStream(remotePeerID, SharedStream{
 			Stream: &stream,
-			Lock:   streamLock,
+			Lock:   new(sync.Mutex),
 		})
 		if inserted {
-			defer node.expireActiveStream(remotePeerID)
+			defer node.expireActiveStream(remotePeerID, &stream)
 		}
 	}
 
+	node.readStream(stream)
+}
+
+// readStream writes the packets received on stream to the TUN device until
+// the stream is closed.
+func (node *Node) readStream(stream network.Stream) {
 	var packet = make([]byte, 1420)
 	var packetSize = make([]byte, 2)
 	for {
@@ -422,7 +531,7 @@ func (node *Node) sendPacket(dst peer.ID, packet []byte, plen int) {
 			// If we encounter an error when writing to a stream we should
 			// close that stream and delete it from the active stream map.
 			(*ms.Stream).Close()
-			node.expireActiveStream(dst)
+			node.expireActiveStream(dst, ms.Stream)
 			return false
 		}() {
 			return
@@ -432,7 +541,7 @@ func (node *Node) sendPacket(dst peer.ID, packet []byte, plen int) {
 	stream, err := node.p2p.NewStream(node.ctx, dst, p2p.Protocols...)
 	if err != nil {
 		logger.With(zap.String("destination", dst.String()), zap.Error(err)).Error("Failed to open stream")
-		go p2p.Rediscover()
+		go node.discovery.Rediscover()
 		return
 	}
 	err = stream.SetWriteDeadline(time.Now().Add(25 * time.Second))
@@ -462,7 +571,9 @@ func (node *Node) eventLogger(ctx context.Context, host host.Host) error {
 	if err != nil {
 		return err
 	}
+	node.wg.Add(1)
 	go func() {
+		defer subCon.Close()
 		for {
 			select {
 			case <-ctx.Done():
@@ -470,17 +581,14 @@ func (node *Node) eventLogger(ctx context.Context, host host.Host) error {
 				return
 			case ev := <-subCon.Out():
 				evt := ev.(event.EvtPeerConnectednessChanged)
-				for _, vpnPeer := range node.cfg.Peers {
-					if vpnPeer.ID == evt.Peer {
-						switch evt.Connectedness {
-						case network.Connected:
-							for _, c := range host.Network().ConnsToPeer(evt.Peer) {
-								logger.Info(fmt.Sprintf("Connected to %s/p2p/%s", c.RemoteMultiaddr().String(), evt.Peer.String()))
-							}
-						case network.NotConnected:
-							logger.Info(fmt.Sprintf("Disconnected from %s", evt.Peer.String()))
+				if _, found := node.cfg.PeerByID(evt.Peer); found {
+					switch evt.Connectedness {
+					case network.Connected:
+						for _, c := range host.Network().ConnsToPeer(evt.Peer) {
+							logger.Info(fmt.Sprintf("Connected to %s/p2p/%s", c.RemoteMultiaddr().String(), evt.Peer.String()))
 						}
-						break
+					case network.NotConnected:
+						logger.Info(fmt.Sprintf("Disconnected from %s", evt.Peer.String()))
 					}
 				}
 			}
@@ -491,37 +599,89 @@ func (node *Node) eventLogger(ctx context.Context, host host.Host) error {
 
 func (node *Node) Rebootstrap() {
 	node.p2p.ConnManager().TrimOpenConns(context.Background())
-	<-node.dht.ForceRefresh()
-	p2p.Rediscover()
+	if node.dht != nil {
+		<-node.dht.ForceRefresh()
+	}
+	node.discovery.Rediscover()
 }
 
+// Stop tears the network down. Only what Run got to set up is torn down, so
+// it also cleans up after a failed start.
 func (node *Node) Stop() error {
-	err := node.p2p.Close()
-	if err != nil {
-		return err
+	var errs []error
+	if node.gossip != nil {
+		node.gossip.Leave(node.ctx)
+	}
+	if node.p2p != nil {
+		// The address book was only loaded along with the host.
+		if err := node.book.Save(); err != nil {
+			logger.With(err).Warn("Failed to save address book")
+		}
 	}
 
-	err = os.Remove(node.lockPath)
-	if err != nil {
-		return err
+	if node.mdns != nil {
+		node.mdns.Close()
+	}
+	node.hosts.drop(node)
+	if node.guest {
+		// The host keeps running for the other networks on it.
+		if node.notifiee != nil {
+			node.p2p.Network().StopNotify(node.notifiee)
+		}
+		node.shared.Leave(node.cfg)
+	} else if node.p2p != nil {
+		if err := node.p2p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if node.lockPath != "" {
+		if err := os.Remove(node.lockPath); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	logger.Info("Received signal, shutting down...")
 
-	err = node.tunDev.Down()
-	if err != nil {
-		return err
+	if node.tunDev.Iface != nil {
+		if err := node.tunDev.Down(); err != nil {
+			errs = append(errs, err)
+		}
+		node.tunDev.Iface.Close()
 	}
-	node.tunDev.Iface.Close()
 	node.cancel()
-	node.wg.Wait()
-	return nil
+	if node.wg != nil {
+		node.wg.Wait()
+	}
+	return errors.Join(errs...)
 }
 
-func parseCIDR(s string) net.IPNet {
-	_, n, err := net.ParseCIDR(s)
-	if err != nil {
-		panic("invalid CIDR: " + s)
+// newConnectionGater combines the given gaters with the address filters, the
+// allowed sources of peers and, in members-only mode, the member gater.
+func newConnectionGater(cfg *config.Config, gaters ...connmgr.ConnectionGater) connmgr.ConnectionGater {
+	gaters = append(gaters, p2p.NewFilterGater(cfg), p2p.NewSourceGater(cfg))
+	if cfg.MembersOnly {
+		gaters = append(gaters, p2p.NewMemberGater(cfg))
+	}
+	return p2p.NewMultiGater(gaters...)
+}
+
+var metricsOnce sync.Once
+
+// serveMetrics serves Prometheus metrics on the port set in the environment,
+// if any. The metrics of all networks in the process are served together.
+func serveMetrics() {
+	metricsPort, ok := os.LookupEnv("HYPRSPACE_METRICS_PORT")
+	if !ok {
+		return
 	}
-	return *n
+	metricsOnce.Do(func() {
+		metricsTuple := fmt.Sprintf("127.0.0.1:%s", metricsPort)
+		http.Handle("/metrics", promhttp.Handler())
+		go func() {
+			logger.Debug("Starting metrics API server")
+			http.ListenAndServe(metricsTuple, nil)
+		}()
+		logger.Info(fmt.Sprintf("Listening for metrics scrape requests on http://%s/metrics", metricsTuple))
+	})
 }
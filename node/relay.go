@@ -0,0 +1,134 @@
+package node
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/hyprspace/hyprspace/p2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// Relay is a node without an interface. It relays connections for VPN peers,
+// serves the DHT and AutoNAT to them and helps them find each other. It
+// doesn't need root.
+type Relay struct {
+	cfg           *config.Config
+	p2p           host.Host
+	dht           *dht.IpfsDHT
+	pex           *p2p.PeX
+	gossip        *p2p.Gossip
+	book          *p2p.AddrBook
+	rendezvous    *p2p.Rendezvous
+	discovery     *p2p.Discovery
+	ctx           context.Context
+	cancel        func()
+	configPath    string
+	interfaceName string
+	wg            *sync.WaitGroup
+}
+
+func NewRelay(ctx context.Context, configPath string, ifName string) Relay {
+	innerCtx, ctxCancel := context.WithCancel(ctx)
+
+	return Relay{
+		cfg:           &config.Config{},
+		ctx:           innerCtx,
+		cancel:        ctxCancel,
+		configPath:    configPath,
+		interfaceName: ifName,
+	}
+}
+
+// rejectStream refuses tunnel traffic, there is no interface to deliver it to.
+func rejectStream(stream network.Stream) {
+	stream.Reset()
+}
+
+func (r *Relay) Run() error {
+	// Read in configuration from file.
+	cfg, err := config.Read(r.configPath)
+	if err != nil {
+		logger.With(err).Error("Failed to read config")
+		return err
+	}
+	cfg.Interface = r.interfaceName
+	if cfg.Routing.DHT != config.DHTNone {
+		cfg.Routing.DHTServer = true
+	}
+	r.cfg = cfg
+
+	logger.Info("Creating LibP2P node")
+
+	r.pex = p2p.NewPeX(r.cfg)
+	r.book = p2p.NewAddrBook(r.cfg)
+	r.rendezvous = p2p.NewRendezvous(r.cfg, r.pex)
+	r.p2p, r.dht, err = p2p.CreateNode(
+		r.ctx,
+		r.cfg,
+		rejectStream,
+		r.pex,
+		p2p.NewUpgrader(r.cfg),
+		r.book,
+		r.rendezvous,
+		p2p.NewClosedCircuitRelayFilter(r.cfg),
+		newConnectionGater(r.cfg),
+		nil,
+	)
+	if err != nil {
+		logger.With(err).Error("Failed to create Libp2p node")
+		return err
+	}
+
+	for _, p := range r.cfg.Peers {
+		r.p2p.ConnManager().Protect(p.ID, "/hyprspace/peer")
+	}
+
+	r.wg = &sync.WaitGroup{}
+
+	r.discovery = p2p.NewDiscovery(r.p2p, r.dht, r.cfg.Peers)
+	go r.discovery.Service(r.ctx, r.wg)
+	go r.pex.Service(r.ctx, r.wg)
+	go r.rendezvous.Service(r.ctx, r.wg)
+	go r.book.Service(r.ctx, r.wg)
+
+	r.gossip, err = p2p.NewGossip(r.ctx, r.p2p, r.cfg)
+	if err != nil {
+		logger.With(err).Error("Failed to set up gossip")
+		return err
+	}
+	go r.gossip.Service(r.ctx, r.wg)
+
+	go p2p.NewProber(r.p2p, r.cfg).Service(r.ctx, r.wg)
+	serveMetrics()
+
+	logger.Info("Relay ready")
+	for _, addr := range r.p2p.Addrs() {
+		logger.Infof("Listening on %s/p2p/%s", addr, r.p2p.ID())
+	}
+	return nil
+}
+
+func (r *Relay) Rebootstrap() {
+	r.p2p.ConnManager().TrimOpenConns(context.Background())
+	if r.dht != nil {
+		<-r.dht.ForceRefresh()
+	}
+	r.discovery.Rediscover()
+}
+
+func (r *Relay) Stop() error {
+	r.gossip.Leave(r.ctx)
+	if err := r.book.Save(); err != nil {
+		logger.With(err).Warn("Failed to save address book")
+	}
+	err := r.p2p.Close()
+	if err != nil {
+		return err
+	}
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
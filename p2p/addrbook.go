@@ -0,0 +1,219 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// How often the address book is written to disk.
+const addrBookInterval = time.Minute
+
+// How long remembered addresses stay in the peerstore after loading them.
+const addrBookTTL = time.Hour
+
+// Peers we haven't been connected to for this long are forgotten.
+const addrBookExpiry = 30 * 24 * time.Hour
+
+// addrBookEntry is how we last reached a VPN peer.
+type addrBookEntry struct {
+	Addrs    []string      `json:"addrs"`
+	Relays   []string      `json:"relays,omitempty"`
+	Latency  time.Duration `json:"latency,omitempty"`
+	LastSeen time.Time     `json:"lastSeen"`
+}
+
+// AddrBook remembers the last known good addresses of VPN peers across
+// restarts, so we can reconnect without waiting for peer routing.
+type AddrBook struct {
+	host    host.Host
+	config  *config.Config
+	lock    sync.Mutex
+	entries map[peer.ID]addrBookEntry
+}
+
+func NewAddrBook(cfg *config.Config) *AddrBook {
+	return &AddrBook{
+		config:  cfg,
+		entries: make(map[peer.ID]addrBookEntry),
+	}
+}
+
+// load reads the state file. A missing state file is not an error.
+func (ab *AddrBook) load() error {
+	data, err := os.ReadFile(ab.config.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var entries map[peer.ID]addrBookEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	for p, e := range entries {
+		if _, found := ab.config.PeerByID(p); !found || time.Since(e.LastSeen) > addrBookExpiry {
+			continue
+		}
+		ab.entries[p] = e
+	}
+	return nil
+}
+
+func parseAddrs(addrs []string) []ma.Multiaddr {
+	var result []ma.Multiaddr
+	for _, s := range addrs {
+		if a, err := ma.NewMultiaddr(s); err == nil {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// start loads the state file into the peerstore of h.
+func (ab *AddrBook) start(h host.Host) {
+	ab.host = h
+	if err := ab.load(); err != nil {
+		logger.With(zap.String("file", ab.config.StateFile), zap.Error(err)).Warn("Failed to load address book")
+		return
+	}
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	for p, e := range ab.entries {
+		h.Peerstore().AddAddrs(p, parseAddrs(e.Addrs), addrBookTTL)
+		if relays, err := peer.AddrInfosFromP2pAddrs(parseAddrs(e.Relays)...); err == nil {
+			for _, r := range relays {
+				h.Peerstore().AddAddrs(r.ID, r.Addrs, addrBookTTL)
+			}
+		}
+		if e.Latency > 0 {
+			h.Peerstore().RecordLatency(p, e.Latency)
+		}
+	}
+	logger.With(zap.Int("peers", len(ab.entries))).Debug("Loaded address book")
+}
+
+// Addrs returns the remembered addresses of p.
+func (ab *AddrBook) Addrs(p peer.ID) []ma.Multiaddr {
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	return parseAddrs(ab.entries[p].Addrs)
+}
+
+// relayOf returns the address of the relay in a circuit address.
+func relayOf(a ma.Multiaddr) ma.Multiaddr {
+	relay, _ := ma.SplitFunc(a, func(c ma.Component) bool {
+		return c.Protocol().Code == ma.P_CIRCUIT
+	})
+	return relay
+}
+
+// sameHost reports whether a and b start with the same IP address or DNS name.
+func sameHost(a ma.Multiaddr, b ma.Multiaddr) bool {
+	return len(a) > 0 && len(b) > 0 && a[0].Equal(&b[0])
+}
+
+// update records how we are connected to VPN peers right now. Peers we
+// aren't connected to keep their previous entry.
+func (ab *AddrBook) update() {
+	now := time.Now()
+	ab.lock.Lock()
+	defer ab.lock.Unlock()
+	for _, p := range ab.config.Peers {
+		conns := ab.host.Network().ConnsToPeer(p.ID)
+		if len(conns) == 0 {
+			continue
+		}
+		e := addrBookEntry{
+			Latency:  ab.host.Peerstore().LatencyEWMA(p.ID),
+			LastSeen: now,
+		}
+		var addrs []ma.Multiaddr
+		var relays []ma.Multiaddr
+		for _, c := range conns {
+			if c.Stat().Direction == network.DirInbound && !IsRelayedAddr(c.RemoteMultiaddr()) {
+				// The source port of inbound connections is usually
+				// ephemeral, remember the listen addresses the peer has
+				// on the same host instead.
+				for _, a := range ab.host.Peerstore().Addrs(p.ID) {
+					if sameHost(a, c.RemoteMultiaddr()) {
+						addrs = append(addrs, a)
+					}
+				}
+				continue
+			}
+			addrs = append(addrs, c.RemoteMultiaddr())
+			if IsRelayedAddr(c.RemoteMultiaddr()) {
+				if relay := relayOf(c.RemoteMultiaddr()); relay != nil {
+					relays = append(relays, relay)
+				}
+			}
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+		for _, a := range ma.Unique(addrs) {
+			e.Addrs = append(e.Addrs, a.String())
+		}
+		for _, r := range ma.Unique(relays) {
+			e.Relays = append(e.Relays, r.String())
+		}
+		ab.entries[p.ID] = e
+	}
+}
+
+// Save records the current connections to VPN peers and writes the state
+// file.
+func (ab *AddrBook) Save() error {
+	ab.update()
+	ab.lock.Lock()
+	data, err := json.MarshalIndent(ab.entries, "", "  ")
+	ab.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ab.config.StateFile), ".hyprspace-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ab.config.StateFile)
+}
+
+func (ab *AddrBook) Service(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	defer wg.Done()
+	ticker := time.NewTicker(addrBookInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ab.Save(); err != nil {
+				logger.With(zap.String("file", ab.config.StateFile), zap.Error(err)).Warn("Failed to save address book")
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package p2p
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestAddrBook(t *testing.T, entries map[peer.ID]addrBookEntry) string {
+	path := filepath.Join(t.TempDir(), "hyprspace.state.json")
+	data, err := json.Marshal(entries)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func Test_AddrBook(t *testing.T) {
+	ids := makeTestIDs(t, 3)
+	relay := "/ip4/203.0.113.1/udp/8001/quic-v1/p2p/" + ids[2].String()
+	entries := map[peer.ID]addrBookEntry{
+		ids[0]: {
+			Addrs:    []string{"/ip4/192.0.2.1/tcp/8001", relay + "/p2p-circuit"},
+			Relays:   []string{relay},
+			Latency:  20 * time.Millisecond,
+			LastSeen: time.Now(),
+		},
+		ids[1]: {
+			Addrs:    []string{"/ip4/192.0.2.2/tcp/8001"},
+			LastSeen: time.Now().Add(-2 * addrBookExpiry),
+		},
+		ids[2]: {
+			Addrs:    []string{"/ip4/192.0.2.3/tcp/8001"},
+			LastSeen: time.Now(),
+		},
+	}
+
+	t.Run("load into peerstore", func(t *testing.T) {
+		cfg := makeTestConfig(ids[0], ids[1])
+		cfg.StateFile = writeTestAddrBook(t, entries)
+		ab := NewAddrBook(cfg)
+		h := makeTestHost(t)
+		ab.start(h)
+
+		assert.Len(t, h.Peerstore().Addrs(ids[0]), 2)
+		assert.Len(t, ab.Addrs(ids[0]), 2)
+		assert.Equal(t, 20*time.Millisecond, h.Peerstore().LatencyEWMA(ids[0]))
+		assert.Len(t, h.Peerstore().Addrs(ids[2]), 1, "relay address should be known")
+		assert.Empty(t, ab.Addrs(ids[1]), "expired entry should be dropped")
+		assert.Empty(t, ab.Addrs(ids[2]), "non-member should be dropped")
+	})
+
+	t.Run("save keeps disconnected peers", func(t *testing.T) {
+		cfg := makeTestConfig(ids[0])
+		cfg.StateFile = writeTestAddrBook(t, entries)
+		ab := NewAddrBook(cfg)
+		ab.start(makeTestHost(t))
+		require.NoError(t, ab.Save())
+
+		ab2 := NewAddrBook(cfg)
+		require.NoError(t, ab2.load())
+		assert.Equal(t, ab.Addrs(ids[0]), ab2.Addrs(ids[0]))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		cfg := makeTestConfig(ids[0])
+		cfg.StateFile = filepath.Join(t.TempDir(), "missing.json")
+		assert.NoError(t, NewAddrBook(cfg).load())
+	})
+
+	t.Run("corrupt file", func(t *testing.T) {
+		cfg := makeTestConfig(ids[0])
+		cfg.StateFile = filepath.Join(t.TempDir(), "corrupt.json")
+		require.NoError(t, os.WriteFile(cfg.StateFile, []byte("{"), 0o600))
+		assert.Error(t, NewAddrBook(cfg).load())
+	})
+}
+
+func Test_relayOf(t *testing.T) {
+	relay := multiaddr.StringCast("/ip4/203.0.113.1/udp/8001/quic-v1/p2p/12D3KooWQWsHPUUeFhe4b6pyCaD1hBoj8j6Z7S7kTznRTh1p1eVt")
+	circuit := relay.Encapsulate(multiaddr.StringCast("/p2p-circuit"))
+	assert.True(t, relay.Equal(relayOf(circuit)))
+}
@@ -12,85 +12,220 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
-	"github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap"
 )
 
-var discoverNow = make(chan bool)
 var logger = log.Logger("hyprspace/p2p")
 
 // mdnsNotifee handles peers discovered via mDNS.
 type mdnsNotifee struct {
-	h     host.Host
-	peers []config.Peer
+	h   host.Host
+	cfg *config.Config
 }
 
 func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
 	// Only connect to peers that are in our VPN config.
-	if _, ok := config.FindPeer(n.peers, pi.ID); !ok {
+	if _, ok := n.cfg.PeerByID(pi.ID); !ok {
 		return
 	}
 	logger.With(zap.String("peer", pi.ID.String()), zap.Int("addrs", len(pi.Addrs))).Info("Discovered peer via mDNS")
-	ctx, _ := context.WithDeadline(context.Background(), time.Now().Add(5*time.Second))
-	go n.h.Connect(ctx, pi)
+	go func() {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(5*time.Second))
+		defer cancel()
+		n.h.Connect(ctx, pi)
+	}()
 }
 
 // SetupMDNS starts the mDNS discovery service. Discovered peers that match
 // the VPN's peer list are added to the peerstore so the connection loop
 // can reach them without DHT bootstrap nodes.
-func SetupMDNS(h host.Host, peers []config.Peer) error {
-	notifee := &mdnsNotifee{h: h, peers: peers}
+func SetupMDNS(h host.Host, cfg *config.Config) (mdns.Service, error) {
+	notifee := &mdnsNotifee{h: h, cfg: cfg}
 	svc := mdns.NewMdnsService(h, "_p2p._udp", notifee)
-	return svc.Start()
+	return svc, svc.Start()
 }
 
-// Discover starts up a DHT based discovery system finding and adding nodes with the same rendezvous string.
-func Discover(ctx context.Context, wg *sync.WaitGroup, h host.Host, dht *dht.IpfsDHT, peers []config.Peer) {
-	dur := time.Second * 1
-	ticker := time.NewTicker(dur)
+// Connection states of a VPN peer.
+const (
+	PeerIdle      = "idle"
+	PeerDialing   = "dialing"
+	PeerConnected = "connected"
+	PeerBackoff   = "backoff"
+)
+
+// Backoff between dials to a VPN peer we can't connect to.
+const (
+	dialMinBackoff = time.Second
+	dialMaxBackoff = time.Minute
+)
+
+// Minimum time between two dials to a peer when rediscovering.
+const rediscoverDelay = 3 * time.Second
+
+// Upper bounds for a single dial and the number of dials in flight.
+const (
+	dialTimeout     = 30 * time.Second
+	dialConcurrency = 16
+)
+
+// PeerDialStatus is the connection state of a VPN peer.
+type PeerDialStatus struct {
+	State         string
+	Failures      int
+	LastError     string
+	LastErrorTime time.Time
+	NextRetry     time.Time
+}
+
+// Discovery keeps us connected to all VPN peers. Each peer is dialed on its
+// own schedule, backing off exponentially while it can't be reached.
+type Discovery struct {
+	host  host.Host
+	dht   *dht.IpfsDHT
+	peers []config.Peer
+	lock  sync.Mutex
+	state map[peer.ID]*PeerDialStatus
+	now   chan struct{}
+}
+
+func NewDiscovery(h host.Host, dht *dht.IpfsDHT, peers []config.Peer) *Discovery {
+	d := &Discovery{
+		host:  h,
+		dht:   dht,
+		peers: peers,
+		state: make(map[peer.ID]*PeerDialStatus, len(peers)),
+		now:   make(chan struct{}, 1),
+	}
+	for _, p := range peers {
+		d.state[p.ID] = &PeerDialStatus{State: PeerIdle}
+	}
+	return d
+}
+
+func dialBackoff(failures int) time.Duration {
+	d := dialMinBackoff << min(failures-1, 16)
+	if d > dialMaxBackoff || d <= 0 {
+		d = dialMaxBackoff
+	}
+	return jitter(d)
+}
+
+// Status returns the connection state of all VPN peers.
+func (d *Discovery) Status() map[peer.ID]PeerDialStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	status := make(map[peer.ID]PeerDialStatus, len(d.state))
+	for p, st := range d.state {
+		status[p] = *st
+	}
+	return status
+}
+
+// due updates the state of all VPN peers and returns the ones that should be
+// dialed now, marking them as dialing. Also reports whether we are connected
+// to any VPN peer.
+func (d *Discovery) due(now time.Time, max int) (peers []peer.ID, connectedToAny bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, p := range d.peers {
+		st := d.state[p.ID]
+		if d.host.Network().Connectedness(p.ID) == network.Connected {
+			st.State = PeerConnected
+			st.Failures = 0
+			st.NextRetry = time.Time{}
+			connectedToAny = true
+			continue
+		}
+		switch st.State {
+		case PeerDialing:
+			continue
+		case PeerConnected:
+			st.State = PeerIdle
+		}
+		if len(peers) >= max || now.Before(st.NextRetry) {
+			continue
+		}
+		st.State = PeerDialing
+		peers = append(peers, p.ID)
+	}
+	return
+}
+
+func (d *Discovery) dial(ctx context.Context, p peer.ID) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	// Fixed addresses from the config are permanent in the peerstore, so
+	// they are dialed without waiting for a routing lookup.
+	err := d.host.Connect(ctx, peer.AddrInfo{ID: p})
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	st := d.state[p]
+	if err == nil {
+		st.State = PeerConnected
+		st.Failures = 0
+		st.NextRetry = time.Time{}
+		return
+	}
+	st.State = PeerBackoff
+	st.Failures++
+	st.LastError = err.Error()
+	st.LastErrorTime = time.Now()
+	st.NextRetry = st.LastErrorTime.Add(dialBackoff(st.Failures))
+	logger.With(zap.String("peer", p.String()), zap.Int("failures", st.Failures), zap.Error(err)).Debug("Failed to connect to peer")
+}
+
+// retrySoon shortens the backoff of all peers, so each of them is dialed
+// again at most rediscoverDelay after its last failure.
+func (d *Discovery) retrySoon() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, st := range d.state {
+		if retry := st.LastErrorTime.Add(rediscoverDelay); retry.Before(st.NextRetry) {
+			st.NextRetry = retry
+		}
+	}
+}
+
+// Service dials VPN peers we aren't connected to.
+func (d *Discovery) Service(ctx context.Context, wg *sync.WaitGroup) {
+	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
 	wg.Add(1)
 	defer wg.Done()
 
+	sem := make(chan struct{}, dialConcurrency)
+	var lastBootstrap time.Time
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-discoverNow:
-			dur = time.Second * 3
-			// Immediately trigger discovery
-			ticker.Reset(time.Millisecond * 1)
+		case <-d.now:
+			d.retrySoon()
 		case <-ticker.C:
-			connectedToAny := false
-			for _, p := range peers {
-				if h.Network().Connectedness(p.ID) != network.Connected {
-					err := h.Connect(ctx, peer.AddrInfo{
-						ID:    p.ID,
-						Addrs: []multiaddr.Multiaddr{},
-					})
-					if err != nil {
-						continue
-					}
-					connectedToAny = true
-				} else {
-					connectedToAny = true
-				}
-			}
-			if !connectedToAny {
-				logger.Debug("Not connected to any peers, attempting to bootstrap again")
-				dht.Bootstrap(ctx)
-				dht.RefreshRoutingTable()
-				dur = time.Second * 10
-				ticker.Reset(dur)
-			} else {
-				dur = min(dur*2, time.Minute)
-				ticker.Reset(dur)
-			}
+		}
+		peers, connectedToAny := d.due(time.Now(), dialConcurrency-len(sem))
+		for _, p := range peers {
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem }()
+				d.dial(ctx, p)
+			}()
+		}
+		if d.dht != nil && !connectedToAny && len(d.peers) > 0 && time.Since(lastBootstrap) > 10*time.Second {
+			logger.Debug("Not connected to any peers, attempting to bootstrap again")
+			lastBootstrap = time.Now()
+			d.dht.Bootstrap(ctx)
+			d.dht.RefreshRoutingTable()
 		}
 	}
 }
 
-func Rediscover() {
-	discoverNow <- true
+// Rediscover cuts the backoff of VPN peers we aren't connected to short.
+func (d *Discovery) Rediscover() {
+	select {
+	case d.now <- struct{}{}:
+	default:
+	}
 }
@@ -0,0 +1,88 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_dialBackoff(t *testing.T) {
+	assert.InDelta(t, dialMinBackoff, dialBackoff(1), float64(dialMinBackoff)/5)
+	assert.InDelta(t, 4*dialMinBackoff, dialBackoff(3), float64(4*dialMinBackoff)/5)
+	assert.InDelta(t, dialMaxBackoff, dialBackoff(100), float64(dialMaxBackoff)/5)
+}
+
+func makeTestDiscovery(t *testing.T, members ...peer.ID) *Discovery {
+	return NewDiscovery(makeTestHost(t), nil, makeTestConfig(members...).Peers)
+}
+
+func Test_Discovery_due(t *testing.T) {
+	ids := makeTestIDs(t, 3)
+	now := time.Now()
+
+	t.Run("new peers are due", func(t *testing.T) {
+		d := makeTestDiscovery(t, ids...)
+		peers, connected := d.due(now, dialConcurrency)
+		assert.ElementsMatch(t, ids, peers)
+		assert.False(t, connected)
+		for _, st := range d.Status() {
+			assert.Equal(t, PeerDialing, st.State)
+		}
+		peers, _ = d.due(now, dialConcurrency)
+		assert.Empty(t, peers, "peers being dialed should not be dialed again")
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		d := makeTestDiscovery(t, ids...)
+		peers, _ := d.due(now, 2)
+		assert.Len(t, peers, 2)
+		peers, _ = d.due(now, 2)
+		assert.Len(t, peers, 1)
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		d := makeTestDiscovery(t, ids[0])
+		st := d.state[ids[0]]
+		st.State = PeerBackoff
+		st.Failures = 3
+		st.LastErrorTime = now
+		st.NextRetry = now.Add(time.Hour)
+
+		peers, _ := d.due(now, dialConcurrency)
+		assert.Empty(t, peers)
+		peers, _ = d.due(now.Add(time.Hour), dialConcurrency)
+		assert.Equal(t, []peer.ID{ids[0]}, peers)
+	})
+
+	t.Run("rediscover", func(t *testing.T) {
+		d := makeTestDiscovery(t, ids[0])
+		st := d.state[ids[0]]
+		st.State = PeerBackoff
+		st.LastErrorTime = now
+		st.NextRetry = now.Add(time.Hour)
+
+		d.retrySoon()
+		assert.Equal(t, now.Add(rediscoverDelay), d.Status()[ids[0]].NextRetry)
+		peers, _ := d.due(now.Add(rediscoverDelay), dialConcurrency)
+		assert.Equal(t, []peer.ID{ids[0]}, peers)
+	})
+
+	t.Run("rediscover is per network", func(t *testing.T) {
+		d := makeTestDiscovery(t, ids[0])
+		other := makeTestDiscovery(t, ids[1])
+		d.Rediscover()
+		d.Rediscover()
+		assert.Len(t, d.now, 1)
+		assert.Empty(t, other.now)
+	})
+
+	t.Run("no peers", func(t *testing.T) {
+		d := NewDiscovery(makeTestHost(t), nil, []config.Peer{})
+		peers, connected := d.due(now, dialConcurrency)
+		assert.Empty(t, peers)
+		assert.False(t, connected)
+	})
+}
@@ -1,8 +1,7 @@
 package p2p
 
 import (
-	"net"
-
+	"github.com/hyprspace/hyprspace/config"
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/control"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -14,13 +13,31 @@ type FilterGater struct {
 	Filters *multiaddr.Filters
 }
 
-func NewFilterGater(networks ...net.IPNet) connmgr.ConnectionGater {
+// NewAddrFilters returns the address filters from the config. Allowed networks
+// are added last, so they win over denied ones.
+func NewAddrFilters(cfg *config.Config) *multiaddr.Filters {
 	filters := multiaddr.NewFilters()
-	for _, network := range networks {
+	for _, network := range cfg.AddressFilters.Deny {
 		filters.AddFilter(network, multiaddr.ActionDeny)
 	}
+	for _, network := range cfg.AddressFilters.Allow {
+		filters.AddFilter(network, multiaddr.ActionAccept)
+	}
+	return filters
+}
+
+func NewFilterGater(cfg *config.Config) connmgr.ConnectionGater {
 	return FilterGater{
-		Filters: filters,
+		Filters: NewAddrFilters(cfg),
+	}
+}
+
+// filterAddrs keeps filtered addresses from being announced.
+func filterAddrs(filters *multiaddr.Filters) func([]multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	return func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		return multiaddr.FilterAddrs(addrs, func(a multiaddr.Multiaddr) bool {
+			return !filters.AddrBlocked(a)
+		})
 	}
 }
 
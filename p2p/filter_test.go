@@ -0,0 +1,33 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewAddrFilters(t *testing.T) {
+	cidr := func(s string) net.IPNet {
+		_, n, _ := net.ParseCIDR(s)
+		return *n
+	}
+	cfg := makeTestConfig()
+	cfg.AddressFilters = config.AddressFilters{
+		Deny:  []net.IPNet{cidr("172.16.0.0/12")},
+		Allow: []net.IPNet{cidr("172.20.0.0/16")},
+	}
+	filters := NewAddrFilters(cfg)
+
+	docker := multiaddr.StringCast("/ip4/172.17.0.1/tcp/8001")
+	office := multiaddr.StringCast("/ip4/172.20.3.4/tcp/8001")
+	public := multiaddr.StringCast("/ip4/203.0.113.1/udp/8001/quic-v1")
+	assert.True(t, filters.AddrBlocked(docker))
+	assert.False(t, filters.AddrBlocked(office), "allowed networks win over denied ones")
+	assert.False(t, filters.AddrBlocked(public))
+
+	announced := filterAddrs(filters)([]multiaddr.Multiaddr{docker, office, public})
+	assert.Equal(t, []multiaddr.Multiaddr{office, public}, announced)
+}
@@ -0,0 +1,299 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/zap"
+)
+
+// GossipProtocol is the GossipSub protocol spoken between VPN peers. It is
+// separate from the public meshsub protocols, so we never join a mesh with
+// nodes outside of the network.
+const GossipProtocol = "/hyprspace/meshsub/1.2.0"
+
+// StateTopic carries the state announcements of VPN peers.
+const StateTopic = "/hyprspace/state/1"
+
+// Version is announced to other VPN peers as part of our state.
+var Version = "develop"
+
+// How often we announce our state, even when nothing changed.
+const stateInterval = 5 * time.Minute
+
+// How long a peer is considered online after its last announcement.
+const StateExpiry = 3 * stateInterval
+
+// Minimum time between two announcements triggered by peers joining.
+const stateMinInterval = 10 * time.Second
+
+// Upper bounds for a single state announcement.
+const (
+	stateMaxSize    = 16 << 10
+	stateMaxEntries = 256
+	stateMaxName    = 63
+)
+
+var errStateMalformed = errors.New("malformed state announcement")
+var errStateNotMember = errors.New("state announced by non-member")
+
+// NodeState is the state a node announces about itself.
+type NodeState struct {
+	Name     string   `json:"name"`
+	Services []string `json:"services,omitempty"`
+	Routes   []string `json:"routes,omitempty"`
+	Version  string   `json:"version"`
+	Online   bool     `json:"online"`
+	// Time of the announcement in nanoseconds since the epoch. Announcements
+	// that are older than the last one we have seen are dropped.
+	Time int64 `json:"time"`
+}
+
+// PeerState is the last state announced by a VPN peer.
+type PeerState struct {
+	NodeState
+	LastSeen time.Time
+}
+
+// IsOnline reports whether the peer announced itself as online recently.
+func (ps PeerState) IsOnline() bool {
+	return ps.Online && time.Since(ps.LastSeen) < StateExpiry
+}
+
+// Gossip spreads the state of each VPN peer to all other VPN peers over a
+// private GossipSub topic.
+type Gossip struct {
+	host    host.Host
+	config  *config.Config
+	pubsub  *pubsub.PubSub
+	topic   *pubsub.Topic
+	lock    sync.RWMutex
+	states  map[peer.ID]PeerState
+	publish chan struct{}
+}
+
+func gossipFeatures(feat pubsub.GossipSubFeature, proto protocol.ID) bool {
+	if proto != GossipProtocol {
+		return false
+	}
+	switch feat {
+	case pubsub.GossipSubFeatureMesh, pubsub.GossipSubFeatureIdontwant:
+		return true
+	default:
+		return false
+	}
+}
+
+func NewGossip(ctx context.Context, h host.Host, cfg *config.Config) (*Gossip, error) {
+	g := &Gossip{
+		host:    h,
+		config:  cfg,
+		states:  make(map[peer.ID]PeerState),
+		publish: make(chan struct{}, 1),
+	}
+	ps, err := pubsub.NewGossipSub(ctx, h,
+		pubsub.WithGossipSubProtocols([]protocol.ID{GossipProtocol}, gossipFeatures),
+		pubsub.WithPeerFilter(func(p peer.ID, _ string) bool {
+			_, found := cfg.PeerByID(p)
+			return found
+		}),
+		pubsub.WithMessageSignaturePolicy(pubsub.StrictSign),
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = ps.RegisterTopicValidator(StateTopic, g.validate)
+	if err != nil {
+		return nil, err
+	}
+	g.topic, err = ps.Join(StateTopic)
+	if err != nil {
+		return nil, err
+	}
+	g.pubsub = ps
+	return g, nil
+}
+
+// parseState decodes and checks a state announcement by author.
+func (g *Gossip) parseState(author peer.ID, data []byte) (NodeState, error) {
+	var state NodeState
+	if _, found := g.config.PeerByID(author); !found {
+		return state, errStateNotMember
+	}
+	if len(data) > stateMaxSize {
+		return state, errStateMalformed
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("%w: %w", errStateMalformed, err)
+	}
+	if len(state.Name) > stateMaxName || len(state.Services) > stateMaxEntries || len(state.Routes) > stateMaxEntries {
+		return state, errStateMalformed
+	}
+	for _, r := range state.Routes {
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return state, fmt.Errorf("%w: %w", errStateMalformed, err)
+		}
+	}
+	return state, nil
+}
+
+func (g *Gossip) validate(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	author := msg.GetFrom()
+	if author == g.host.ID() {
+		return pubsub.ValidationAccept
+	}
+	state, err := g.parseState(author, msg.Data)
+	if err != nil {
+		logger.With(zap.String("peer", author.String()), zap.String("from", from.String()), zap.Error(err)).Debug("Rejecting state announcement")
+		return pubsub.ValidationReject
+	}
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	if known, ok := g.states[author]; ok && known.Time >= state.Time {
+		return pubsub.ValidationIgnore
+	}
+	return pubsub.ValidationAccept
+}
+
+// store records a validated state announcement. Returns false if we already
+// have a newer one.
+func (g *Gossip) store(author peer.ID, state NodeState) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if known, ok := g.states[author]; ok && known.Time >= state.Time {
+		return false
+	}
+	g.states[author] = PeerState{
+		NodeState: state,
+		LastSeen:  time.Now(),
+	}
+	return true
+}
+
+// States returns the last known state of every VPN peer that announced one.
+func (g *Gossip) States() map[peer.ID]PeerState {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return maps.Clone(g.states)
+}
+
+func (g *Gossip) localState(online bool) NodeState {
+	routes := make([]string, 0, len(g.config.AdvertiseRoutes))
+	for _, r := range g.config.AdvertiseRoutes {
+		routes = append(routes, r.String())
+	}
+	return NodeState{
+		Name:     g.config.Name,
+		Services: slices.Sorted(maps.Keys(g.config.Services)),
+		Routes:   routes,
+		Version:  Version,
+		Online:   online,
+		Time:     time.Now().UnixNano(),
+	}
+}
+
+func (g *Gossip) announce(ctx context.Context, online bool) error {
+	data, err := json.Marshal(g.localState(online))
+	if err != nil {
+		return err
+	}
+	return g.topic.Publish(ctx, data)
+}
+
+// Leave announces that we are going offline.
+func (g *Gossip) Leave(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := g.announce(ctx, false); err != nil {
+		logger.With(err).Warn("Failed to announce shutdown")
+	}
+}
+
+func (g *Gossip) receive(ctx context.Context, sub *pubsub.Subscription) {
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			return
+		}
+		author := msg.GetFrom()
+		if author == g.host.ID() {
+			continue
+		}
+		// Already checked by the validator, this can't fail.
+		state, err := g.parseState(author, msg.Data)
+		if err != nil {
+			continue
+		}
+		if g.store(author, state) {
+			logger.With(zap.String("peer", author.String()), zap.String("name", state.Name), zap.Bool("online", state.Online)).Debug("Received state announcement")
+		}
+	}
+}
+
+func (g *Gossip) Service(ctx context.Context, wg *sync.WaitGroup) {
+	sub, err := g.topic.Subscribe()
+	if err != nil {
+		logger.With(err).Fatal("Failed to subscribe to state topic")
+	}
+	events, err := g.topic.EventHandler()
+	if err != nil {
+		logger.With(err).Fatal("Failed to subscribe to state topic events")
+	}
+	logger.Info("Gossip service ready")
+	wg.Add(1)
+	defer wg.Done()
+	defer sub.Cancel()
+	defer events.Cancel()
+	go g.receive(ctx, sub)
+	go func() {
+		for {
+			evt, err := events.NextPeerEvent(ctx)
+			if err != nil {
+				return
+			}
+			// Let peers that just joined know about us right away.
+			if evt.Type == pubsub.PeerJoin {
+				select {
+				case g.publish <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug("Stopping Gossip service")
+			return
+		case <-ticker.C:
+		case <-g.publish:
+			if wait := stateMinInterval - time.Since(last); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+		}
+		last = time.Now()
+		if err := g.announce(ctx, true); err != nil && ctx.Err() == nil {
+			logger.With(err).Warn("Failed to announce state")
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Gossip_parseState(t *testing.T) {
+	ids := makeTestIDs(t, 2)
+	member, outsider := ids[0], ids[1]
+	g, err := NewGossip(context.Background(), makeTestHost(t), makeTestConfig(member))
+	require.NoError(t, err)
+
+	encode := func(state NodeState) []byte {
+		data, err := json.Marshal(state)
+		require.NoError(t, err)
+		return data
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		state, err := g.parseState(member, encode(NodeState{
+			Name:     "router",
+			Services: []string{"www"},
+			Routes:   []string{"10.10.0.0/16", "2001:db8::/64"},
+			Version:  "1.2.3",
+			Online:   true,
+			Time:     1,
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, "router", state.Name)
+		assert.Len(t, state.Routes, 2)
+	})
+
+	t.Run("not a member", func(t *testing.T) {
+		_, err := g.parseState(outsider, encode(NodeState{Name: "evil", Online: true, Time: 1}))
+		assert.ErrorIs(t, err, errStateNotMember)
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		_, err := g.parseState(member, []byte("garbage"))
+		assert.ErrorIs(t, err, errStateMalformed)
+	})
+
+	t.Run("invalid route", func(t *testing.T) {
+		_, err := g.parseState(member, encode(NodeState{Routes: []string{"10.10.0.0"}, Time: 1}))
+		assert.ErrorIs(t, err, errStateMalformed)
+	})
+
+	t.Run("name too long", func(t *testing.T) {
+		_, err := g.parseState(member, encode(NodeState{Name: strings.Repeat("a", stateMaxName+1), Time: 1}))
+		assert.ErrorIs(t, err, errStateMalformed)
+	})
+}
+
+func Test_Gossip_store(t *testing.T) {
+	member := makeTestIDs(t, 1)[0]
+	g, err := NewGossip(context.Background(), makeTestHost(t), makeTestConfig(member))
+	require.NoError(t, err)
+
+	assert.True(t, g.store(member, NodeState{Name: "a", Online: true, Time: 2}))
+	assert.False(t, g.store(member, NodeState{Name: "b", Online: true, Time: 1}), "older announcement should be dropped")
+	assert.False(t, g.store(member, NodeState{Name: "b", Online: true, Time: 2}), "replayed announcement should be dropped")
+
+	state, ok := g.States()[member]
+	require.True(t, ok)
+	assert.Equal(t, "a", state.Name)
+	assert.True(t, state.IsOnline())
+
+	assert.True(t, g.store(member, NodeState{Name: "a", Online: false, Time: 3}))
+	assert.False(t, g.States()[member].IsOnline())
+}
@@ -0,0 +1,258 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Backoff between attempts to get a direct connection to a VPN peer that we
+// are only connected to through a relay.
+const (
+	upgradeMinBackoff = 30 * time.Second
+	upgradeMaxBackoff = 30 * time.Minute
+)
+
+// How many past attempts to keep per peer.
+const upgradeMaxAttempts = 8
+
+// Kinds of attempts to get a direct connection.
+const (
+	AttemptDirectDial = "direct dial"
+	AttemptHolePunch  = "hole punch"
+	AttemptProtocol   = "protocol error"
+)
+
+// HolePunchAttempt is the outcome of a single attempt to get a direct
+// connection to a peer.
+type HolePunchAttempt struct {
+	Time     time.Time
+	Kind     string
+	Success  bool
+	Duration time.Duration
+	Error    string
+}
+
+// HolePunchStatus is the state of the direct connection upgrade for a peer.
+type HolePunchStatus struct {
+	Attempts  []HolePunchAttempt
+	Failures  int
+	NextRetry time.Time
+}
+
+type upgradeState struct {
+	HolePunchStatus
+	inFlight bool
+}
+
+// Upgrader runs hole punching and retries it with backoff for VPN peers we
+// are only connected to through a relay.
+type Upgrader struct {
+	host    host.Host
+	config  *config.Config
+	service *holepunch.Service
+	shared  bool
+	lock    sync.Mutex
+	peers   map[peer.ID]*upgradeState
+}
+
+func NewUpgrader(cfg *config.Config) *Upgrader {
+	return &Upgrader{
+		config: cfg,
+		peers:  make(map[peer.ID]*upgradeState),
+	}
+}
+
+// start creates the hole punching service for h. It replaces the one built
+// into libp2p, so we can trigger attempts ourselves. The events of the service
+// go to tracer.
+func (u *Upgrader) start(h host.Host, tracer holepunch.EventTracer) error {
+	idh, ok := h.(interface{ IDService() identify.IDService })
+	if !ok {
+		return errors.New("host doesn't provide an identify service")
+	}
+	u.host = h
+	var err error
+	u.service, err = holepunch.NewService(h, idh.IDService(), u.holePunchAddrs,
+		holepunch.WithMetricsAndEventTracer(holepunch.NewMetricsTracer(), tracer),
+	)
+	return err
+}
+
+// holePunchAddrs returns our public, non-relayed addresses.
+func (u *Upgrader) holePunchAddrs() []ma.Multiaddr {
+	return ma.FilterAddrs(u.host.Addrs(),
+		func(a ma.Multiaddr) bool { return !IsRelayedAddr(a) },
+		manet.IsPublicAddr,
+	)
+}
+
+// IsRelayedAddr reports whether a is a circuit relay address.
+func IsRelayedAddr(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// HasDirectConn reports whether we have a connection to p that isn't relayed.
+func HasDirectConn(h host.Host, p peer.ID) bool {
+	for _, c := range h.Network().ConnsToPeer(p) {
+		if !IsRelayedAddr(c.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *Upgrader) state(p peer.ID) *upgradeState {
+	st, ok := u.peers[p]
+	if !ok {
+		st = &upgradeState{}
+		u.peers[p] = st
+	}
+	return st
+}
+
+// Trace records the outcome of hole punching attempts with VPN peers.
+func (u *Upgrader) Trace(evt *holepunch.Event) {
+	if _, found := u.config.PeerByID(evt.Remote); !found {
+		return
+	}
+	attempt := HolePunchAttempt{
+		Time: time.Unix(0, evt.Timestamp),
+	}
+	switch e := evt.Evt.(type) {
+	case *holepunch.DirectDialEvt:
+		attempt.Kind = AttemptDirectDial
+		attempt.Success = e.Success
+		attempt.Duration = e.EllapsedTime
+		attempt.Error = e.Error
+	case *holepunch.EndHolePunchEvt:
+		attempt.Kind = AttemptHolePunch
+		attempt.Success = e.Success
+		attempt.Duration = e.EllapsedTime
+		attempt.Error = e.Error
+	case *holepunch.ProtocolErrorEvt:
+		attempt.Kind = AttemptProtocol
+		attempt.Error = e.Error
+	default:
+		return
+	}
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	st := u.state(evt.Remote)
+	st.Attempts = append(st.Attempts, attempt)
+	if len(st.Attempts) > upgradeMaxAttempts {
+		st.Attempts = st.Attempts[len(st.Attempts)-upgradeMaxAttempts:]
+	}
+}
+
+// Status returns the upgrade state of all VPN peers we tried to get a direct
+// connection to.
+func (u *Upgrader) Status() map[peer.ID]HolePunchStatus {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	status := make(map[peer.ID]HolePunchStatus, len(u.peers))
+	for p, st := range u.peers {
+		hs := st.HolePunchStatus
+		hs.Attempts = append([]HolePunchAttempt(nil), st.Attempts...)
+		status[p] = hs
+	}
+	return status
+}
+
+func upgradeBackoff(failures int) time.Duration {
+	d := upgradeMinBackoff << min(failures, 16)
+	if d > upgradeMaxBackoff || d <= 0 {
+		d = upgradeMaxBackoff
+	}
+	return jitter(d)
+}
+
+// jitter randomizes d by +/- 20%.
+func jitter(d time.Duration) time.Duration {
+	return d - d/5 + rand.N(2*d/5)
+}
+
+// due returns the VPN peers that are only connected through a relay and are
+// due for another attempt.
+func (u *Upgrader) due(now time.Time) []peer.ID {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	var peers []peer.ID
+	for _, p := range u.config.Peers {
+		if u.host.Network().Connectedness(p.ID) != network.Connected || HasDirectConn(u.host, p.ID) {
+			if st, ok := u.peers[p.ID]; ok && !st.inFlight {
+				// Give the hole punch that starts on new relayed
+				// connections a chance first.
+				st.Failures = 0
+				st.NextRetry = now.Add(upgradeMinBackoff)
+			}
+			continue
+		}
+		st, ok := u.peers[p.ID]
+		if !ok {
+			st = u.state(p.ID)
+			st.NextRetry = now.Add(upgradeMinBackoff)
+			continue
+		}
+		if st.inFlight || now.Before(st.NextRetry) {
+			continue
+		}
+		st.inFlight = true
+		peers = append(peers, p.ID)
+	}
+	return peers
+}
+
+func (u *Upgrader) attempt(p peer.ID) {
+	err := u.service.DirectConnect(p)
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	st := u.state(p)
+	st.inFlight = false
+	if err == nil && HasDirectConn(u.host, p) {
+		st.Failures = 0
+		st.NextRetry = time.Time{}
+		return
+	}
+	st.Failures++
+	st.NextRetry = time.Now().Add(upgradeBackoff(st.Failures))
+	logger.With(err).Debugf("No direct connection to %s, retrying at %s", p, st.NextRetry.Format(time.TimeOnly))
+}
+
+func (u *Upgrader) Service(ctx context.Context, wg *sync.WaitGroup) {
+	logger.Debug("Connection upgrade service ready")
+	wg.Add(1)
+	defer wg.Done()
+	if !u.shared {
+		// On a shared host, the service belongs to the network that created it.
+		defer u.service.Close()
+	}
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// Hole punching needs a public address to work with.
+			if len(u.holePunchAddrs()) == 0 {
+				continue
+			}
+			for _, p := range u.due(now) {
+				go u.attempt(p)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_upgradeBackoff(t *testing.T) {
+	for failures := range 32 {
+		d := upgradeBackoff(failures)
+		assert.GreaterOrEqual(t, d, upgradeMinBackoff*4/5)
+		assert.LessOrEqual(t, d, upgradeMaxBackoff*6/5)
+	}
+	assert.Greater(t, upgradeBackoff(4), upgradeBackoff(0))
+}
+
+func Test_Upgrader_Trace(t *testing.T) {
+	ids := makeTestIDs(t, 2)
+	member, outsider := ids[0], ids[1]
+	u := NewUpgrader(makeTestConfig(member))
+
+	u.Trace(&holepunch.Event{
+		Timestamp: time.Now().UnixNano(),
+		Remote:    member,
+		Type:      holepunch.DirectDialEvtT,
+		Evt:       &holepunch.DirectDialEvt{Success: false, Error: "timeout"},
+	})
+	u.Trace(&holepunch.Event{
+		Remote: member,
+		Type:   holepunch.StartHolePunchEvtT,
+		Evt:    &holepunch.StartHolePunchEvt{},
+	})
+	u.Trace(&holepunch.Event{
+		Remote: outsider,
+		Type:   holepunch.EndHolePunchEvtT,
+		Evt:    &holepunch.EndHolePunchEvt{Success: true},
+	})
+	for range upgradeMaxAttempts {
+		u.Trace(&holepunch.Event{
+			Remote: member,
+			Type:   holepunch.EndHolePunchEvtT,
+			Evt:    &holepunch.EndHolePunchEvt{Success: true},
+		})
+	}
+
+	status := u.Status()
+	assert.NotContains(t, status, outsider, "non-members aren't tracked")
+	require.Contains(t, status, member)
+	attempts := status[member].Attempts
+	require.Len(t, attempts, upgradeMaxAttempts)
+	for _, a := range attempts {
+		assert.Equal(t, AttemptHolePunch, a.Kind)
+		assert.True(t, a.Success)
+	}
+}
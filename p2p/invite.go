@@ -0,0 +1,417 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/hyprspace/hyprspace/schema"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multibase"
+	"go.uber.org/zap"
+)
+
+// JoinProtocol is used by new nodes to redeem an invite.
+const JoinProtocol = "/hyprspace/join/0.0.1"
+
+// How long a joining node has to send its request.
+const joinTimeout = 30 * time.Second
+
+// How long a join waits for approval.
+const joinApprovalTimeout = 10 * time.Minute
+
+const inviteDomain = "hyprspace-invite"
+
+var inviteCodec = []byte("/hyprspace/invite")
+
+func init() {
+	record.RegisterType(&Invite{})
+}
+
+// Invite lets one node join the network of the node that signed it, before
+// it expires.
+type Invite struct {
+	Secret  []byte
+	Expiry  time.Time
+	Name    string
+	Addrs   []ma.Multiaddr
+	Approve bool
+}
+
+func (inv *Invite) Domain() string {
+	return inviteDomain
+}
+
+func (inv *Invite) Codec() []byte {
+	return inviteCodec
+}
+
+func (inv *Invite) MarshalRecord() ([]byte, error) {
+	return marshalInvite(inv), nil
+}
+
+func (inv *Invite) UnmarshalRecord(data []byte) error {
+	return unmarshalInvite(data, inv)
+}
+
+// EncodeInvite signs inv with key and encodes it as a token.
+func EncodeInvite(key crypto.PrivKey, inv *Invite) (string, error) {
+	env, err := record.Seal(inv, key)
+	if err != nil {
+		return "", err
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return multibase.Encode(multibase.Base58BTC, data)
+}
+
+// DecodeInvite decodes a token and returns the invite and the node that
+// signed it.
+func DecodeInvite(token string) (peer.ID, *Invite, error) {
+	_, data, err := multibase.Decode(token)
+	if err != nil {
+		return "", nil, err
+	}
+	return consumeInvite(data)
+}
+
+func consumeInvite(data []byte) (peer.ID, *Invite, error) {
+	env, rec, err := record.ConsumeEnvelope(data, inviteDomain)
+	if err != nil {
+		return "", nil, err
+	}
+	inv, ok := rec.(*Invite)
+	if !ok {
+		return "", nil, errors.New("not an invite")
+	}
+	id, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, inv, nil
+}
+
+// JoinRequest is a node waiting for its join to be approved.
+type JoinRequest struct {
+	ID        peer.ID
+	Name      string
+	Requested time.Time
+}
+
+type pendingJoin struct {
+	JoinRequest
+	decision chan bool
+}
+
+// Invites hands out invites to the network and adds the nodes redeeming them
+// to the config file. Redeemed invites are remembered until they expire, but
+// only in memory.
+type Invites struct {
+	host      host.Host
+	config    *config.Config
+	lock      sync.Mutex
+	used      map[string]time.Time
+	redeeming map[string]bool
+	pending   map[peer.ID]*pendingJoin
+	reload    func()
+}
+
+// NewInvites serves invites of the network of cfg on h. Once a peer joined,
+// the network is restarted with reload to connect to it, if reload is set.
+func NewInvites(h host.Host, cfg *config.Config, reload func()) *Invites {
+	inv := &Invites{
+		host:      h,
+		config:    cfg,
+		reload:    reload,
+		used:      make(map[string]time.Time),
+		redeeming: make(map[string]bool),
+		pending:   make(map[peer.ID]*pendingJoin),
+	}
+	h.SetStreamHandler(JoinProtocol, inv.streamHandler)
+	return inv
+}
+
+// Create returns a token that is valid for ttl. Nodes redeeming it must be
+// approved first if approve is set.
+func (inv *Invites) Create(ttl time.Duration, approve bool) (string, error) {
+	if inv.config.MembersOnly {
+		return "", errors.New("invites can't be redeemed in members-only mode, non-members can't connect")
+	}
+	if h, ok := inv.host.(sharedNetworkHost); ok && !h.owner() {
+		return "", errors.New("invites can't be redeemed on a shared host, non-members only reach the network that created it")
+	}
+	if ttl <= 0 {
+		return "", errors.New("invites need a positive lifetime")
+	}
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	return EncodeInvite(inv.config.PrivateKey, &Invite{
+		Secret:  secret,
+		Expiry:  time.Now().Add(ttl),
+		Name:    inv.config.Name,
+		Addrs:   inv.host.Addrs(),
+		Approve: approve,
+	})
+}
+
+// Pending returns the nodes waiting for approval, oldest first.
+func (inv *Invites) Pending() []JoinRequest {
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	var reqs []JoinRequest
+	for _, pj := range inv.pending {
+		reqs = append(reqs, pj.JoinRequest)
+	}
+	slices.SortFunc(reqs, func(a, b JoinRequest) int {
+		return a.Requested.Compare(b.Requested)
+	})
+	return reqs
+}
+
+// Decide approves or denies the join of p.
+func (inv *Invites) Decide(p peer.ID, approve bool) error {
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	pj, ok := inv.pending[p]
+	if !ok {
+		return fmt.Errorf("no pending join from %s", p)
+	}
+	delete(inv.pending, p)
+	pj.decision <- approve
+	return nil
+}
+
+// redeem starts redeeming an invite, so no other node can redeem it at the
+// same time. It fails if the invite is used or being redeemed already.
+func (inv *Invites) redeem(i *Invite, now time.Time) string {
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	for secret, expiry := range inv.used {
+		if now.After(expiry) {
+			delete(inv.used, secret)
+		}
+	}
+	if _, used := inv.used[string(i.Secret)]; used {
+		return "invite used already"
+	}
+	if inv.redeeming[string(i.Secret)] {
+		return "invite being redeemed by another node"
+	}
+	inv.redeeming[string(i.Secret)] = true
+	return ""
+}
+
+// redeemed finishes redeeming an invite. Only an invite that was used is
+// marked as such, otherwise it can be redeemed again.
+func (inv *Invites) redeemed(i *Invite, used bool) {
+	inv.lock.Lock()
+	defer inv.lock.Unlock()
+	delete(inv.redeeming, string(i.Secret))
+	if used {
+		inv.used[string(i.Secret)] = i.Expiry
+	}
+}
+
+// checkName checks that p can join under name. It has to be set, and not be
+// taken by another peer in the config file.
+func (inv *Invites) checkName(p peer.ID, name string) (joinResponse, bool) {
+	if name == "" {
+		return joinResponse{status: joinStatusInvalid, statusText: "joining needs a name"}, false
+	}
+	inv.lock.Lock()
+	cfg, err := config.Read(inv.config.Path)
+	inv.lock.Unlock()
+	if err != nil {
+		logger.With(zap.Error(err)).Error("Failed to read config")
+		return joinResponse{status: joinStatusInternal, statusText: "failed to read config"}, false
+	}
+	if other, found := cfg.PeerByName(name); found && other.ID != p {
+		return joinResponse{status: joinStatusInvalid, statusText: fmt.Sprintf("name %q is taken by another peer", name)}, false
+	}
+	return joinResponse{}, true
+}
+
+// await waits until the join of p is decided on, or until it times out.
+func (inv *Invites) await(p peer.ID, name string) bool {
+	pj := &pendingJoin{
+		JoinRequest: JoinRequest{ID: p, Name: name, Requested: time.Now()},
+		decision:    make(chan bool, 1),
+	}
+	inv.lock.Lock()
+	if _, ok := inv.pending[p]; ok {
+		inv.lock.Unlock()
+		return false
+	}
+	inv.pending[p] = pj
+	inv.lock.Unlock()
+
+	logger.With(zap.String("peer", p.String()), zap.String("name", name)).Info("Join waiting for approval")
+	timer := time.NewTimer(joinApprovalTimeout)
+	defer timer.Stop()
+	select {
+	case approve := <-pj.decision:
+		return approve
+	case <-timer.C:
+		inv.lock.Lock()
+		if inv.pending[p] == pj {
+			delete(inv.pending, p)
+		}
+		inv.lock.Unlock()
+		return false
+	}
+}
+
+// join checks the request of p and adds p to the config file. The invite is
+// only used up once p is added. It reports whether the config file changed.
+func (inv *Invites) join(p peer.ID, req joinRequest) (joinResponse, bool) {
+	signer, i, err := consumeInvite(req.invite)
+	now := time.Now()
+	switch {
+	case err != nil || signer != inv.host.ID():
+		return joinResponse{status: joinStatusInvalid, statusText: "invalid invite"}, false
+	case now.After(i.Expiry):
+		return joinResponse{status: joinStatusInvalid, statusText: "invite expired"}, false
+	}
+	if resp, ok := inv.checkName(p, req.name); !ok {
+		return resp, false
+	}
+	if problem := inv.redeem(i, now); problem != "" {
+		return joinResponse{status: joinStatusInvalid, statusText: problem}, false
+	}
+	used := false
+	defer func() { inv.redeemed(i, used) }()
+	if i.Approve && !inv.await(p, req.name) {
+		return joinResponse{status: joinStatusDenied, statusText: "join denied"}, false
+	}
+
+	inv.lock.Lock()
+	added, err := config.AddPeer(inv.config.Path, schema.ConfigPeersElem{Id: p.String(), Name: req.name})
+	inv.lock.Unlock()
+	if err != nil {
+		logger.With(zap.String("peer", p.String()), zap.Error(err)).Error("Failed to add joining peer to config")
+		return joinResponse{status: joinStatusInternal, statusText: "failed to add peer to config"}, false
+	}
+	used = true
+	if added {
+		logger.With(zap.String("peer", p.String()), zap.String("name", req.name)).Info("Peer joined")
+	}
+	return joinResponse{status: joinStatusOK, name: inv.config.Name}, added
+}
+
+func (inv *Invites) streamHandler(s network.Stream) {
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(joinTimeout))
+	data, err := readJoinMsg(bufio.NewReader(s))
+	if err != nil {
+		s.Reset()
+		return
+	}
+	req, err := unmarshalJoinRequest(data)
+	if err != nil {
+		s.Reset()
+		return
+	}
+	if _, i, err := consumeInvite(req.invite); err == nil && i.Approve {
+		s.SetDeadline(time.Now().Add(joinApprovalTimeout + joinTimeout))
+	}
+	resp, added := inv.join(s.Conn().RemotePeer(), req)
+	if err := writeJoinMsg(s, marshalJoinResponse(&resp)); err != nil {
+		s.Reset()
+	}
+	if added && inv.reload != nil {
+		// Like peer add, restart the network to connect to the new peer.
+		// The response is sent first, the restart closes this stream.
+		go inv.reload()
+	}
+}
+
+// Join redeems token with the node that signed it, from a temporary host with
+// the key of cfg, and adds that node to the config file. It returns the ID and
+// name of the node.
+func Join(ctx context.Context, cfg *config.Config, token string) (peer.ID, string, error) {
+	_, data, err := multibase.Decode(token)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid invite: %w", err)
+	}
+	id, i, err := consumeInvite(data)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid invite: %w", err)
+	}
+	if time.Now().After(i.Expiry) {
+		return "", "", errors.New("invite expired")
+	}
+	timeout := joinTimeout
+	if i.Approve {
+		timeout += joinApprovalTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	maybePrivateNet, privateNet, err := privateNetwork()
+	if err != nil {
+		return "", "", err
+	}
+	transports, err := transportOptions(cfg, privateNet)
+	if err != nil {
+		return "", "", err
+	}
+	h, err := libp2p.New(
+		maybePrivateNet,
+		libp2p.NoListenAddrs,
+		libp2p.Identity(cfg.PrivateKey),
+		libp2p.UserAgent("hyprspace"),
+		transports,
+	)
+	if err != nil {
+		return "", "", err
+	}
+	defer h.Close()
+
+	if err := h.Connect(ctx, peer.AddrInfo{ID: id, Addrs: i.Addrs}); err != nil {
+		return "", "", err
+	}
+	s, err := h.NewStream(network.WithAllowLimitedConn(ctx, "join"), id, JoinProtocol)
+	if err != nil {
+		return "", "", err
+	}
+	defer s.Close()
+	deadline, _ := ctx.Deadline()
+	s.SetDeadline(deadline)
+
+	err = writeJoinMsg(s, marshalJoinRequest(&joinRequest{invite: data, name: cfg.Name}))
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := readJoinMsg(bufio.NewReader(s))
+	if err != nil {
+		return "", "", err
+	}
+	jr, err := unmarshalJoinResponse(resp)
+	if err != nil {
+		return "", "", err
+	}
+	if jr.status != joinStatusOK {
+		return "", "", errors.New(jr.statusText)
+	}
+
+	name := jr.name
+	if name == "" {
+		name = i.Name
+	}
+	_, err = config.AddPeer(cfg.Path, schema.ConfigPeersElem{Id: id.String(), Name: name})
+	return id, name, err
+}
@@ -0,0 +1,258 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/multiformats/go-multibase"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// makeTestNode writes a config file with a new key and starts a host that
+// accepts invites with it.
+func makeTestNode(t *testing.T, name string) (*config.Config, host.Host, *Invites) {
+	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	keyBytes, err := crypto.MarshalPrivateKey(pk)
+	require.NoError(t, err)
+	out, err := json.Marshal(map[string]any{
+		"privateKey": multibase.MustNewEncoder(multibase.Base58BTC).Encode(keyBytes),
+		"name":       name,
+	})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "hyprspace.json")
+	require.NoError(t, os.WriteFile(path, out, 0o600))
+	cfg, err := config.Read(path)
+	require.NoError(t, err)
+
+	h, err := libp2p.New(libp2p.Identity(cfg.PrivateKey), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return cfg, h, NewInvites(h, cfg, nil)
+}
+
+func readTestPeers(t *testing.T, cfg *config.Config) []config.Peer {
+	cfg, err := config.Read(cfg.Path)
+	require.NoError(t, err)
+	return cfg.Peers
+}
+
+func Test_Invite_token(t *testing.T) {
+	pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	inv := &Invite{
+		Secret:  []byte("0123456789abcdef"),
+		Expiry:  time.Unix(1700000000, 0),
+		Name:    "inviter",
+		Addrs:   testAddrs(1),
+		Approve: true,
+	}
+	token, err := EncodeInvite(pk, inv)
+	require.NoError(t, err)
+
+	id, decoded, err := DecodeInvite(token)
+	require.NoError(t, err)
+	assert.True(t, id.MatchesPrivateKey(pk))
+	assert.Equal(t, inv, decoded)
+
+	_, data, err := multibase.Decode(token)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 1
+	tampered, err := multibase.Encode(multibase.Base58BTC, data)
+	require.NoError(t, err)
+	_, _, err = DecodeInvite(tampered)
+	assert.Error(t, err)
+}
+
+func Test_Join(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("both sides add each other", func(t *testing.T) {
+		inviterCfg, inviter, invites := makeTestNode(t, "inviter")
+		joinerCfg, joiner, _ := makeTestNode(t, "joiner")
+		reloaded := make(chan struct{}, 1)
+		invites.reload = func() { reloaded <- struct{}{} }
+		token, err := invites.Create(time.Minute, false)
+		require.NoError(t, err)
+
+		id, name, err := Join(ctx, joinerCfg, token)
+		require.NoError(t, err)
+		assert.Equal(t, inviter.ID(), id)
+		assert.Equal(t, "inviter", name)
+		select {
+		case <-reloaded:
+		case <-time.After(5 * time.Second):
+			t.Error("the inviter didn't reload to connect to the joiner")
+		}
+
+		peers := readTestPeers(t, inviterCfg)
+		require.Len(t, peers, 1)
+		assert.Equal(t, joiner.ID(), peers[0].ID)
+		assert.Equal(t, "joiner", peers[0].Name)
+		peers = readTestPeers(t, joinerCfg)
+		require.Len(t, peers, 1)
+		assert.Equal(t, inviter.ID(), peers[0].ID)
+		assert.Equal(t, "inviter", peers[0].Name)
+	})
+
+	t.Run("invites can be used once", func(t *testing.T) {
+		_, _, invites := makeTestNode(t, "inviter")
+		joinerCfg, _, _ := makeTestNode(t, "joiner")
+		otherCfg, _, _ := makeTestNode(t, "other")
+		token, err := invites.Create(time.Minute, false)
+		require.NoError(t, err)
+
+		_, _, err = Join(ctx, joinerCfg, token)
+		require.NoError(t, err)
+		_, _, err = Join(ctx, otherCfg, token)
+		assert.ErrorContains(t, err, "used already")
+		assert.Empty(t, readTestPeers(t, otherCfg))
+	})
+
+	t.Run("invites of other nodes are rejected", func(t *testing.T) {
+		_, inviter, invites := makeTestNode(t, "inviter")
+		otherCfg, _, _ := makeTestNode(t, "other")
+		joinerCfg, _, _ := makeTestNode(t, "joiner")
+		token, err := EncodeInvite(otherCfg.PrivateKey, &Invite{
+			Secret: []byte("0123456789abcdef"),
+			Expiry: time.Now().Add(time.Minute),
+			Addrs:  inviter.Addrs(),
+		})
+		require.NoError(t, err)
+
+		resp, _ := invites.join(inviter.ID(), joinRequest{invite: mustDecodeToken(t, token)})
+		assert.EqualValues(t, joinStatusInvalid, resp.status)
+		_, _, err = Join(ctx, joinerCfg, token)
+		assert.Error(t, err)
+	})
+
+	t.Run("expired invites are rejected", func(t *testing.T) {
+		inviterCfg, inviter, invites := makeTestNode(t, "inviter")
+		joinerCfg, joiner, _ := makeTestNode(t, "joiner")
+		token, err := EncodeInvite(inviterCfg.PrivateKey, &Invite{
+			Secret: []byte("0123456789abcdef"),
+			Expiry: time.Now().Add(-time.Second),
+			Addrs:  inviter.Addrs(),
+		})
+		require.NoError(t, err)
+
+		_, _, err = Join(ctx, joinerCfg, token)
+		assert.ErrorContains(t, err, "expired")
+		resp, _ := invites.join(joiner.ID(), joinRequest{invite: mustDecodeToken(t, token)})
+		assert.EqualValues(t, joinStatusInvalid, resp.status)
+		assert.Empty(t, readTestPeers(t, inviterCfg))
+	})
+
+	t.Run("joins wait for approval", func(t *testing.T) {
+		inviterCfg, _, invites := makeTestNode(t, "inviter")
+		joinerCfg, joiner, _ := makeTestNode(t, "joiner")
+		token, err := invites.Create(time.Minute, true)
+		require.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			_, _, err := Join(ctx, joinerCfg, token)
+			done <- err
+		}()
+		require.Eventually(t, func() bool { return len(invites.Pending()) == 1 }, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, joiner.ID(), invites.Pending()[0].ID)
+		assert.Equal(t, "joiner", invites.Pending()[0].Name)
+		assert.Empty(t, readTestPeers(t, inviterCfg))
+
+		require.NoError(t, invites.Decide(joiner.ID(), true))
+		require.NoError(t, <-done)
+		assert.Len(t, readTestPeers(t, inviterCfg), 1)
+		assert.Empty(t, invites.Pending())
+	})
+
+	t.Run("denied joins add nobody", func(t *testing.T) {
+		inviterCfg, _, invites := makeTestNode(t, "inviter")
+		joinerCfg, joiner, _ := makeTestNode(t, "joiner")
+		token, err := invites.Create(time.Minute, true)
+		require.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			_, _, err := Join(ctx, joinerCfg, token)
+			done <- err
+		}()
+		require.Eventually(t, func() bool { return len(invites.Pending()) == 1 }, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, invites.Decide(joiner.ID(), false))
+		assert.ErrorContains(t, <-done, "denied")
+		assert.Empty(t, readTestPeers(t, inviterCfg))
+		assert.Empty(t, readTestPeers(t, joinerCfg))
+		assert.Error(t, invites.Decide(joiner.ID(), true))
+	})
+
+	t.Run("denied invites can be redeemed again", func(t *testing.T) {
+		inviterCfg, _, invites := makeTestNode(t, "inviter")
+		joinerCfg, joiner, _ := makeTestNode(t, "joiner")
+		otherCfg, other, _ := makeTestNode(t, "other")
+		token, err := invites.Create(time.Minute, true)
+		require.NoError(t, err)
+
+		done := make(chan error)
+		go func() {
+			_, _, err := Join(ctx, joinerCfg, token)
+			done <- err
+		}()
+		require.Eventually(t, func() bool { return len(invites.Pending()) == 1 }, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, invites.Decide(joiner.ID(), false))
+		assert.ErrorContains(t, <-done, "denied")
+
+		go func() {
+			_, _, err := Join(ctx, otherCfg, token)
+			done <- err
+		}()
+		require.Eventually(t, func() bool { return len(invites.Pending()) == 1 }, 5*time.Second, 10*time.Millisecond)
+		require.NoError(t, invites.Decide(other.ID(), true))
+		require.NoError(t, <-done)
+		peers := readTestPeers(t, inviterCfg)
+		require.Len(t, peers, 1)
+		assert.Equal(t, other.ID(), peers[0].ID)
+	})
+
+	t.Run("names are checked before redeeming", func(t *testing.T) {
+		inviterCfg, inviter, invites := makeTestNode(t, "inviter")
+		joinerCfg, _, _ := makeTestNode(t, "joiner")
+		sameNameCfg, _, _ := makeTestNode(t, "JOINER")
+		otherCfg, _, _ := makeTestNode(t, "other")
+		token, err := invites.Create(time.Minute, false)
+		require.NoError(t, err)
+		_, _, err = Join(ctx, joinerCfg, token)
+		require.NoError(t, err)
+
+		token, err = invites.Create(time.Minute, false)
+		require.NoError(t, err)
+		resp, _ := invites.join(inviter.ID(), joinRequest{invite: mustDecodeToken(t, token)})
+		assert.EqualValues(t, joinStatusInvalid, resp.status)
+		assert.Equal(t, "joining needs a name", resp.statusText)
+		_, _, err = Join(ctx, sameNameCfg, token)
+		assert.ErrorContains(t, err, "taken by another peer")
+		_, _, err = Join(ctx, otherCfg, token)
+		require.NoError(t, err)
+		assert.Len(t, readTestPeers(t, inviterCfg), 2)
+	})
+
+	t.Run("members-only nodes can't invite", func(t *testing.T) {
+		cfg, _, invites := makeTestNode(t, "inviter")
+		cfg.MembersOnly = true
+		_, err := invites.Create(time.Minute, false)
+		assert.Error(t, err)
+	})
+}
+
+func mustDecodeToken(t *testing.T, token string) []byte {
+	_, data, err := multibase.Decode(token)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,169 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Invites and join messages are protobuf encoded. Join messages are
+// length-prefixed with an unsigned varint.
+//
+//	message Invite {
+//	  bytes secret = 1;
+//	  uint64 expiry = 2; // unix seconds
+//	  string name = 3;
+//	  repeated bytes addrs = 4;
+//	  bool approve = 5;
+//	}
+//
+//	message JoinRequest {
+//	  bytes invite = 1; // signed envelope of the Invite
+//	  string name = 2;
+//	}
+//
+//	message JoinResponse {
+//	  JoinStatus status = 1;
+//	  string statusText = 2;
+//	  string name = 3;
+//	}
+
+// Join response status codes.
+const (
+	joinStatusOK       = 0
+	joinStatusInvalid  = 1
+	joinStatusDenied   = 2
+	joinStatusInternal = 3
+)
+
+// Upper bound for a single join message.
+const joinMaxMessageSize = 64 << 10
+
+var errJoinTooLarge = errors.New("join message too large")
+
+func marshalInvite(inv *Invite) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, inv.Secret)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(inv.Expiry.Unix()))
+	if inv.Name != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, inv.Name)
+	}
+	for _, a := range inv.Addrs {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, a.Bytes())
+	}
+	if inv.Approve {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	return b
+}
+
+func unmarshalInvite(data []byte, inv *Invite) error {
+	*inv = Invite{}
+	return rvFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			inv.Secret = b
+		case 2:
+			inv.Expiry = time.Unix(int64(v), 0)
+		case 3:
+			inv.Name = string(b)
+		case 4:
+			a, err := ma.NewMultiaddrBytes(b)
+			if err != nil {
+				return err
+			}
+			inv.Addrs = append(inv.Addrs, a)
+		case 5:
+			inv.Approve = v != 0
+		}
+		return nil
+	})
+}
+
+type joinRequest struct {
+	invite []byte
+	name   string
+}
+
+type joinResponse struct {
+	status     uint64
+	statusText string
+	name       string
+}
+
+func marshalJoinRequest(req *joinRequest) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, req.invite)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendString(b, req.name)
+}
+
+func unmarshalJoinRequest(data []byte) (req joinRequest, err error) {
+	err = rvFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			req.invite = b
+		case 2:
+			req.name = string(b)
+		}
+		return nil
+	})
+	return
+}
+
+func marshalJoinResponse(resp *joinResponse) []byte {
+	b := appendRvStatus(nil, resp.status, 1, resp.statusText, 2)
+	if resp.name != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, resp.name)
+	}
+	return b
+}
+
+func unmarshalJoinResponse(data []byte) (resp joinResponse, err error) {
+	err = rvFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			resp.status = v
+		case 2:
+			resp.statusText = string(b)
+		case 3:
+			resp.name = string(b)
+		}
+		return nil
+	})
+	return
+}
+
+func writeJoinMsg(w io.Writer, data []byte) error {
+	if len(data) > joinMaxMessageSize {
+		return errJoinTooLarge
+	}
+	buf := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(data)), uint64(len(data)))
+	_, err := w.Write(append(buf, data...))
+	return err
+}
+
+func readJoinMsg(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > joinMaxMessageSize {
+		return nil, errJoinTooLarge
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
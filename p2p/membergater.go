@@ -0,0 +1,74 @@
+package p2p
+
+import (
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// MemberGater keeps every node that isn't a VPN peer from connecting to us,
+// apart from the bootstrap peers, relays and rendezvous points we need.
+type MemberGater struct {
+	config *config.Config
+	infra  map[peer.ID]struct{}
+}
+
+func NewMemberGater(cfg *config.Config) connmgr.ConnectionGater {
+	infra := make(map[peer.ID]struct{})
+	for _, addr := range cfg.BootstrapPeers {
+		if _, id := peer.SplitAddr(addr); id != "" {
+			infra[id] = struct{}{}
+		}
+	}
+	for _, ai := range cfg.Relays {
+		infra[ai.ID] = struct{}{}
+	}
+	for _, ai := range cfg.Rendezvous.Points {
+		infra[ai.ID] = struct{}{}
+	}
+	return MemberGater{
+		config: cfg,
+		infra:  infra,
+	}
+}
+
+func (mg MemberGater) allowed(p peer.ID) bool {
+	if _, found := mg.config.PeerByID(p); found {
+		return true
+	}
+	_, found := mg.infra[p]
+	return found
+}
+
+func (mg MemberGater) InterceptAccept(network.ConnMultiaddrs) (allow bool) {
+	return true
+}
+
+func (mg MemberGater) InterceptAddrDial(peer.ID, multiaddr.Multiaddr) (allow bool) {
+	return true
+}
+
+func (mg MemberGater) InterceptPeerDial(p peer.ID) (allow bool) {
+	return mg.allowed(p)
+}
+
+func (mg MemberGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) (allow bool) {
+	if mg.allowed(p) {
+		return true
+	}
+	logger.With(
+		zap.String("peer", p.String()),
+		zap.String("addr", addrs.RemoteMultiaddr().String()),
+		zap.Stringer("direction", dir),
+	).Debug("Rejected connection from a non-member")
+	gaterRejected.WithLabelValues("members").Inc()
+	return false
+}
+
+func (mg MemberGater) InterceptUpgraded(network.Conn) (allow bool, reason control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,31 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MemberGater(t *testing.T) {
+	ids := makeTestIDs(t, 5)
+	member, bootstrap, relay, point, stranger := ids[0], ids[1], ids[2], ids[3], ids[4]
+	cfg := makeTestConfig(member)
+	cfg.BootstrapPeers = []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/203.0.113.1/tcp/4001/p2p/" + bootstrap.String()),
+	}
+	cfg.Relays = []peer.AddrInfo{{ID: relay}}
+	cfg.Rendezvous = config.Rendezvous{Points: []peer.AddrInfo{{ID: point}}}
+	gater := NewMemberGater(cfg)
+
+	addrs := testConnAddrs{multiaddr.StringCast("/ip4/198.51.100.7/tcp/8001")}
+	for _, p := range []peer.ID{member, bootstrap, relay, point} {
+		assert.True(t, gater.InterceptPeerDial(p))
+		assert.True(t, gater.InterceptSecured(network.DirInbound, p, addrs))
+	}
+	assert.False(t, gater.InterceptPeerDial(stranger))
+	assert.False(t, gater.InterceptSecured(network.DirInbound, stranger, addrs))
+}
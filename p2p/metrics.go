@@ -1,56 +1,327 @@
 package p2p
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/hyprspace/hyprspace/config"
-	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	msmux "github.com/multiformats/go-multistream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-func RouteMetricsService(ctx context.Context, wg *sync.WaitGroup, host host.Host, cfg *config.Config) {
-	subCon, err := host.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged))
-	if err != nil {
-		logger.With(err).Fatal("Failed to subscribe eventbus")
+const (
+	probeInterval = 5 * time.Second
+	probeTimeout  = 3 * time.Second
+	// Weight of the latest probe in the moving averages, like the latency
+	// in the peerstore.
+	probeSmoothing = 0.1
+	// Links losing more probes than this aren't preferred.
+	lossyLink = 0.5
+)
+
+var (
+	probeRTT = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "hyprspace",
+		Subsystem: "probe",
+		Name:      "rtt_seconds",
+		Help:      "Smoothed round trip time to VPN peers.",
+	}, []string{"peer", "path"})
+	probeJitter = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "hyprspace",
+		Subsystem: "probe",
+		Name:      "jitter_seconds",
+		Help:      "Smoothed variation of the round trip time to VPN peers.",
+	}, []string{"peer", "path"})
+	probeLoss = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "hyprspace",
+		Subsystem: "probe",
+		Name:      "loss_ratio",
+		Help:      "Smoothed ratio of lost probes to VPN peers.",
+	}, []string{"peer", "path"})
+)
+
+// LinkStats is what probing measured on one connection to a peer.
+type LinkStats struct {
+	Addr      string
+	Relayed   bool
+	RTT       time.Duration
+	Jitter    time.Duration
+	Loss      float64
+	Probes    int
+	LastProbe time.Time
+}
+
+// lossy reports whether the link loses too many probes, or never answered
+// one at all.
+func (ls LinkStats) lossy() bool {
+	return ls.RTT == 0 || ls.Loss >= lossyLink
+}
+
+func (ls LinkStats) path() string {
+	if ls.Relayed {
+		return "relayed"
 	}
-	logger.Debug("Route metrics service ready")
+	return "direct"
+}
+
+// update adds the result of a probe to the moving averages. Jitter is
+// estimated from the difference between subsequent round trip times.
+func (ls *LinkStats) update(rtt time.Duration, lost bool) {
+	ls.LastProbe = time.Now()
+	ls.Probes++
+	if lost {
+		ls.Loss += probeSmoothing * (1 - ls.Loss)
+		return
+	}
+	ls.Loss -= probeSmoothing * ls.Loss
+	if ls.RTT == 0 {
+		ls.RTT = rtt
+		return
+	}
+	diff := rtt - ls.RTT
+	if diff < 0 {
+		diff = -diff
+	}
+	ls.Jitter += time.Duration(probeSmoothing * float64(diff-ls.Jitter))
+	ls.RTT += time.Duration(probeSmoothing * float64(rtt-ls.RTT))
+}
+
+type probeLink struct {
+	conn   network.Conn
+	stream network.Stream
+	stats  LinkStats
+}
+
+// Prober keeps measuring every connection to VPN peers, direct and relayed,
+// with the libp2p ping protocol.
+type Prober struct {
+	host   host.Host
+	config *config.Config
+	lock   sync.RWMutex
+	links  map[peer.ID]map[string]*probeLink
+}
+
+func NewProber(h host.Host, cfg *config.Config) *Prober {
+	return &Prober{
+		host:   h,
+		config: cfg,
+		links:  make(map[peer.ID]map[string]*probeLink),
+	}
+}
+
+// Stats returns the measurements of the connections to p.
+func (pr *Prober) Stats(p peer.ID) []LinkStats {
+	pr.lock.RLock()
+	defer pr.lock.RUnlock()
+	var stats []LinkStats
+	for _, l := range pr.links[p] {
+		stats = append(stats, l.stats)
+	}
+	return stats
+}
+
+// Preferred returns the best measured connection to p: the one with the
+// lowest round trip time among those that aren't lossy.
+func (pr *Prober) Preferred(p peer.ID) (LinkStats, bool) {
+	return preferredLink(pr.Stats(p))
+}
+
+func preferredLink(stats []LinkStats) (best LinkStats, ok bool) {
+	for _, ls := range stats {
+		if ls.Probes == 0 {
+			continue
+		}
+		if ok {
+			switch {
+			case ls.lossy() != best.lossy():
+				if ls.lossy() {
+					continue
+				}
+			case ls.lossy():
+				if ls.Loss >= best.Loss {
+					continue
+				}
+			case ls.RTT >= best.RTT:
+				continue
+			}
+		}
+		best, ok = ls, true
+	}
+	return
+}
+
+// PreferDirect reports whether traffic to p should move to a direct
+// connection. It should, unless the direct connections turned out to be lossy
+// while a relayed one isn't.
+func (pr *Prober) PreferDirect(p peer.ID) bool {
+	var direct, relayed []LinkStats
+	for _, ls := range pr.Stats(p) {
+		if ls.Relayed {
+			relayed = append(relayed, ls)
+		} else {
+			direct = append(direct, ls)
+		}
+	}
+	bestDirect, ok := preferredLink(direct)
+	if !ok || !bestDirect.lossy() {
+		return true
+	}
+	bestRelayed, ok := preferredLink(relayed)
+	return !ok || bestRelayed.lossy()
+}
+
+func (pr *Prober) Service(ctx context.Context, wg *sync.WaitGroup) {
 	wg.Add(1)
 	defer wg.Done()
+	logger.Debug("Route metrics service ready")
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
+			pr.lock.Lock()
+			for _, links := range pr.links {
+				for _, l := range links {
+					if l.stream != nil {
+						l.stream.Reset()
+					}
+				}
+			}
+			pr.lock.Unlock()
 			return
-		case ev := <-subCon.Out():
-			evt := ev.(event.EvtPeerConnectednessChanged)
-			_, found := config.FindPeer(cfg.Peers, evt.Peer)
-			if found {
-				if evt.Connectedness == network.Connected {
-					ctx2, cancel := context.WithDeadline(ctx, time.Now().Add(30*time.Second))
-					ch := ping.Ping(ctx2, host, evt.Peer)
-					go func() {
-						t := time.NewTimer(15 * time.Second)
-						for {
-							select {
-							case <-t.C:
-								cancel()
-							case <-ctx2.Done():
-								return
-							case res := <-ch:
-								if res.Error == nil {
-									host.Peerstore().RecordLatency(evt.Peer, res.RTT)
-								}
-								time.Sleep(5 * time.Second)
-							}
-						}
-					}()
-					// wait a little before spawning another ping goroutine
-					time.Sleep(1 * time.Second)
+		case <-ticker.C:
+			pr.probeAll(ctx)
+		}
+	}
+}
+
+// probeAll probes all connections to VPN peers at once, after catching up
+// with the connections that were opened and closed since the last round.
+func (pr *Prober) probeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range pr.config.Peers {
+		links := pr.syncLinks(p.ID)
+		for _, l := range links {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rtt, err := pr.probe(ctx, l)
+				pr.lock.Lock()
+				l.stats.update(rtt, err != nil)
+				pr.lock.Unlock()
+				if err == nil && (!l.stats.Relayed || !HasDirectConn(pr.host, p.ID)) {
+					pr.host.Peerstore().RecordLatency(p.ID, rtt)
 				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	for _, p := range pr.config.Peers {
+		pr.export(p.ID)
+	}
+}
+
+func (pr *Prober) syncLinks(p peer.ID) []*probeLink {
+	conns := pr.host.Network().ConnsToPeer(p)
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	links := make(map[string]*probeLink, len(conns))
+	for _, c := range conns {
+		l, ok := pr.links[p][c.ID()]
+		if !ok {
+			l = &probeLink{
+				conn: c,
+				stats: LinkStats{
+					Addr:    c.RemoteMultiaddr().String(),
+					Relayed: IsRelayedAddr(c.RemoteMultiaddr()),
+				},
 			}
 		}
+		links[c.ID()] = l
+	}
+	for id, l := range pr.links[p] {
+		if _, ok := links[id]; !ok && l.stream != nil {
+			l.stream.Reset()
+		}
+	}
+	if len(links) == 0 {
+		delete(pr.links, p)
+	} else {
+		pr.links[p] = links
+	}
+
+	var out []*probeLink
+	for _, l := range links {
+		out = append(out, l)
+	}
+	return out
+}
+
+// probe measures one round trip on the ping stream of l. The stream is kept
+// open between probes, and replaced after a failure.
+func (pr *Prober) probe(ctx context.Context, l *probeLink) (time.Duration, error) {
+	if l.stream == nil {
+		s, err := l.conn.NewStream(network.WithAllowLimitedConn(ctx, "probe"))
+		if err != nil {
+			return 0, err
+		}
+		s.SetDeadline(time.Now().Add(probeTimeout))
+		if err := msmux.SelectProtoOrFail(ping.ID, s); err != nil {
+			s.Reset()
+			return 0, err
+		}
+		s.SetProtocol(ping.ID)
+		s.Scope().SetService(ping.ServiceName)
+		l.stream = s
+	}
+
+	buf := make([]byte, ping.PingSize)
+	rand.Read(buf)
+	before := time.Now()
+	l.stream.SetDeadline(before.Add(probeTimeout))
+	_, err := l.stream.Write(buf)
+	if err == nil {
+		rbuf := make([]byte, ping.PingSize)
+		_, err = io.ReadFull(l.stream, rbuf)
+		if err == nil && !bytes.Equal(buf, rbuf) {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		l.stream.Reset()
+		l.stream = nil
+		return 0, err
+	}
+	return time.Since(before), nil
+}
+
+// export publishes the best link of each path to p as metrics.
+func (pr *Prober) export(p peer.ID) {
+	byPath := map[string][]LinkStats{}
+	for _, ls := range pr.Stats(p) {
+		byPath[ls.path()] = append(byPath[ls.path()], ls)
+	}
+	for _, path := range []string{"direct", "relayed"} {
+		best, ok := preferredLink(byPath[path])
+		if !ok {
+			probeRTT.DeleteLabelValues(p.String(), path)
+			probeJitter.DeleteLabelValues(p.String(), path)
+			probeLoss.DeleteLabelValues(p.String(), path)
+			continue
+		}
+		probeRTT.WithLabelValues(p.String(), path).Set(best.RTT.Seconds())
+		probeJitter.WithLabelValues(p.String(), path).Set(best.Jitter.Seconds())
+		probeLoss.WithLabelValues(p.String(), path).Set(best.Loss)
 	}
 }
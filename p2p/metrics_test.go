@@ -0,0 +1,94 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LinkStats_update(t *testing.T) {
+	var ls LinkStats
+	ls.update(0, true)
+	assert.True(t, ls.lossy(), "a link that never answered is lossy")
+
+	ls.update(10*time.Millisecond, false)
+	assert.Equal(t, 10*time.Millisecond, ls.RTT)
+	assert.Zero(t, ls.Jitter)
+	ls.update(20*time.Millisecond, false)
+	assert.Equal(t, 11*time.Millisecond, ls.RTT)
+	assert.Equal(t, time.Millisecond, ls.Jitter)
+	assert.InDelta(t, 0.1*0.9*0.9, ls.Loss, 1e-9)
+	assert.False(t, ls.lossy())
+
+	for range 10 {
+		ls.update(0, true)
+	}
+	assert.True(t, ls.lossy())
+}
+
+func Test_preferredLink(t *testing.T) {
+	fast := LinkStats{Addr: "fast", RTT: 5 * time.Millisecond, Probes: 10}
+	slow := LinkStats{Addr: "slow", RTT: 50 * time.Millisecond, Probes: 10}
+	lossy := LinkStats{Addr: "lossy", RTT: time.Millisecond, Loss: 0.7, Probes: 10}
+	unprobed := LinkStats{Addr: "unprobed"}
+
+	best, ok := preferredLink([]LinkStats{slow, lossy, fast, unprobed})
+	require.True(t, ok)
+	assert.Equal(t, "fast", best.Addr)
+
+	best, ok = preferredLink([]LinkStats{lossy, {Addr: "worse", Loss: 0.9, Probes: 10}})
+	require.True(t, ok)
+	assert.Equal(t, "lossy", best.Addr)
+
+	_, ok = preferredLink([]LinkStats{unprobed})
+	assert.False(t, ok)
+}
+
+func Test_Prober_PreferDirect(t *testing.T) {
+	p := peer.ID("peer")
+	pr := NewProber(nil, makeTestConfig(p))
+	assert.True(t, pr.PreferDirect(p), "unmeasured links are worth a try")
+
+	pr.links[p] = map[string]*probeLink{
+		"direct":  {stats: LinkStats{RTT: time.Millisecond, Loss: 0.8, Probes: 10}},
+		"relayed": {stats: LinkStats{Relayed: true, RTT: 40 * time.Millisecond, Probes: 10}},
+	}
+	assert.False(t, pr.PreferDirect(p))
+
+	pr.links[p]["direct"].stats.Loss = 0.1
+	assert.True(t, pr.PreferDirect(p))
+}
+
+func makeTestListeningHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func Test_Prober_probeAll(t *testing.T) {
+	ctx := context.Background()
+	a, b := makeTestListeningHost(t), makeTestListeningHost(t)
+	require.NoError(t, a.Connect(ctx, peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}))
+
+	pr := NewProber(a, makeTestConfig(b.ID()))
+	pr.probeAll(ctx)
+	pr.probeAll(ctx)
+
+	stats := pr.Stats(b.ID())
+	require.Len(t, stats, 1)
+	assert.Equal(t, 2, stats[0].Probes)
+	assert.NotZero(t, stats[0].RTT)
+	assert.Zero(t, stats[0].Loss)
+	assert.False(t, stats[0].Relayed)
+
+	require.NoError(t, a.Network().ClosePeer(b.ID()))
+	pr.probeAll(ctx)
+	assert.Empty(t, pr.Stats(b.ID()))
+}
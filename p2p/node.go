@@ -20,6 +20,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/pnet"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/routing"
@@ -27,8 +28,7 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
 	routedhost "github.com/libp2p/go-libp2p/p2p/host/routed"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
-	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
-	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
 	ma "github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap"
 )
@@ -118,31 +118,65 @@ func getExtraBootstrapNodes(addr ma.Multiaddr) (nodesList []string) {
 }
 
 // CreateNode creates an internal Libp2p nodes and returns it and it's DHT Discovery service.
-func CreateNode(ctx context.Context, privateKey crypto.PrivKey, listenAddreses []ma.Multiaddr, bootstrapPeers []ma.Multiaddr, handler network.StreamHandler, acl relay.ACLFilter, gater connmgr.ConnectionGater, vpnPeers []config.Peer) (node host.Host, dhtOut *dht.IpfsDHT, err error) {
+// If a shared host is given, the node is set up as its first network, and other
+// networks can join it with JoinSharedHost.
+func CreateNode(ctx context.Context, cfg *config.Config, handler network.StreamHandler, pex *PeX, upgrader *Upgrader, book *AddrBook, rv *Rendezvous, acl relay.ACLFilter, gater connmgr.ConnectionGater, shared *SharedHost) (node host.Host, dhtOut *dht.IpfsDHT, err error) {
 
-	maybePrivateNet := libp2p.ChainOptions()
-	swarmKeyFile, ok := os.LookupEnv("HYPRSPACE_SWARM_KEY")
-	if ok {
-		logger.With(zap.String("key", swarmKeyFile)).Info("Using swarm key")
-		var swarmKey *os.File
-		swarmKey, err = os.Open(swarmKeyFile)
-		if err != nil {
-			logger.With(err).Error("Failed to open swarm key-file")
-			return
-		}
-		defer swarmKey.Close()
-		key, _ := pnet.DecodeV1PSK(swarmKey)
-		maybePrivateNet = libp2p.PrivateNetwork(key)
+	maybePrivateNet, privateNet, err := privateNetwork()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transports, err := transportOptions(cfg, privateNet)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	peerChan := make(chan peer.AddrInfo)
 
+	var autoRelay libp2p.Option
+	if cfg.RelayPolicy == config.RelayPolicyStatic {
+		autoRelay = libp2p.EnableAutoRelayWithStaticRelays(cfg.Relays)
+	} else {
+		autoRelay = libp2p.EnableAutoRelayWithPeerSource(
+			relayPeerSource(cfg.Relays, peerChan),
+			autorelay.WithNumRelays(2),
+			autorelay.WithBootDelay(10*time.Second),
+		)
+	}
+
+	var tracer holepunch.EventTracer = upgrader
+	var member *sharedNetwork
+	if shared != nil {
+		member, err = shared.add(cfg, gater, acl, upgrader)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			if err != nil {
+				shared.remove(cfg)
+			}
+		}()
+		gater = sharedGater{shared}
+		acl = sharedRelayACL{shared}
+		tracer = shared
+	}
+
 	logger.Debug("Creating libp2p node")
 
+	rm, err := resourceManager(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	cm, err := connManager(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Resolve unspecified listen addresses (0.0.0.0, ::) to concrete
 	// per-interface IPs, excluding tunnel devices to prevent advertising
 	// tunnel IPs via mDNS (VPN-over-VPN loop prevention).
-	resolved, err := resolveListenAddrs(listenAddreses)
+	resolved, err := resolveListenAddrs(cfg.ListenAddresses)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -151,51 +185,55 @@ func CreateNode(ctx context.Context, privateKey crypto.PrivKey, listenAddreses [
 	basicHost, err := libp2p.New(
 		maybePrivateNet,
 		libp2p.ListenAddrs(resolved...),
-		libp2p.Identity(privateKey),
+		libp2p.Identity(cfg.PrivateKey),
 		libp2p.UserAgent("hyprspace"),
 		libp2p.DefaultSecurity,
 		libp2p.ConnectionGater(gater),
+		libp2p.AddrsFactory(filterAddrs(NewAddrFilters(cfg))),
 		libp2p.NATPortMap(),
 		libp2p.DefaultMuxers,
-		libp2p.Transport(libp2pquic.NewTransport),
-		libp2p.Transport(tcp.NewTCPTransport),
-		libp2p.EnableHolePunching(),
-		libp2p.EnableRelayService(relay.WithLimit(nil), relay.WithACL(acl)),
-		libp2p.EnableNATService(),
-		libp2p.EnableAutoRelayWithPeerSource(
-			func(ctx context.Context, numPeers int) <-chan peer.AddrInfo {
-				r := make(chan peer.AddrInfo)
-				go func() {
-					defer close(r)
-					for ; numPeers != 0; numPeers-- {
-						select {
-						case v, ok := <-peerChan:
-							if !ok {
-								return
-							}
-							select {
-							case r <- v:
-							case <-ctx.Done():
-								return
-							}
-						case <-ctx.Done():
-							return
-						}
-					}
-				}()
-				return r
-			},
-			autorelay.WithNumRelays(2),
-			autorelay.WithBootDelay(10*time.Second),
+		transports,
+		libp2p.EnableRelayService(
+			relay.WithResources(relayResources(cfg)),
+			relay.WithACL(acl),
+			relay.WithMetricsTracer(relay.NewMetricsTracer()),
 		),
+		libp2p.EnableNATService(),
+		autoRelay,
 		libp2p.WithDialTimeout(time.Second*5),
+		libp2p.ResourceManager(rm),
+		libp2p.ConnectionManager(cm),
 		libp2p.FallbackDefaults,
 	)
 	if err != nil {
 		return
 	}
+	// Don't leave the host listening if the rest of the setup fails
+	defer func() {
+		if err == nil {
+			return
+		}
+		if dhtOut != nil {
+			dhtOut.Close()
+		}
+		if upgrader.service != nil {
+			upgrader.service.Close()
+		}
+		basicHost.Close()
+	}()
+
+	// Hole punching is set up by the upgrader instead of libp2p
+	err = upgrader.start(basicHost, tracer)
+	if err != nil {
+		return
+	}
+
+	// Reconnect to peers where we last saw them
+	book.start(basicHost)
+
+	addPeerAddrs(basicHost, cfg)
 
-	staticBootstrapPeers, err := addrInfosFromMultiaddrs(bootstrapPeers)
+	staticBootstrapPeers, err := addrInfosFromMultiaddrs(cfg.BootstrapPeers)
 	if err != nil {
 		return node, nil, err
 	}
@@ -216,32 +254,226 @@ func CreateNode(ctx context.Context, privateKey crypto.PrivKey, listenAddreses [
 		}
 	}
 
+	pex.host = basicHost
+
 	// Create DHT Subsystem
-	dhtOut, err = dht.New(
-		ctx,
-		basicHost,
-		dht.Mode(dht.ModeClient),
-		dht.BootstrapPeers(staticBootstrapPeers...),
-		dht.BootstrapPeersFunc(func() []peer.AddrInfo {
-			extraBootstrapNodes := []string{}
-			ipfsApiStr, ok := os.LookupEnv("HYPRSPACE_IPFS_API")
-			if ok {
-				ipfsApiAddr, err := ma.NewMultiaddr(ipfsApiStr)
-				if err == nil {
-					logger.Debug("Getting additional bootstrap nodes from IPFS API")
-					extraBootstrapNodes = getExtraBootstrapNodes(ipfsApiAddr)
-					logger.With(zap.Int("nodes", len(extraBootstrapNodes))).Debug("Found additional bootstrap nodes")
+	switch cfg.Routing.DHT {
+	case config.DHTPublic:
+		mode := dht.ModeClient
+		if cfg.Routing.DHTServer {
+			mode = dht.ModeServer
+		}
+		dhtOut, err = dht.New(
+			ctx,
+			basicHost,
+			dht.Mode(mode),
+			dht.BootstrapPeers(staticBootstrapPeers...),
+			dht.BootstrapPeersFunc(func() []peer.AddrInfo {
+				extraBootstrapNodes := []string{}
+				ipfsApiStr, ok := os.LookupEnv("HYPRSPACE_IPFS_API")
+				if ok {
+					ipfsApiAddr, err := ma.NewMultiaddr(ipfsApiStr)
+					if err == nil {
+						logger.Debug("Getting additional bootstrap nodes from IPFS API")
+						extraBootstrapNodes = getExtraBootstrapNodes(ipfsApiAddr)
+						logger.With(zap.Int("nodes", len(extraBootstrapNodes))).Debug("Found additional bootstrap nodes")
+					}
+				}
+				dynamicBootstrapPeers, err := parsePeerAddrs(extraBootstrapNodes)
+				if err != nil {
+					return staticBootstrapPeers
+				} else {
+					return append(staticBootstrapPeers, dynamicBootstrapPeers...)
+				}
+			}),
+		)
+	case config.DHTPrivate:
+		dhtOut, err = dht.New(ctx, basicHost, privateDHTOptions(cfg, staticBootstrapPeers)...)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rv.host = basicHost
+	pr, err := networkRouting(cfg, pex, rv, book, dhtOut)
+	if err != nil {
+		return nil, nil, err
+	}
+	node = routedhost.Wrap(basicHost, pr)
+	if shared != nil {
+		shared.host = basicHost
+		shared.dht = dhtOut
+		shared.holePunch = upgrader.service
+		node = sharedNetworkHost{node, shared, member}
+	}
+
+	// Setup Hyprspace Stream Handler
+	for _, proto := range Protocols {
+		node.SetStreamHandler(proto, handler)
+	}
+
+	// Setup PeX Stream Handler
+	for _, proto := range PeXProtocols {
+		node.SetStreamHandler(proto, pex.streamHandler)
+	}
+
+	// The rendezvous point serves everyone, not only the peers of a network
+	if cfg.Rendezvous.Serve {
+		basicHost.SetStreamHandler(RendezvousProtocol, NewRendezvousServer().StreamHandler)
+	}
+
+	for _, r := range cfg.Relays {
+		node.ConnManager().Protect(r.ID, "/hyprspace/relay")
+	}
+
+	// Continuously feed peers into the AutoRelay service
+	if cfg.RelayPolicy != config.RelayPolicyStatic {
+		go func() {
+			delay := backoff.NewExponentialDecorrelatedJitter(time.Second, time.Second*60, 5.0, rand.NewSource(time.Now().UnixMilli()))()
+			// AutoRelay stops reading once the host is closed
+			send := func(pi peer.AddrInfo) bool {
+				select {
+				case peerChan <- pi:
+					return true
+				case <-ctx.Done():
+					return false
 				}
 			}
-			dynamicBootstrapPeers, err := parsePeerAddrs(extraBootstrapNodes)
-			if err != nil {
-				return staticBootstrapPeers
-			} else {
-				return append(staticBootstrapPeers, dynamicBootstrapPeers...)
+			for {
+				for _, p := range node.Network().Peers() {
+					pi := node.Network().Peerstore().PeerInfo(p)
+					if cfg.RelayPolicy == config.RelayPolicyVPN {
+						if _, found := cfg.PeerByID(p); !found {
+							continue
+						}
+						if !send(pi) {
+							return
+						}
+						continue
+					}
+					relayCount := 0
+					for _, la := range node.Network().ListenAddresses() {
+						for _, proto := range la.Protocols() {
+							if proto.Code == ma.P_CIRCUIT {
+								relayCount = relayCount + 1
+								break
+							}
+						}
+					}
+					if _, found := cfg.PeerByID(p); relayCount < 2 || found {
+						if !send(pi) {
+							return
+						}
+					}
+				}
+				timer := time.NewTimer(delay.Delay())
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
 			}
-		}),
+		}()
+	}
+
+	return node, dhtOut, nil
+}
+
+// addPeerAddrs adds the fixed addresses of the peers in cfg to h. They never
+// expire.
+func addPeerAddrs(h host.Host, cfg *config.Config) {
+	for _, p := range cfg.Peers {
+		h.Peerstore().AddAddrs(p.ID, p.Addrs, peerstore.PermanentAddrTTL)
+	}
+}
+
+// networkRouting looks up the peers of cfg through PeX, the DHT d if any, the
+// rendezvous points and the delegated routing endpoints of cfg.
+func networkRouting(cfg *config.Config, pex *PeX, rv *Rendezvous, book *AddrBook, d *dht.IpfsDHT) (ParallelRouting, error) {
+	routings := []routedhost.Routing{PeXRouting{pex}}
+	if d != nil {
+		routings = append(routings, d)
+	}
+	if len(cfg.Rendezvous.Points) > 0 {
+		routings = append(routings, RendezvousRouting{rv})
+	}
+	for _, endpoint := range cfg.Routing.Delegated {
+		r, err := delegatedRouting(cfg, endpoint)
+		if err != nil {
+			return ParallelRouting{}, err
+		}
+		routings = append(routings, r)
+	}
+	return ParallelRouting{
+		routings: routings,
+		book:     book,
+	}, nil
+}
+
+// privateNetwork returns the option to use the swarm key set in the
+// environment, if any, and whether one is set.
+func privateNetwork() (libp2p.Option, bool, error) {
+	swarmKeyFile, ok := os.LookupEnv("HYPRSPACE_SWARM_KEY")
+	if !ok {
+		return libp2p.ChainOptions(), false, nil
+	}
+	logger.With(zap.String("key", swarmKeyFile)).Info("Using swarm key")
+	swarmKey, err := os.Open(swarmKeyFile)
+	if err != nil {
+		logger.With(err).Error("Failed to open swarm key-file")
+		return nil, true, err
+	}
+	defer swarmKey.Close()
+	key, _ := pnet.DecodeV1PSK(swarmKey)
+	return libp2p.PrivateNetwork(key), true, nil
+}
+
+// NewRendezvousHost creates a host that only serves as a rendezvous point.
+func NewRendezvousHost(key crypto.PrivKey, listenAddrs []ma.Multiaddr) (host.Host, error) {
+	maybePrivateNet, _, err := privateNetwork()
+	if err != nil {
+		return nil, err
+	}
+	h, err := libp2p.New(
+		maybePrivateNet,
+		libp2p.ListenAddrs(listenAddrs...),
+		libp2p.Identity(key),
+		libp2p.UserAgent("hyprspace"),
+		libp2p.NATPortMap(),
 	)
+	if err != nil {
+		return nil, err
+	}
+	h.SetStreamHandler(RendezvousProtocol, NewRendezvousServer().StreamHandler)
+	return h, nil
+}
 
+// privateDHTOptions sets up a DHT that only spans VPN peers. Peers with fixed
+// addresses are used to bootstrap it, in addition to the bootstrap peers.
+func privateDHTOptions(cfg *config.Config, bootstrapPeers []peer.AddrInfo) []dht.Option {
+	mode := dht.ModeClient
+	if cfg.Routing.DHTServer {
+		mode = dht.ModeServer
+	}
+	for _, p := range cfg.Peers {
+		if len(p.Addrs) > 0 {
+			bootstrapPeers = append(bootstrapPeers, peer.AddrInfo{ID: p.ID, Addrs: p.Addrs})
+		}
+	}
+	return []dht.Option{
+		dht.Mode(mode),
+		dht.ProtocolPrefix(protocol.ID(cfg.Routing.DHTPrefix)),
+		dht.BootstrapPeers(bootstrapPeers...),
+		dht.RoutingTableFilter(func(_ interface{}, p peer.ID) bool {
+			_, found := cfg.PeerByID(p)
+			return found
+		}),
+	}
+}
+
+// delegatedRouting looks up peers with a delegated routing endpoint.
+func delegatedRouting(cfg *config.Config, endpoint string) (routedhost.Routing, error) {
 	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.MaxIdleConns = 500
 	transport.MaxIdleConnsPerHost = 100
@@ -252,60 +484,20 @@ func CreateNode(ctx context.Context, privateKey crypto.PrivKey, listenAddreses [
 		},
 	}
 	dr, err := drclient.New(
-		"https://p2p.privatevoid.net",
+		endpoint,
 		drclient.WithHTTPClient(delegateHTTPClient),
-		drclient.WithIdentity(privateKey),
+		drclient.WithIdentity(cfg.PrivateKey),
 		drclient.WithUserAgent("hyprspace"),
 	)
 	if err != nil {
-		return node, nil, err
+		return nil, err
 	}
-
 	cr := contentrouter.NewContentRoutingClient(dr)
-
-	pexr := PeXRouting{basicHost, vpnPeers}
-
-	pr := ParallelRouting{[]routedhost.Routing{pexr, dhtOut, httpRoutingWrapper{
+	return httpRoutingWrapper{
 		ContentRouting: cr,
 		PeerRouting:    cr,
 		ValueStore:     cr,
-	}}}
-
-	node = routedhost.Wrap(basicHost, pr)
-
-	// Setup Hyprspace Stream Handler
-	for _, proto := range Protocols {
-		node.SetStreamHandler(proto, handler)
-	}
-
-	if err != nil {
-		return node, nil, err
-	}
-
-	// Continuously feed peers into the AutoRelay service
-	go func() {
-		delay := backoff.NewExponentialDecorrelatedJitter(time.Second, time.Second*60, 5.0, rand.NewSource(time.Now().UnixMilli()))()
-		for {
-			for _, p := range node.Network().Peers() {
-				pi := node.Network().Peerstore().PeerInfo(p)
-				relayCount := 0
-				for _, la := range node.Network().ListenAddresses() {
-					for _, proto := range la.Protocols() {
-						if proto.Code == ma.P_CIRCUIT {
-							relayCount = relayCount + 1
-							break
-						}
-					}
-				}
-				if relayCount < 2 || acl.AllowReserve(p, node.Addrs()[0]) {
-					peerChan <- pi
-				}
-			}
-			time.Sleep(delay.Delay())
-		}
-	}()
-
-	return node, dhtOut, nil
+	}, nil
 }
 
 func parsePeerAddrs(peers []string) (addrs []peer.AddrInfo, err error) {
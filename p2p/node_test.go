@@ -0,0 +1,31 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hyprspace/hyprspace/config"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_privateDHTOptions(t *testing.T) {
+	ids := makeTestIDs(t, 2)
+	cfg := makeTestConfig(ids...)
+	cfg.Peers[0].Addrs = testAddrs(1)
+	cfg.Routing = config.Routing{DHT: config.DHTPrivate, DHTServer: true, DHTPrefix: "/hyprspace"}
+
+	d, err := dht.New(context.Background(), makeTestHost(t), privateDHTOptions(cfg, nil)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { d.Close() })
+	assert.Equal(t, dht.ModeServer, d.Mode())
+	assert.Contains(t, d.Host().Mux().Protocols(), protocol.ID("/hyprspace/kad/1.0.0"))
+}
+
+func Test_delegatedRouting(t *testing.T) {
+	cfg := makeTestConfig()
+	_, err := delegatedRouting(cfg, "https://p2p.example.com")
+	assert.NoError(t, err)
+}
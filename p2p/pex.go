@@ -2,9 +2,13 @@ package p2p
 
 import (
 	"bufio"
+	"bytes"
+	"container/list"
 	"context"
+	"errors"
 	"fmt"
-	"io"
+	"math/rand/v2"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -14,148 +18,576 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/record"
 	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/multiformats/go-multiaddr"
 )
 
-type PeXRouting struct {
-	host     host.Host
-	vpnPeers []config.Peer
+// Version 0: Plain text, unsigned
+const PeXProtocolV0 = "/hyprspace/pex/0.0.1"
+
+// Version 2: Signed peer records, incremental updates
+const PeXProtocolV2 = "/hyprspace/pex/2"
+
+var PeXProtocols = []protocol.ID{
+	PeXProtocolV2,
+	PeXProtocolV0,
 }
 
-const PeXProtocol = "/hyprspace/pex/0.0.1"
+// How long addresses learned from signed peer records stay in the peerstore.
+const pexAddrTTL = 10 * time.Minute
 
-func checkErrPeX(err error, stream network.Stream) bool {
-	if err != nil {
-		stream.Reset()
+// How long addresses learned via PeX v0 stay in the peerstore. These aren't
+// signed by the peer they belong to, so we don't hold on to them for long.
+const pexUnsignedAddrTTL = 30 * time.Second
+
+// How many connected VPN peers to ask for updates when a peer disconnects.
+const pexFanout = 3
+
+// Upper bound for the number of addresses accepted per peer.
+const pexMaxAddrs = 64
+
+// Upper bound for the number of records in a single PeX response. The
+// responder may know many more VPN peers than the requester, so this doesn't
+// depend on our own config. A responder with more changed records sends them
+// over several responses.
+const pexMaxRecords = 10000
+
+// Upper bound for a single line in PeX v0.
+const pexMaxLineLength = 4096
+
+var errPeXMalformed = errors.New("malformed PeX record")
+var errPeXTooMany = errors.New("too many PeX records")
+
+// pexRecord is the set of addresses advertised for a single VPN peer, along
+// with the signed peer record of that peer, if we have one. Records of peers
+// that are no longer configured are kept as removed, so requesters learn
+// about the removal.
+type pexRecord struct {
+	id       peer.ID
+	addrs    []multiaddr.Multiaddr
+	envelope []byte
+	seq      uint64
+	removed  bool
+}
+
+// pexTable holds the records we serve to other VPN peers. Each change to a
+// record bumps its sequence number and moves it to the back of the log, so a
+// requester that already has everything up to a given sequence number only
+// needs to be sent the tail of the log.
+type pexTable struct {
+	lock    sync.RWMutex
+	epoch   uint64
+	seq     uint64
+	log     *list.List
+	records map[peer.ID]*list.Element
+}
+
+func newPeXTable() *pexTable {
+	return &pexTable{
+		epoch:   rand.Uint64(),
+		log:     list.New(),
+		records: make(map[peer.ID]*list.Element),
+	}
+}
+
+// update replaces the contents of a record, returning whether anything changed.
+func (t *pexTable) update(id peer.ID, addrs []multiaddr.Multiaddr, envelope []byte) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if e, ok := t.records[id]; ok {
+		rec := e.Value.(*pexRecord)
+		if !rec.removed && sameAddrs(rec.addrs, addrs) && bytes.Equal(rec.envelope, envelope) {
+			return false
+		}
+		t.seq++
+		rec.addrs = addrs
+		rec.envelope = envelope
+		rec.seq = t.seq
+		rec.removed = false
+		t.log.MoveToBack(e)
 		return true
 	}
-	return false
+	if len(addrs) == 0 && envelope == nil {
+		return false
+	}
+	t.seq++
+	t.records[id] = t.log.PushBack(&pexRecord{
+		id:       id,
+		addrs:    addrs,
+		envelope: envelope,
+		seq:      t.seq,
+	})
+	return true
+}
+
+// retain marks the records of all peers for which keep returns false as
+// removed.
+func (t *pexTable) retain(keep func(peer.ID) bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for id, e := range t.records {
+		rec := e.Value.(*pexRecord)
+		if rec.removed || keep(id) {
+			continue
+		}
+		t.seq++
+		rec.addrs = nil
+		rec.envelope = nil
+		rec.seq = t.seq
+		rec.removed = true
+		t.log.MoveToBack(e)
+	}
+}
+
+// since returns all records that changed after the given sequence number,
+// oldest first, along with the current sequence number. If the epoch doesn't
+// match ours, the requester's view is from a previous run and all records are
+// returned.
+func (t *pexTable) since(epoch uint64, seq uint64) ([]pexRecord, uint64) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if epoch != t.epoch || seq > t.seq {
+		seq = 0
+	}
+	var records []pexRecord
+	for e := t.log.Back(); e != nil; e = e.Prev() {
+		rec := e.Value.(*pexRecord)
+		if rec.seq <= seq {
+			break
+		}
+		records = append(records, *rec)
+	}
+	slices.Reverse(records)
+	return records, t.seq
 }
 
-func NewPeXStreamHandler(host host.Host, cfg *config.Config) func(network.Stream) {
-	return func(stream network.Stream) {
+func sameAddrs(a []multiaddr.Multiaddr, b []multiaddr.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
 		found := false
-		for _, p := range cfg.Peers {
-			if p.ID == stream.Conn().RemotePeer() {
+		for _, y := range b {
+			if x.Equal(y) {
 				found = true
 				break
 			}
 		}
 		if !found {
-			stream.Reset()
-			return
+			return false
 		}
-		buf := bufio.NewReader(stream)
-		str, err := buf.ReadString('\n')
-		if checkErrPeX(err, stream) {
-			logger.With(err).Error("Failed to read from stream")
-			return
-		}
-		str = strings.TrimSuffix(str, "\n")
-		if str == "r" {
-			// peer requests addresses
-			for _, p := range cfg.Peers {
-				if p.ID != stream.Conn().RemotePeer() {
-					for _, a := range host.Peerstore().Addrs(p.ID) {
-						_, err := stream.Write([]byte(fmt.Sprintf("%s|%s\n", p.ID, a)))
-						if checkErrPeX(err, stream) {
-							logger.With(err).Error("Failed to write to stream")
-							return
-						}
-					}
-				}
+	}
+	return true
+}
+
+type pexCursor struct {
+	epoch uint64
+	seq   uint64
+}
+
+// PeX exchanges the addresses of VPN peers between VPN peers.
+type PeX struct {
+	host    host.Host
+	config  *config.Config
+	table   *pexTable
+	lock    sync.Mutex
+	cursors map[peer.ID]pexCursor
+	known   map[peer.ID][]multiaddr.Multiaddr
+}
+
+func NewPeX(cfg *config.Config) *PeX {
+	return &PeX{
+		config:  cfg,
+		table:   newPeXTable(),
+		cursors: make(map[peer.ID]pexCursor),
+		known:   make(map[peer.ID][]multiaddr.Multiaddr),
+	}
+}
+
+type PeXRouting struct {
+	pex *PeX
+}
+
+func checkErrPeX(err error, stream network.Stream) bool {
+	if err != nil {
+		stream.Reset()
+		return true
+	}
+	return false
+}
+
+// refresh updates the record of a VPN peer from the peerstore.
+func (pex *PeX) refresh(p peer.ID) {
+	var envelope []byte
+	if cab, ok := peerstore.GetCertifiedAddrBook(pex.host.Peerstore()); ok {
+		if env := cab.GetPeerRecord(p); env != nil {
+			var err error
+			envelope, err = env.Marshal()
+			if err != nil {
+				envelope = nil
 			}
-			stream.Close()
 		}
 	}
+	pex.table.update(p, pex.host.Peerstore().Addrs(p), envelope)
 }
 
-func RequestPeX(ctx context.Context, host host.Host, peers []peer.ID) (addrInfos []peer.AddrInfo, e error) {
-	for _, p := range peers {
-		s, err := host.NewStream(ctx, p, PeXProtocol)
+func (pex *PeX) refreshAll() {
+	for _, p := range pex.config.Peers {
+		pex.refresh(p.ID)
+	}
+	pex.table.retain(pex.isVPNPeer)
+}
+
+func (pex *PeX) streamHandler(stream network.Stream) {
+	remotePeer := stream.Conn().RemotePeer()
+	if _, found := pex.config.PeerByID(remotePeer); !found {
+		stream.Reset()
+		return
+	}
+	stream.SetDeadline(time.Now().Add(10 * time.Second))
+	var err error
+	switch stream.Protocol() {
+	case PeXProtocolV0:
+		err = pex.serveV0(stream)
+	case PeXProtocolV2:
+		err = pex.serveV2(stream)
+	}
+	if checkErrPeX(err, stream) {
+		logger.With(err).Error("Failed to serve PeX request")
+		return
+	}
+	stream.Close()
+}
+
+func (pex *PeX) serveV0(stream network.Stream) error {
+	buf := bufio.NewReaderSize(stream, 16)
+	line, err := buf.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	if string(line) != "r\n" {
+		return errPeXMalformed
+	}
+	// peer requests addresses
+	w := bufio.NewWriter(stream)
+	records, _ := pex.table.since(0, 0)
+	for _, rec := range records {
+		if rec.id == stream.Conn().RemotePeer() {
+			continue
+		}
+		for _, a := range rec.addrs {
+			fmt.Fprintf(w, "%s|%s\n", rec.id, a)
+		}
+	}
+	return w.Flush()
+}
+
+func (pex *PeX) serveV2(stream network.Stream) error {
+	kind, data, err := readPeXMsg(bufio.NewReader(stream))
+	if err != nil {
+		return err
+	}
+	if kind != pexMsgRequest {
+		return errPeXMalformed
+	}
+	cursor, err := decodePeXCursor(data)
+	if err != nil {
+		return err
+	}
+	// peer requests records that changed since it last asked
+	w := bufio.NewWriter(stream)
+	records, seq := pex.table.since(cursor.epoch, cursor.seq)
+	sent := 0
+	for _, rec := range records {
+		if sent == pexMaxRecords {
+			// the requester continues after the last record sent
+			break
+		}
+		seq = rec.seq
+		if rec.id == stream.Conn().RemotePeer() {
+			continue
+		}
+		switch {
+		case rec.removed:
+			err = writePeXMsg(w, pexMsgRemoved, []byte(rec.id))
+		case rec.envelope != nil:
+			err = writePeXMsg(w, pexMsgRecord, rec.envelope)
+		default:
+			// without a signed record there is nothing to send yet
+			continue
+		}
+		if errors.Is(err, errPeXTooLarge) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		sent++
+	}
+	err = writePeXMsg(w, pexMsgEnd, encodePeXCursor(pexCursor{pex.table.epoch, seq}))
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Request asks a connected VPN peer for the addresses of other VPN peers and
+// adds them to the peerstore. Only records that changed since the last
+// request to the same peer are transferred, if the peer supports it.
+func (pex *PeX) Request(ctx context.Context, p peer.ID) (addrInfos []peer.AddrInfo, e error) {
+	for {
+		page, more, err := pex.request(ctx, p)
 		if err != nil {
 			return nil, err
 		}
-		s.Write([]byte("r\n"))
-		s.SetDeadline(time.Now().Add(10 * time.Second))
-		buf := bufio.NewReader(s)
-		for {
-			str, err := buf.ReadString('\n')
-			if err == io.EOF {
-				s.Close()
-				return addrInfos, nil
-			} else if checkErrPeX(err, s) {
-				s.Close()
-				return nil, err
+		addrInfos = append(addrInfos, page...)
+		if !more {
+			return addrInfos, nil
+		}
+	}
+}
+
+// request does a single exchange of Request. It reports whether the peer has
+// more records than fit in one response.
+func (pex *PeX) request(ctx context.Context, p peer.ID) (addrInfos []peer.AddrInfo, more bool, e error) {
+	s, err := pex.host.NewStream(ctx, p, PeXProtocols...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(10 * time.Second))
+	switch s.Protocol() {
+	case PeXProtocolV0:
+		addrInfos, err = pex.requestV0(s)
+	default:
+		addrInfos, more, err = pex.requestV2(s)
+	}
+	if checkErrPeX(err, s) {
+		return nil, false, err
+	}
+	pex.lock.Lock()
+	defer pex.lock.Unlock()
+	for _, ai := range addrInfos {
+		pex.known[ai.ID] = ai.Addrs
+	}
+	return addrInfos, more, nil
+}
+
+func (pex *PeX) requestV0(s network.Stream) (addrInfos []peer.AddrInfo, e error) {
+	_, err := s.Write([]byte("r\n"))
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(s)
+	scanner.Buffer(make([]byte, 0, 256), pexMaxLineLength)
+	index := make(map[peer.ID]int)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+		if lines > pexMaxRecords*pexMaxAddrs {
+			return nil, errPeXTooMany
+		}
+		idStr, addrStr, ok := strings.Cut(scanner.Text(), "|")
+		if !ok {
+			return nil, errPeXMalformed
+		}
+		peerId, err := peer.Decode(idStr)
+		if err != nil {
+			return nil, err
+		}
+		ma, err := multiaddr.NewMultiaddr(addrStr)
+		if err != nil {
+			return nil, err
+		}
+		if !pex.isVPNPeer(peerId) {
+			continue
+		}
+		i, ok := index[peerId]
+		if !ok {
+			i = len(addrInfos)
+			index[peerId] = i
+			addrInfos = append(addrInfos, peer.AddrInfo{ID: peerId})
+		}
+		if len(addrInfos[i].Addrs) < pexMaxAddrs {
+			addrInfos[i].Addrs = append(addrInfos[i].Addrs, ma)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, ai := range addrInfos {
+		pex.host.Peerstore().AddAddrs(ai.ID, ai.Addrs, pexUnsignedAddrTTL)
+	}
+	return addrInfos, nil
+}
+
+// requestV2 reports whether the response was full, so the peer may have more
+// records after the cursor it sent.
+func (pex *PeX) requestV2(s network.Stream) (addrInfos []peer.AddrInfo, more bool, e error) {
+	remotePeer := s.Conn().RemotePeer()
+	pex.lock.Lock()
+	cursor := pex.cursors[remotePeer]
+	pex.lock.Unlock()
+
+	err := writePeXMsg(s, pexMsgRequest, encodePeXCursor(cursor))
+	if err != nil {
+		return nil, false, err
+	}
+	buf := bufio.NewReader(s)
+	for received := 0; received <= pexMaxRecords; received++ {
+		kind, data, err := readPeXMsg(buf)
+		if err != nil {
+			// the stream must end with an end message
+			return nil, false, err
+		}
+		switch kind {
+		case pexMsgEnd:
+			next, err := decodePeXCursor(data)
+			if err != nil {
+				return nil, false, err
+			}
+			pex.lock.Lock()
+			pex.cursors[remotePeer] = next
+			pex.lock.Unlock()
+			// a peer that doesn't move on would keep us asking forever
+			return addrInfos, received == pexMaxRecords && next != cursor, nil
+		case pexMsgRemoved:
+			peerId, err := peer.IDFromBytes(data)
+			if err != nil {
+				return nil, false, err
 			}
-			str = strings.TrimSuffix(str, "\n")
-			splits := strings.Split(str, "|")
-			idStr := splits[0]
-			addrStr := splits[1]
-			peerId, err := peer.Decode(idStr)
-			if checkErrPeX(err, s) {
-				s.Close()
-				return nil, err
+			if pex.isVPNPeer(peerId) {
+				addrInfos = append(addrInfos, peer.AddrInfo{ID: peerId})
 			}
-			ma, err := multiaddr.NewMultiaddr(addrStr)
-			if checkErrPeX(err, s) {
-				s.Close()
-				return nil, err
+		case pexMsgRecord:
+			ai, err := pex.consumeRecord(data)
+			if err != nil {
+				logger.With(err).Debug("Rejected PeX record")
+				continue
 			}
-			addrInfos = append(addrInfos, peer.AddrInfo{
-				ID:    peerId,
-				Addrs: []multiaddr.Multiaddr{ma},
-			})
+			addrInfos = append(addrInfos, ai)
+		default:
+			return nil, false, errPeXMalformed
 		}
 	}
-	return addrInfos, nil
+	return nil, false, errPeXTooMany
+}
+
+// consumeRecord validates a signed peer record and adds its addresses to the
+// peerstore. Only records of VPN peers, signed by the peer itself, are accepted.
+func (pex *PeX) consumeRecord(data []byte) (peer.AddrInfo, error) {
+	env, rec, err := record.ConsumeEnvelope(data, peer.PeerRecordEnvelopeDomain)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	pr, ok := rec.(*peer.PeerRecord)
+	if !ok {
+		return peer.AddrInfo{}, errPeXMalformed
+	}
+	if !pr.PeerID.MatchesPublicKey(env.PublicKey) {
+		return peer.AddrInfo{}, errors.New("peer record not signed by its peer")
+	}
+	if !pex.isVPNPeer(pr.PeerID) {
+		return peer.AddrInfo{}, errors.New("peer record of non-VPN peer")
+	}
+	if len(pr.Addrs) > pexMaxAddrs {
+		return peer.AddrInfo{}, errPeXTooMany
+	}
+	if cab, ok := peerstore.GetCertifiedAddrBook(pex.host.Peerstore()); ok {
+		if _, err := cab.ConsumePeerRecord(env, pexAddrTTL); err != nil {
+			return peer.AddrInfo{}, err
+		}
+	} else {
+		pex.host.Peerstore().AddAddrs(pr.PeerID, pr.Addrs, pexAddrTTL)
+	}
+	return peer.AddrInfo{ID: pr.PeerID, Addrs: pr.Addrs}, nil
+}
+
+func (pex *PeX) isVPNPeer(p peer.ID) bool {
+	if p == pex.host.ID() {
+		return false
+	}
+	_, found := pex.config.PeerByID(p)
+	return found
+}
+
+// learnFrom requests PeX records from a VPN peer and tries to connect to the
+// peers whose addresses changed.
+func (pex *PeX) learnFrom(ctx context.Context, p peer.ID) {
+	addrInfos, err := pex.Request(ctx, p)
+	if err != nil {
+		return
+	}
+	for _, addrInfo := range addrInfos {
+		if len(addrInfo.Addrs) == 0 {
+			continue
+		}
+		if pex.host.Network().Connectedness(addrInfo.ID) != network.Connected {
+			go pex.host.Connect(ctx, addrInfo)
+		}
+	}
+}
+
+// connectedVPNPeers returns up to max VPN peers we're currently connected to,
+// in random order. A negative max returns all of them.
+func (pex *PeX) connectedVPNPeers(max int) []peer.ID {
+	var peers []peer.ID
+	for _, p := range pex.host.Network().Peers() {
+		if _, found := pex.config.PeerByID(p); found {
+			peers = append(peers, p)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+	if max >= 0 && len(peers) > max {
+		peers = peers[:max]
+	}
+	return peers
 }
 
-func PeXService(ctx context.Context, wg *sync.WaitGroup, host host.Host, cfg *config.Config) {
-	subCon, err := host.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged))
+func (pex *PeX) Service(ctx context.Context, wg *sync.WaitGroup) {
+	subCon, err := pex.host.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerConnectednessChanged),
+		new(event.EvtPeerIdentificationCompleted),
+	})
 	if err != nil {
 		logger.With(err).Fatal("Failed to subscribe to EventBus")
 	}
+	defer subCon.Close()
 	logger.Info("PeX service ready")
 	wg.Add(1)
 	defer wg.Done()
+	pex.refreshAll()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Debug("Stopping Peer-Exchange service")
 			return
+		case <-ticker.C:
+			pex.refreshAll()
 		case ev := <-subCon.Out():
-			evt := ev.(event.EvtPeerConnectednessChanged)
-			for _, vpnPeer := range cfg.Peers {
-				if vpnPeer.ID == evt.Peer {
-					switch evt.Connectedness {
-					case network.Connected:
-						go func() {
-							addrInfos, err := RequestPeX(ctx, host, []peer.ID{evt.Peer})
-							if err == nil {
-								for _, addrInfo := range addrInfos {
-									host.Peerstore().AddAddrs(addrInfo.ID, addrInfo.Addrs, 30*time.Second)
-									go host.Connect(ctx, addrInfo)
-								}
-							}
-						}()
-					case network.NotConnected:
-						peers := []peer.ID{}
-						for _, p := range cfg.Peers {
-							peers = append(peers, p.ID)
-						}
-						go func() {
-							addrInfos, err := RequestPeX(ctx, host, peers)
-							if err == nil {
-								for _, addrInfo := range addrInfos {
-									host.Peerstore().AddAddrs(addrInfo.ID, addrInfo.Addrs, 30*time.Second)
-									go host.Connect(ctx, addrInfo)
-								}
-							}
-						}()
+			switch evt := ev.(type) {
+			case event.EvtPeerIdentificationCompleted:
+				if _, found := pex.config.PeerByID(evt.Peer); found {
+					pex.refresh(evt.Peer)
+				}
+			case event.EvtPeerConnectednessChanged:
+				if _, found := pex.config.PeerByID(evt.Peer); !found {
+					continue
+				}
+				pex.refresh(evt.Peer)
+				switch evt.Connectedness {
+				case network.Connected:
+					go pex.learnFrom(ctx, evt.Peer)
+				case network.NotConnected:
+					for _, p := range pex.connectedVPNPeers(pexFanout) {
+						go pex.learnFrom(ctx, p)
 					}
-					break
 				}
 			}
 		}
@@ -163,29 +595,31 @@ func PeXService(ctx context.Context, wg *sync.WaitGroup, host host.Host, cfg *co
 }
 
 func (pexr PeXRouting) FindPeer(ctx context.Context, targetPeer peer.ID) (peer.AddrInfo, error) {
-	found := false
-	peers := []peer.ID{}
+	pex := pexr.pex
 	addrInfo := peer.AddrInfo{
 		ID: targetPeer,
 	}
-	for _, p := range pexr.vpnPeers {
-		peers = append(peers, p.ID)
-		if p.ID == targetPeer {
-			found = true
-		}
-	}
 	// PeX routing only returns VPN node addresses
-	if !found {
+	if _, found := pex.config.PeerByID(targetPeer); !found {
 		return addrInfo, routing.ErrNotFound
 	}
-	addrInfos, err := RequestPeX(ctx, pexr.host, peers)
-	if err != nil {
-		return addrInfo, err
-	}
-	for _, ai := range addrInfos {
-		if ai.ID == targetPeer {
-			addrInfo.Addrs = append(addrInfo.Addrs, ai.Addrs...)
+	var wg sync.WaitGroup
+	for _, p := range pex.connectedVPNPeers(-1) {
+		if p == targetPeer {
+			continue
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pex.Request(ctx, p)
+		}()
+	}
+	wg.Wait()
+	pex.lock.Lock()
+	defer pex.lock.Unlock()
+	addrInfo.Addrs = append(addrInfo.Addrs, pex.known[targetPeer]...)
+	if len(addrInfo.Addrs) == 0 {
+		return addrInfo, routing.ErrNotFound
 	}
 	return addrInfo, nil
 }
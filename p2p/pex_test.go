@@ -0,0 +1,408 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestIDs(tb testing.TB, n int) []peer.ID {
+	ids := make([]peer.ID, n)
+	for i := range n {
+		pk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+		require.NoError(tb, err)
+		ids[i], err = peer.IDFromPrivateKey(pk)
+		require.NoError(tb, err)
+	}
+	return ids
+}
+
+func testAddrs(i int) []multiaddr.Multiaddr {
+	return []multiaddr.Multiaddr{
+		multiaddr.StringCast(fmt.Sprintf("/ip4/192.0.2.%d/tcp/8001", i%256)),
+		multiaddr.StringCast(fmt.Sprintf("/ip4/192.0.2.%d/udp/8001/quic-v1", i%256)),
+	}
+}
+
+func Test_pexTable(t *testing.T) {
+	ids := makeTestIDs(t, 3)
+
+	t.Run("full dump", func(t *testing.T) {
+		table := newPeXTable()
+		for i, id := range ids {
+			assert.True(t, table.update(id, testAddrs(i), nil))
+		}
+		records, seq := table.since(0, 0)
+		assert.Len(t, records, 3)
+		assert.Equal(t, uint64(3), seq)
+	})
+
+	t.Run("unchanged records are skipped", func(t *testing.T) {
+		table := newPeXTable()
+		for i, id := range ids {
+			table.update(id, testAddrs(i), nil)
+		}
+		_, seq := table.since(0, 0)
+
+		// same addresses in a different order
+		addrs := testAddrs(1)
+		assert.False(t, table.update(ids[1], []multiaddr.Multiaddr{addrs[1], addrs[0]}, nil))
+		records, seq2 := table.since(table.epoch, seq)
+		assert.Empty(t, records)
+		assert.Equal(t, seq, seq2)
+	})
+
+	t.Run("only changed records", func(t *testing.T) {
+		table := newPeXTable()
+		for i, id := range ids {
+			table.update(id, testAddrs(i), nil)
+		}
+		_, seq := table.since(0, 0)
+
+		assert.True(t, table.update(ids[0], testAddrs(42), nil))
+		records, seq2 := table.since(table.epoch, seq)
+		require.Len(t, records, 1)
+		assert.Equal(t, ids[0], records[0].id)
+		assert.Equal(t, seq+1, seq2)
+	})
+
+	t.Run("removed addresses", func(t *testing.T) {
+		table := newPeXTable()
+		assert.False(t, table.update(ids[0], nil, nil), "empty new record should not be stored")
+		table.update(ids[1], testAddrs(1), nil)
+		_, seq := table.since(0, 0)
+
+		assert.True(t, table.update(ids[1], nil, nil))
+		records, _ := table.since(table.epoch, seq)
+		require.Len(t, records, 1)
+		assert.Empty(t, records[0].addrs)
+	})
+
+	t.Run("removed peers", func(t *testing.T) {
+		table := newPeXTable()
+		for i, id := range ids {
+			table.update(id, testAddrs(i), nil)
+		}
+		_, seq := table.since(0, 0)
+
+		table.retain(func(id peer.ID) bool { return id != ids[2] })
+		records, seq2 := table.since(table.epoch, seq)
+		require.Len(t, records, 1)
+		assert.Equal(t, ids[2], records[0].id)
+		assert.True(t, records[0].removed)
+
+		table.retain(func(id peer.ID) bool { return id != ids[2] })
+		records, _ = table.since(table.epoch, seq2)
+		assert.Empty(t, records, "already removed")
+
+		assert.True(t, table.update(ids[2], testAddrs(2), nil))
+		records, _ = table.since(table.epoch, seq2)
+		require.Len(t, records, 1)
+		assert.False(t, records[0].removed)
+	})
+
+	t.Run("changed envelope", func(t *testing.T) {
+		table := newPeXTable()
+		table.update(ids[0], testAddrs(0), []byte("a"))
+		_, seq := table.since(0, 0)
+
+		assert.False(t, table.update(ids[0], testAddrs(0), []byte("a")))
+		assert.True(t, table.update(ids[0], testAddrs(0), []byte("b")))
+		records, _ := table.since(table.epoch, seq)
+		require.Len(t, records, 1)
+		assert.Equal(t, []byte("b"), records[0].envelope)
+	})
+
+	t.Run("epoch mismatch", func(t *testing.T) {
+		table := newPeXTable()
+		for i, id := range ids {
+			table.update(id, testAddrs(i), nil)
+		}
+		_, seq := table.since(0, 0)
+
+		records, _ := table.since(table.epoch+1, seq)
+		assert.Len(t, records, 3, "requester from a previous run should get all records")
+		records, _ = table.since(table.epoch, seq+10)
+		assert.Len(t, records, 3, "requester ahead of us should get all records")
+	})
+}
+
+func Test_PeXMsg(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		cursor := pexCursor{epoch: 1234, seq: 56}
+		require.NoError(t, writePeXMsg(&buf, pexMsgRequest, encodePeXCursor(cursor)))
+		require.NoError(t, writePeXMsg(&buf, pexMsgRemoved, []byte("x")))
+
+		r := bufio.NewReader(&buf)
+		kind, data, err := readPeXMsg(r)
+		require.NoError(t, err)
+		assert.Equal(t, pexMsgRequest, kind)
+		decoded, err := decodePeXCursor(data)
+		require.NoError(t, err)
+		assert.Equal(t, cursor, decoded)
+
+		kind, data, err = readPeXMsg(r)
+		require.NoError(t, err)
+		assert.Equal(t, pexMsgRemoved, kind)
+		assert.Equal(t, []byte("x"), data)
+
+		_, _, err = readPeXMsg(r)
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.ErrorIs(t, writePeXMsg(&buf, pexMsgRecord, make([]byte, pexMaxMessageSize)), errPeXTooLarge)
+
+		buf.Write(binary.AppendUvarint(nil, pexMaxMessageSize+1))
+		_, _, err := readPeXMsg(bufio.NewReader(&buf))
+		assert.ErrorIs(t, err, errPeXTooLarge)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, _, err := readPeXMsg(bufio.NewReader(bytes.NewReader([]byte{0})))
+		assert.ErrorIs(t, err, errPeXMalformed)
+	})
+
+	t.Run("truncated cursor", func(t *testing.T) {
+		_, err := decodePeXCursor(make([]byte, 15))
+		assert.ErrorIs(t, err, errPeXMalformed)
+	})
+}
+
+func makeTestHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func makeTestConfig(members ...peer.ID) *config.Config {
+	cfg := &config.Config{
+		PeerLookup: config.PeerLookup{ByID: make(map[peer.ID]config.Peer)},
+	}
+	for _, id := range members {
+		p := config.Peer{ID: id}
+		cfg.Peers = append(cfg.Peers, p)
+		cfg.PeerLookup.ByID[id] = p
+	}
+	return cfg
+}
+
+func makeTestPeX(t *testing.T, members ...peer.ID) *PeX {
+	pex := NewPeX(makeTestConfig(members...))
+	pex.host = makeTestHost(t)
+	return pex
+}
+
+func sealTestRecord(t *testing.T, key crypto.PrivKey, id peer.ID, addrs []multiaddr.Multiaddr) []byte {
+	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: id, Addrs: addrs})
+	env, err := record.Seal(rec, key)
+	require.NoError(t, err)
+	data, err := env.Marshal()
+	require.NoError(t, err)
+	return data
+}
+
+func Test_PeX_consumeRecord(t *testing.T) {
+	memberKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	member, err := peer.IDFromPrivateKey(memberKey)
+	require.NoError(t, err)
+	outsiderKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	outsider, err := peer.IDFromPrivateKey(outsiderKey)
+	require.NoError(t, err)
+
+	t.Run("signed by member", func(t *testing.T) {
+		pex := makeTestPeX(t, member)
+		ai, err := pex.consumeRecord(sealTestRecord(t, memberKey, member, testAddrs(1)))
+		require.NoError(t, err)
+		assert.Equal(t, member, ai.ID)
+		assert.Len(t, ai.Addrs, 2)
+		assert.Len(t, pex.host.Peerstore().Addrs(member), 2)
+	})
+
+	t.Run("signed by someone else", func(t *testing.T) {
+		pex := makeTestPeX(t, member)
+		_, err := pex.consumeRecord(sealTestRecord(t, outsiderKey, member, testAddrs(1)))
+		assert.Error(t, err)
+		assert.Empty(t, pex.host.Peerstore().Addrs(member))
+	})
+
+	t.Run("not a member", func(t *testing.T) {
+		pex := makeTestPeX(t, member)
+		_, err := pex.consumeRecord(sealTestRecord(t, outsiderKey, outsider, testAddrs(1)))
+		assert.Error(t, err)
+		assert.Empty(t, pex.host.Peerstore().Addrs(outsider))
+	})
+
+	t.Run("too many addresses", func(t *testing.T) {
+		pex := makeTestPeX(t, member)
+		var addrs []multiaddr.Multiaddr
+		for i := range pexMaxAddrs + 1 {
+			addrs = append(addrs, multiaddr.StringCast(fmt.Sprintf("/ip4/192.0.2.1/tcp/%d", i+1)))
+		}
+		_, err := pex.consumeRecord(sealTestRecord(t, memberKey, member, addrs))
+		assert.ErrorIs(t, err, errPeXTooMany)
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		pex := makeTestPeX(t, member)
+		_, err := pex.consumeRecord([]byte("garbage"))
+		assert.Error(t, err)
+	})
+}
+
+// A leaf of a hub-and-spoke network only knows a few of the peers the hub
+// sends it.
+func Test_PeX_Request_moreRecordsThanPeers(t *testing.T) {
+	ctx := context.Background()
+	keys := make([]crypto.PrivKey, 5)
+	ids := make([]peer.ID, len(keys))
+	for i := range keys {
+		var err error
+		keys[i], _, err = crypto.GenerateKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+		ids[i], err = peer.IDFromPrivateKey(keys[i])
+		require.NoError(t, err)
+	}
+	hubHost := makeTestListeningHost(t)
+	leafHost := makeTestListeningHost(t)
+
+	hub := NewPeX(makeTestConfig(append(ids, leafHost.ID())...))
+	hub.host = hubHost
+	for _, proto := range PeXProtocols {
+		hubHost.SetStreamHandler(proto, hub.streamHandler)
+	}
+	for i, id := range ids {
+		hub.table.update(id, testAddrs(i), sealTestRecord(t, keys[i], id, testAddrs(i)))
+	}
+
+	leaf := NewPeX(makeTestConfig(hubHost.ID(), ids[0]))
+	leaf.host = leafHost
+	require.NoError(t, leafHost.Connect(ctx, peer.AddrInfo{ID: hubHost.ID(), Addrs: hubHost.Addrs()}))
+
+	t.Run("v2", func(t *testing.T) {
+		addrInfos, err := leaf.Request(ctx, hubHost.ID())
+		require.NoError(t, err)
+		require.Len(t, addrInfos, 1)
+		assert.Equal(t, ids[0], addrInfos[0].ID)
+		assert.NotZero(t, leaf.cursors[hubHost.ID()].seq)
+	})
+
+	t.Run("v0", func(t *testing.T) {
+		s, err := leafHost.NewStream(ctx, hubHost.ID(), PeXProtocolV0)
+		require.NoError(t, err)
+		defer s.Close()
+		addrInfos, err := leaf.requestV0(s)
+		require.NoError(t, err)
+		require.Len(t, addrInfos, 1)
+		assert.Equal(t, ids[0], addrInfos[0].ID)
+	})
+}
+
+func Test_PeX_Request_removed(t *testing.T) {
+	ctx := context.Background()
+	ids := makeTestIDs(t, 2)
+	hubHost := makeTestListeningHost(t)
+	leafHost := makeTestListeningHost(t)
+
+	hub := NewPeX(makeTestConfig(ids[0], leafHost.ID()))
+	hub.host = hubHost
+	hubHost.SetStreamHandler(PeXProtocolV2, hub.streamHandler)
+	// known addresses, but no signed record yet
+	hub.table.update(ids[0], testAddrs(0), nil)
+	hub.table.update(ids[1], testAddrs(1), nil)
+	hub.table.retain(hub.isVPNPeer)
+
+	leaf := NewPeX(makeTestConfig(hubHost.ID(), ids[0], ids[1]))
+	leaf.host = leafHost
+	leaf.known[ids[0]] = testAddrs(0)
+	leaf.known[ids[1]] = testAddrs(1)
+	require.NoError(t, leafHost.Connect(ctx, peer.AddrInfo{ID: hubHost.ID(), Addrs: hubHost.Addrs()}))
+
+	addrInfos, err := leaf.Request(ctx, hubHost.ID())
+	require.NoError(t, err)
+	require.Len(t, addrInfos, 1, "only the peer removed from the config")
+	assert.Equal(t, ids[1], addrInfos[0].ID)
+	assert.Empty(t, leaf.known[ids[1]])
+	assert.Equal(t, testAddrs(0), leaf.known[ids[0]])
+}
+
+// A responder with more changed records than fit in one response sends them
+// over several.
+func Test_PeX_Request_pages(t *testing.T) {
+	ctx := context.Background()
+	key, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	member, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	hubHost := makeTestListeningHost(t)
+	leafHost := makeTestListeningHost(t)
+
+	hub := NewPeX(makeTestConfig(member, leafHost.ID()))
+	hub.host = hubHost
+	hubHost.SetStreamHandler(PeXProtocolV2, hub.streamHandler)
+	// records the leaf rejects still count towards a full response
+	for i := range pexMaxRecords {
+		hub.table.update(peer.ID(fmt.Sprintf("peer%d", i)), testAddrs(i), []byte("garbage"))
+	}
+	hub.table.update(member, testAddrs(0), sealTestRecord(t, key, member, testAddrs(0)))
+
+	leaf := NewPeX(makeTestConfig(hubHost.ID(), member))
+	leaf.host = leafHost
+	require.NoError(t, leafHost.Connect(ctx, peer.AddrInfo{ID: hubHost.ID(), Addrs: hubHost.Addrs()}))
+
+	addrInfos, err := leaf.Request(ctx, hubHost.ID())
+	require.NoError(t, err)
+	require.Len(t, addrInfos, 1)
+	assert.Equal(t, member, addrInfos[0].ID)
+	assert.Equal(t, pexCursor{hub.table.epoch, hub.table.seq}, leaf.cursors[hubHost.ID()])
+}
+
+func Benchmark_pexTable_since(b *testing.B) {
+	ids := makeTestIDs(b, 5000)
+	table := newPeXTable()
+	for i, id := range ids {
+		table.update(id, testAddrs(i), nil)
+	}
+	_, seq := table.since(0, 0)
+	for i := range 10 {
+		table.update(ids[i*100], testAddrs(i+1000), nil)
+	}
+	for b.Loop() {
+		records, _ := table.since(table.epoch, seq)
+		if len(records) != 10 {
+			b.Fatalf("expected 10 records, got %d", len(records))
+		}
+	}
+}
+
+func Benchmark_pexTable_update(b *testing.B) {
+	ids := makeTestIDs(b, 5000)
+	table := newPeXTable()
+	for i, id := range ids {
+		table.update(id, testAddrs(i), nil)
+	}
+	i := 0
+	for b.Loop() {
+		table.update(ids[i%len(ids)], testAddrs(i), nil)
+		i++
+	}
+}
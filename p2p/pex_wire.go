@@ -0,0 +1,71 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// PeX v2 messages are length-prefixed with an unsigned varint. The first byte
+// of each message is its type.
+//
+//	request: epoch (8 bytes) | seq (8 bytes)
+//	record:  signed peer record envelope
+//	removed: peer ID
+//	end:     epoch (8 bytes) | seq (8 bytes)
+const (
+	pexMsgRequest byte = 0x01
+	pexMsgRecord  byte = 0x02
+	pexMsgRemoved byte = 0x03
+	pexMsgEnd     byte = 0x04
+)
+
+// Upper bound for a single PeX v2 message, including the type byte.
+const pexMaxMessageSize = 16 << 10
+
+var errPeXTooLarge = errors.New("PeX message too large")
+
+func writePeXMsg(w io.Writer, kind byte, data []byte) error {
+	if len(data)+1 > pexMaxMessageSize {
+		return errPeXTooLarge
+	}
+	buf := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+1+len(data)), uint64(len(data)+1))
+	buf = append(buf, kind)
+	buf = append(buf, data...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readPeXMsg(r *bufio.Reader) (kind byte, data []byte, err error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size == 0 {
+		return 0, nil, errPeXMalformed
+	}
+	if size > pexMaxMessageSize {
+		return 0, nil, errPeXTooLarge
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	return buf[0], buf[1:], nil
+}
+
+func encodePeXCursor(c pexCursor) []byte {
+	buf := binary.BigEndian.AppendUint64(make([]byte, 0, 16), c.epoch)
+	return binary.BigEndian.AppendUint64(buf, c.seq)
+}
+
+func decodePeXCursor(data []byte) (pexCursor, error) {
+	if len(data) != 16 {
+		return pexCursor{}, errPeXMalformed
+	}
+	return pexCursor{
+		epoch: binary.BigEndian.Uint64(data[:8]),
+		seq:   binary.BigEndian.Uint64(data[8:]),
+	}, nil
+}
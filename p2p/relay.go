@@ -1,29 +1,144 @@
 package p2p
 
 import (
+	"context"
+	"math"
+	"slices"
+	"time"
+
 	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var relayDenied = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "hyprspace",
+	Subsystem: "relay",
+	Name:      "denied_total",
+	Help:      "Relay requests denied by the VPN peer filter.",
+}, []string{"request"})
+
+// ClosedCircuitRelayFilter only relays for VPN peers that are allowed to
+// relay through us.
 type ClosedCircuitRelayFilter struct {
-	allowedPeers []config.Peer
+	config *config.Config
+}
+
+func (ccr ClosedCircuitRelayFilter) mayRelay(p peer.ID) bool {
+	cfgPeer, found := ccr.config.PeerByID(p)
+	return found && cfgPeer.AllowRelay
 }
 
 func (ccr ClosedCircuitRelayFilter) AllowReserve(p peer.ID, a multiaddr.Multiaddr) bool {
-	_, found := config.FindPeer(ccr.allowedPeers, p)
-	return found
+	if !ccr.mayRelay(p) {
+		relayDenied.WithLabelValues("reserve").Inc()
+		return false
+	}
+	return true
 }
 
 func (ccr ClosedCircuitRelayFilter) AllowConnect(src peer.ID, srcAddr multiaddr.Multiaddr, dest peer.ID) bool {
-	_, foundSrc := config.FindPeer(ccr.allowedPeers, src)
-	_, foundDst := config.FindPeer(ccr.allowedPeers, dest)
-	return foundSrc || foundDst
+	var allowed bool
+	if ccr.config.RelayService.Strict {
+		allowed = ccr.mayRelay(src) && ccr.mayRelay(dest)
+	} else {
+		allowed = ccr.mayRelay(src) || ccr.mayRelay(dest)
+	}
+	if !allowed {
+		relayDenied.WithLabelValues("connect").Inc()
+	}
+	return allowed
 }
 
-func NewClosedCircuitRelayFilter(allowedPeers []config.Peer) relay.ACLFilter {
+func NewClosedCircuitRelayFilter(cfg *config.Config) relay.ACLFilter {
 	return ClosedCircuitRelayFilter{
-		allowedPeers: allowedPeers,
+		config: cfg,
+	}
+}
+
+// relayResources returns the relay service limits from the config.
+func relayResources(cfg *config.Config) relay.Resources {
+	rc := relay.DefaultResources()
+	rc.MaxReservations = cfg.RelayService.MaxReservations
+	rc.MaxCircuits = cfg.RelayService.MaxCircuits
+	rc.Limit = nil
+	if cfg.RelayService.ConnectionDuration > 0 || cfg.RelayService.ConnectionData > 0 {
+		// The relay applies both limits at once, so a zero limit has to be
+		// made practically unlimited.
+		rc.Limit = &relay.RelayLimit{
+			Duration: cfg.RelayService.ConnectionDuration,
+			Data:     cfg.RelayService.ConnectionData,
+		}
+		if rc.Limit.Duration == 0 {
+			rc.Limit.Duration = math.MaxUint32 * time.Second
+		}
+		if rc.Limit.Data == 0 {
+			rc.Limit.Data = math.MaxInt64
+		}
+	}
+	return rc
+}
+
+// relayPeerSource offers the static relays to AutoRelay first, followed by
+// candidates received on peerChan.
+func relayPeerSource(static []peer.AddrInfo, peerChan <-chan peer.AddrInfo) autorelay.PeerSource {
+	return func(ctx context.Context, numPeers int) <-chan peer.AddrInfo {
+		r := make(chan peer.AddrInfo)
+		go func() {
+			defer close(r)
+			for _, v := range static {
+				if numPeers == 0 {
+					return
+				}
+				select {
+				case r <- v:
+					numPeers--
+				case <-ctx.Done():
+					return
+				}
+			}
+			for ; numPeers != 0; numPeers-- {
+				select {
+				case v, ok := <-peerChan:
+					if !ok {
+						return
+					}
+					select {
+					case r <- v:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return r
+	}
+}
+
+// ActiveRelays returns the relays we currently hold a reservation with, as
+// seen in our advertised circuit addresses.
+func ActiveRelays(h host.Host) []peer.ID {
+	var relays []peer.ID
+	for _, addr := range h.Addrs() {
+		if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err != nil {
+			continue
+		}
+		ra, err := addr.ValueForProtocol(multiaddr.P_P2P)
+		if err != nil {
+			continue
+		}
+		relayID, err := peer.Decode(ra)
+		if err != nil || slices.Contains(relays, relayID) {
+			continue
+		}
+		relays = append(relays, relayID)
 	}
+	return relays
 }
@@ -0,0 +1,77 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ClosedCircuitRelayFilter(t *testing.T) {
+	ids := makeTestIDs(t, 3)
+	member, denied, outsider := ids[0], ids[1], ids[2]
+	cfg := makeTestConfig()
+	for _, p := range []config.Peer{{ID: member, AllowRelay: true}, {ID: denied}} {
+		cfg.Peers = append(cfg.Peers, p)
+		cfg.PeerLookup.ByID[p.ID] = p
+	}
+	filter := NewClosedCircuitRelayFilter(cfg)
+
+	assert.True(t, filter.AllowReserve(member, nil))
+	assert.False(t, filter.AllowReserve(denied, nil), "peer without allowRelay")
+	assert.False(t, filter.AllowReserve(outsider, nil), "non-member")
+
+	cases := []struct {
+		name     string
+		src, dst peer.ID
+		loose    bool
+		strict   bool
+	}{
+		{"member to member", member, member, true, true},
+		{"outsider to member", outsider, member, true, false},
+		{"member to outsider", member, outsider, true, false},
+		{"denied to member", denied, member, true, false},
+		{"outsider to denied", outsider, denied, false, false},
+		{"outsider to outsider", outsider, outsider, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg.RelayService.Strict = false
+			assert.Equal(t, c.loose, filter.AllowConnect(c.src, nil, c.dst))
+			cfg.RelayService.Strict = true
+			assert.Equal(t, c.strict, filter.AllowConnect(c.src, nil, c.dst))
+		})
+	}
+}
+
+func Test_relayResources(t *testing.T) {
+	cfg := makeTestConfig()
+	cfg.RelayService = config.RelayService{MaxReservations: 4, MaxCircuits: 2}
+
+	rc := relayResources(cfg)
+	assert.Equal(t, 4, rc.MaxReservations)
+	assert.Equal(t, 2, rc.MaxCircuits)
+	assert.Nil(t, rc.Limit, "no limits configured")
+
+	cfg.RelayService = config.RelayService{}
+	rc = relayResources(cfg)
+	assert.Zero(t, rc.MaxReservations, "zero refuses reservations")
+	assert.Zero(t, rc.MaxCircuits, "zero refuses circuits")
+	cfg.RelayService = config.RelayService{MaxReservations: 4, MaxCircuits: 2}
+
+	cfg.RelayService.ConnectionDuration = time.Minute
+	rc = relayResources(cfg)
+	require.NotNil(t, rc.Limit)
+	assert.Equal(t, time.Minute, rc.Limit.Duration)
+	assert.Greater(t, rc.Limit.Data, int64(1<<40))
+
+	cfg.RelayService.ConnectionDuration = 0
+	cfg.RelayService.ConnectionData = 1 << 20
+	rc = relayResources(cfg)
+	require.NotNil(t, rc.Limit)
+	assert.Greater(t, rc.Limit.Duration, 24*time.Hour)
+	assert.Equal(t, int64(1<<20), rc.Limit.Data)
+}
@@ -0,0 +1,441 @@
+package p2p
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/libp2p/go-libp2p/core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// RendezvousProtocol is the libp2p rendezvous protocol.
+const RendezvousProtocol = "/rendezvous/1.0.0"
+
+// Registration TTLs accepted by the server, in seconds.
+const (
+	rvDefaultTTL = 2 * 60 * 60
+	rvMinTTL     = 2 * 60
+	rvMaxTTL     = 72 * 60 * 60
+)
+
+// Upper bounds enforced by the server. A single peer may only hold a few
+// registrations, so it can't use up the whole server on its own.
+const (
+	rvMaxNamespace         = 255
+	rvMaxDiscover          = 1000
+	rvMaxRegistrations     = 10000
+	rvMaxPeerRegistrations = 16
+)
+
+// How often the client registers with and asks each rendezvous point.
+const (
+	rvRegisterInterval = rvDefaultTTL * time.Second / 2
+	rvDiscoverInterval = 5 * time.Minute
+)
+
+// How long to wait for our addresses to settle before registering them.
+const rvRegisterDelay = 10 * time.Second
+
+// How long a single exchange with a rendezvous point may take.
+const rvTimeout = 30 * time.Second
+
+type rvEntry struct {
+	record []byte
+	expiry time.Time
+	seq    uint64
+}
+
+// RendezvousServer is a rendezvous point. Registrations are only kept in
+// memory.
+type RendezvousServer struct {
+	lock    sync.Mutex
+	epoch   uint64
+	seq     uint64
+	count   int
+	perPeer map[peer.ID]int
+	entries map[string]map[peer.ID]rvEntry
+}
+
+func NewRendezvousServer() *RendezvousServer {
+	return &RendezvousServer{
+		epoch:   rand.Uint64(),
+		perPeer: make(map[peer.ID]int),
+		entries: make(map[string]map[peer.ID]rvEntry),
+	}
+}
+
+// drop removes the registration of p in ns.
+func (rs *RendezvousServer) drop(ns string, p peer.ID) {
+	delete(rs.entries[ns], p)
+	rs.count--
+	if rs.perPeer[p]--; rs.perPeer[p] <= 0 {
+		delete(rs.perPeer, p)
+	}
+}
+
+// expire drops the expired registrations in ns.
+func (rs *RendezvousServer) expire(ns string, now time.Time) {
+	for p, e := range rs.entries[ns] {
+		if now.After(e.expiry) {
+			rs.drop(ns, p)
+		}
+	}
+	if len(rs.entries[ns]) == 0 {
+		delete(rs.entries, ns)
+	}
+}
+
+func (rs *RendezvousServer) register(from peer.ID, r rvRegistration, now time.Time) (uint64, uint64, string) {
+	if r.ns == "" || len(r.ns) > rvMaxNamespace {
+		return rvStatusInvalidNamespace, 0, "invalid namespace"
+	}
+	ttl := r.ttl
+	if ttl == 0 {
+		ttl = rvDefaultTTL
+	}
+	if ttl < rvMinTTL || ttl > rvMaxTTL {
+		return rvStatusInvalidTTL, 0, "invalid TTL"
+	}
+	_, rec, err := record.ConsumeEnvelope(r.record, peer.PeerRecordEnvelopeDomain)
+	if err != nil {
+		return rvStatusInvalidPeerRecord, 0, err.Error()
+	}
+	if pr, ok := rec.(*peer.PeerRecord); !ok || pr.PeerID != from {
+		return rvStatusInvalidPeerRecord, 0, "peer record not signed by the registering peer"
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.expire(r.ns, now)
+	if _, ok := rs.entries[r.ns][from]; !ok {
+		if rs.count >= rvMaxRegistrations || rs.perPeer[from] >= rvMaxPeerRegistrations {
+			for ns := range rs.entries {
+				rs.expire(ns, now)
+			}
+		}
+		if rs.perPeer[from] >= rvMaxPeerRegistrations {
+			return rvStatusUnavailable, 0, "too many registrations of this peer"
+		}
+		if rs.count >= rvMaxRegistrations {
+			return rvStatusUnavailable, 0, "too many registrations"
+		}
+		rs.count++
+		rs.perPeer[from]++
+	}
+	if rs.entries[r.ns] == nil {
+		rs.entries[r.ns] = make(map[peer.ID]rvEntry)
+	}
+	rs.seq++
+	rs.entries[r.ns][from] = rvEntry{
+		record: r.record,
+		expiry: now.Add(time.Duration(ttl) * time.Second),
+		seq:    rs.seq,
+	}
+	return rvStatusOK, ttl, ""
+}
+
+func (rs *RendezvousServer) unregister(from peer.ID, ns string) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if _, ok := rs.entries[ns][from]; ok {
+		rs.drop(ns, from)
+		if len(rs.entries[ns]) == 0 {
+			delete(rs.entries, ns)
+		}
+	}
+}
+
+// discover returns the registrations in ns that changed since the cookie.
+// The cookie is the epoch of the server and the last sequence number the
+// client has seen. Cookies from a previous run of the server start over.
+func (rs *RendezvousServer) discover(ns string, limit uint64, cookie []byte, now time.Time) ([]rvRegistration, []byte, uint64) {
+	if ns == "" || len(ns) > rvMaxNamespace {
+		return nil, nil, rvStatusInvalidNamespace
+	}
+	var since uint64
+	if len(cookie) > 0 {
+		if len(cookie) != 16 {
+			return nil, nil, rvStatusInvalidCookie
+		}
+		if binary.BigEndian.Uint64(cookie[:8]) == rs.epoch {
+			since = binary.BigEndian.Uint64(cookie[8:])
+		}
+	}
+	if limit == 0 || limit > rvMaxDiscover {
+		limit = rvMaxDiscover
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.expire(ns, now)
+	var entries []rvEntry
+	for _, e := range rs.entries[ns] {
+		if e.seq > since {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b rvEntry) int { return cmp.Compare(a.seq, b.seq) })
+	if uint64(len(entries)) > limit {
+		entries = entries[:limit]
+	}
+	regs := make([]rvRegistration, 0, len(entries))
+	last := since
+	for _, e := range entries {
+		regs = append(regs, rvRegistration{
+			ns:     ns,
+			record: e.record,
+			ttl:    uint64(e.expiry.Sub(now) / time.Second),
+		})
+		last = e.seq
+	}
+	cookie = binary.BigEndian.AppendUint64(make([]byte, 0, 16), rs.epoch)
+	cookie = binary.BigEndian.AppendUint64(cookie, last)
+	return regs, cookie, rvStatusOK
+}
+
+// StreamHandler serves rendezvous requests until the stream is closed.
+func (rs *RendezvousServer) StreamHandler(stream network.Stream) {
+	defer stream.Close()
+	from := stream.Conn().RemotePeer()
+	r := bufio.NewReader(stream)
+	for {
+		stream.SetDeadline(time.Now().Add(rvTimeout))
+		msg, err := readRvMessage(r)
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		var resp *rvMessage
+		switch msg.kind {
+		case rvMsgRegister:
+			status, ttl, text := rs.register(from, msg.register, now)
+			resp = &rvMessage{kind: rvMsgRegisterResponse, status: status, statusText: text, ttl: ttl}
+		case rvMsgUnregister:
+			rs.unregister(from, msg.ns)
+			continue
+		case rvMsgDiscover:
+			regs, cookie, status := rs.discover(msg.ns, msg.limit, msg.cookie, now)
+			resp = &rvMessage{kind: rvMsgDiscoverResponse, registrations: regs, cookie: cookie, status: status}
+		default:
+			stream.Reset()
+			return
+		}
+		if err := writeRvMessage(stream, resp); err != nil {
+			stream.Reset()
+			return
+		}
+	}
+}
+
+// Rendezvous registers this node with the configured rendezvous points and
+// looks up the addresses of VPN peers there.
+type Rendezvous struct {
+	host    host.Host
+	config  *config.Config
+	pex     *PeX
+	lock    sync.Mutex
+	cookies map[peer.ID][]byte
+	known   map[peer.ID][]ma.Multiaddr
+}
+
+func NewRendezvous(cfg *config.Config, pex *PeX) *Rendezvous {
+	return &Rendezvous{
+		config:  cfg,
+		pex:     pex,
+		cookies: make(map[peer.ID][]byte),
+		known:   make(map[peer.ID][]ma.Multiaddr),
+	}
+}
+
+type RendezvousRouting struct {
+	rv *Rendezvous
+}
+
+// exchange sends a request to a rendezvous point and reads its response.
+func (rv *Rendezvous) exchange(ctx context.Context, point peer.AddrInfo, req *rvMessage) (*rvMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, rvTimeout)
+	defer cancel()
+	rv.host.Peerstore().AddAddrs(point.ID, point.Addrs, time.Hour)
+	stream, err := rv.host.NewStream(ctx, point.ID, RendezvousProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(rvTimeout))
+	if err := writeRvMessage(stream, req); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	resp, err := readRvMessage(bufio.NewReader(stream))
+	if err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	if resp.kind != req.kind+1 {
+		return nil, errRvMalformed
+	}
+	if resp.status != rvStatusOK {
+		return nil, fmt.Errorf("rendezvous error %d: %s", resp.status, resp.statusText)
+	}
+	return resp, nil
+}
+
+// register announces our current addresses at a rendezvous point.
+func (rv *Rendezvous) register(ctx context.Context, point peer.AddrInfo) error {
+	addrs := rv.host.Addrs()
+	if len(addrs) == 0 {
+		return errors.New("no addresses to register")
+	}
+	env, err := record.Seal(peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: rv.host.ID(), Addrs: addrs}), rv.config.PrivateKey)
+	if err != nil {
+		return err
+	}
+	data, err := env.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = rv.exchange(ctx, point, &rvMessage{
+		kind: rvMsgRegister,
+		register: rvRegistration{
+			ns:     rv.config.Rendezvous.Namespace,
+			record: data,
+			ttl:    rvDefaultTTL,
+		},
+	})
+	return err
+}
+
+// discover asks a rendezvous point for registrations that changed since we
+// last asked, and adds the addresses of VPN peers to the peerstore.
+func (rv *Rendezvous) discover(ctx context.Context, point peer.AddrInfo) error {
+	for {
+		rv.lock.Lock()
+		cookie := rv.cookies[point.ID]
+		rv.lock.Unlock()
+		resp, err := rv.exchange(ctx, point, &rvMessage{
+			kind:   rvMsgDiscover,
+			ns:     rv.config.Rendezvous.Namespace,
+			limit:  rvMaxDiscover,
+			cookie: cookie,
+		})
+		if err != nil {
+			return err
+		}
+		rv.lock.Lock()
+		rv.cookies[point.ID] = resp.cookie
+		rv.lock.Unlock()
+		for _, r := range resp.registrations {
+			// Registrations of peers outside of the network are ignored.
+			ai, err := rv.pex.consumeRecord(r.record)
+			if err != nil {
+				continue
+			}
+			rv.lock.Lock()
+			rv.known[ai.ID] = ai.Addrs
+			rv.lock.Unlock()
+		}
+		if len(resp.registrations) < rvMaxDiscover {
+			return nil
+		}
+	}
+}
+
+// discoverAll asks all rendezvous points at once.
+func (rv *Rendezvous) discoverAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, point := range rv.config.Rendezvous.Points {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rv.discover(ctx, point); err != nil {
+				logger.With(zap.String("point", point.ID.String()), zap.Error(err)).Debug("Rendezvous discovery failed")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (rv *Rendezvous) registerAll(ctx context.Context) {
+	for _, point := range rv.config.Rendezvous.Points {
+		go func() {
+			if err := rv.register(ctx, point); err != nil {
+				logger.With(zap.String("point", point.ID.String()), zap.Error(err)).Debug("Rendezvous registration failed")
+			}
+		}()
+	}
+}
+
+func (rv *Rendezvous) Service(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	defer wg.Done()
+	if len(rv.config.Rendezvous.Points) == 0 {
+		return
+	}
+	sub, err := rv.host.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
+	if err != nil {
+		logger.With(err).Fatal("Failed to subscribe to EventBus")
+	}
+	defer sub.Close()
+	logger.With(zap.String("namespace", rv.config.Rendezvous.Namespace)).Info("Rendezvous service ready")
+
+	// Our addresses are only known after listening and asking around, give
+	// them some time to settle before registering. Further address changes
+	// while a registration is pending don't push it back.
+	registerTimer := time.NewTimer(rvRegisterDelay)
+	pending := true
+	defer registerTimer.Stop()
+	discoverTicker := time.NewTicker(rvDiscoverInterval)
+	defer discoverTicker.Stop()
+	go rv.discoverAll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Out():
+			if !pending {
+				registerTimer.Reset(rvRegisterDelay)
+				pending = true
+			}
+		case <-registerTimer.C:
+			rv.registerAll(ctx)
+			registerTimer.Reset(jitter(rvRegisterInterval))
+			pending = false
+		case <-discoverTicker.C:
+			go rv.discoverAll(ctx)
+		}
+	}
+}
+
+func (rvr RendezvousRouting) FindPeer(ctx context.Context, targetPeer peer.ID) (peer.AddrInfo, error) {
+	rv := rvr.rv
+	addrInfo := peer.AddrInfo{
+		ID: targetPeer,
+	}
+	// Rendezvous routing only returns VPN node addresses
+	if _, found := rv.config.PeerByID(targetPeer); !found {
+		return addrInfo, routing.ErrNotFound
+	}
+	rv.discoverAll(ctx)
+	rv.lock.Lock()
+	defer rv.lock.Unlock()
+	addrInfo.Addrs = append(addrInfo.Addrs, rv.known[targetPeer]...)
+	if len(addrInfo.Addrs) == 0 {
+		return addrInfo, routing.ErrNotFound
+	}
+	return addrInfo, nil
+}
@@ -0,0 +1,186 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rvMessage(t *testing.T) {
+	msgs := []*rvMessage{
+		{kind: rvMsgRegister, register: rvRegistration{ns: "ns", record: []byte("record"), ttl: 7200}},
+		{kind: rvMsgRegisterResponse, status: rvStatusInvalidTTL, statusText: "invalid TTL"},
+		{kind: rvMsgUnregister, ns: "ns"},
+		{kind: rvMsgDiscover, ns: "ns", limit: 10, cookie: []byte("cookie")},
+		{kind: rvMsgDiscoverResponse, cookie: []byte("cookie"), registrations: []rvRegistration{
+			{ns: "ns", record: []byte("a"), ttl: 1},
+			{ns: "ns", record: []byte("b"), ttl: 2},
+		}},
+	}
+	for _, m := range msgs {
+		decoded, err := unmarshalRvMessage(marshalRvMessage(m))
+		require.NoError(t, err)
+		assert.Equal(t, m, decoded)
+	}
+
+	_, err := unmarshalRvMessage([]byte{0xff})
+	assert.ErrorIs(t, err, errRvMalformed)
+}
+
+func sealTestHostRecord(t *testing.T, key crypto.PrivKey) (peer.ID, []byte) {
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	return id, sealTestRecord(t, key, id, testAddrs(1))
+}
+
+func Test_RendezvousServer(t *testing.T) {
+	keys := make([]crypto.PrivKey, 3)
+	for i := range keys {
+		var err error
+		keys[i], _, err = crypto.GenerateKeyPair(crypto.Ed25519, 256)
+		require.NoError(t, err)
+	}
+	now := time.Now()
+
+	t.Run("register and discover", func(t *testing.T) {
+		rs := NewRendezvousServer()
+		for _, key := range keys {
+			id, rec := sealTestHostRecord(t, key)
+			status, ttl, _ := rs.register(id, rvRegistration{ns: "ns", record: rec}, now)
+			require.EqualValues(t, rvStatusOK, status)
+			assert.EqualValues(t, rvDefaultTTL, ttl)
+		}
+		regs, cookie, status := rs.discover("ns", 2, nil, now)
+		require.EqualValues(t, rvStatusOK, status)
+		assert.Len(t, regs, 2)
+		regs, cookie, _ = rs.discover("ns", 2, cookie, now)
+		assert.Len(t, regs, 1)
+		regs, _, _ = rs.discover("ns", 2, cookie, now)
+		assert.Empty(t, regs)
+
+		regs, _, _ = rs.discover("other", 0, nil, now)
+		assert.Empty(t, regs)
+	})
+
+	t.Run("changes only", func(t *testing.T) {
+		rs := NewRendezvousServer()
+		for _, key := range keys {
+			id, rec := sealTestHostRecord(t, key)
+			rs.register(id, rvRegistration{ns: "ns", record: rec}, now)
+		}
+		_, cookie, _ := rs.discover("ns", 0, nil, now)
+
+		id, rec := sealTestHostRecord(t, keys[1])
+		rs.register(id, rvRegistration{ns: "ns", record: rec}, now)
+		regs, _, _ := rs.discover("ns", 0, cookie, now)
+		require.Len(t, regs, 1)
+		assert.Equal(t, rec, regs[0].record)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		rs := NewRendezvousServer()
+		id, rec := sealTestHostRecord(t, keys[0])
+		rs.register(id, rvRegistration{ns: "ns", record: rec, ttl: rvMinTTL}, now)
+		regs, _, _ := rs.discover("ns", 0, nil, now.Add(rvMinTTL*time.Second+time.Second))
+		assert.Empty(t, regs)
+		assert.Zero(t, rs.count)
+	})
+
+	t.Run("unregister", func(t *testing.T) {
+		rs := NewRendezvousServer()
+		id, rec := sealTestHostRecord(t, keys[0])
+		rs.register(id, rvRegistration{ns: "ns", record: rec}, now)
+		rs.unregister(id, "ns")
+		regs, _, _ := rs.discover("ns", 0, nil, now)
+		assert.Empty(t, regs)
+	})
+
+	t.Run("per peer limit", func(t *testing.T) {
+		rs := NewRendezvousServer()
+		id, rec := sealTestHostRecord(t, keys[0])
+		for i := range rvMaxPeerRegistrations {
+			status, _, _ := rs.register(id, rvRegistration{ns: fmt.Sprint("ns", i), record: rec}, now)
+			require.EqualValues(t, rvStatusOK, status)
+		}
+		status, _, _ := rs.register(id, rvRegistration{ns: "full", record: rec}, now)
+		assert.EqualValues(t, rvStatusUnavailable, status)
+		status, _, _ = rs.register(id, rvRegistration{ns: "ns0", record: rec}, now)
+		assert.EqualValues(t, rvStatusOK, status, "renewing is still allowed")
+
+		other, otherRec := sealTestHostRecord(t, keys[1])
+		status, _, _ = rs.register(other, rvRegistration{ns: "full", record: otherRec}, now)
+		assert.EqualValues(t, rvStatusOK, status, "other peers are not affected")
+
+		rs.unregister(id, "ns0")
+		status, _, _ = rs.register(id, rvRegistration{ns: "full", record: rec}, now)
+		assert.EqualValues(t, rvStatusOK, status)
+	})
+
+	t.Run("record of someone else", func(t *testing.T) {
+		rs := NewRendezvousServer()
+		id, _ := sealTestHostRecord(t, keys[0])
+		_, rec := sealTestHostRecord(t, keys[1])
+		status, _, _ := rs.register(id, rvRegistration{ns: "ns", record: rec}, now)
+		assert.EqualValues(t, rvStatusInvalidPeerRecord, status)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		rs := NewRendezvousServer()
+		id, rec := sealTestHostRecord(t, keys[0])
+		status, _, _ := rs.register(id, rvRegistration{ns: "", record: rec}, now)
+		assert.EqualValues(t, rvStatusInvalidNamespace, status)
+		status, _, _ = rs.register(id, rvRegistration{ns: "ns", record: rec, ttl: rvMaxTTL + 1}, now)
+		assert.EqualValues(t, rvStatusInvalidTTL, status)
+		_, _, status = rs.discover("ns", 0, []byte("short"), now)
+		assert.EqualValues(t, rvStatusInvalidCookie, status)
+	})
+}
+
+func makeTestRendezvousClient(t *testing.T, point host.Host, members ...peer.ID) (*Rendezvous, host.Host) {
+	key, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 256)
+	require.NoError(t, err)
+	h, err := libp2p.New(libp2p.Identity(key), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+
+	cfg := makeTestConfig(members...)
+	cfg.PrivateKey = key
+	cfg.Rendezvous = config.Rendezvous{
+		Points:    []peer.AddrInfo{{ID: point.ID(), Addrs: point.Addrs()}},
+		Namespace: "test",
+	}
+	pex := NewPeX(cfg)
+	pex.host = h
+	rv := NewRendezvous(cfg, pex)
+	rv.host = h
+	return rv, h
+}
+
+func Test_Rendezvous(t *testing.T) {
+	ctx := context.Background()
+	point, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { point.Close() })
+	point.SetStreamHandler(RendezvousProtocol, NewRendezvousServer().StreamHandler)
+
+	outsider, _ := makeTestRendezvousClient(t, point)
+	member, memberHost := makeTestRendezvousClient(t, point)
+	client, _ := makeTestRendezvousClient(t, point, memberHost.ID())
+
+	require.NoError(t, outsider.register(ctx, outsider.config.Rendezvous.Points[0]))
+	require.NoError(t, member.register(ctx, member.config.Rendezvous.Points[0]))
+
+	ai, err := RendezvousRouting{client}.FindPeer(ctx, memberHost.ID())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, memberHost.Addrs(), ai.Addrs)
+	assert.Len(t, client.known, 1, "registrations of non-members should be ignored")
+}
@@ -0,0 +1,299 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Rendezvous messages follow the libp2p rendezvous spec. They are protobuf
+// encoded and length-prefixed with an unsigned varint.
+//
+//	message Message {
+//	  MessageType type = 1;
+//	  Register register = 2;
+//	  RegisterResponse registerResponse = 3;
+//	  Unregister unregister = 4;
+//	  Discover discover = 5;
+//	  DiscoverResponse discoverResponse = 6;
+//	}
+const (
+	rvMsgRegister         = 0
+	rvMsgRegisterResponse = 1
+	rvMsgUnregister       = 2
+	rvMsgDiscover         = 3
+	rvMsgDiscoverResponse = 4
+)
+
+// Response status codes.
+const (
+	rvStatusOK                = 0
+	rvStatusInvalidNamespace  = 100
+	rvStatusInvalidPeerRecord = 101
+	rvStatusInvalidTTL        = 102
+	rvStatusInvalidCookie     = 103
+	rvStatusNotAuthorized     = 200
+	rvStatusInternalError     = 300
+	rvStatusUnavailable       = 400
+)
+
+// Upper bound for a single rendezvous message.
+const rvMaxMessageSize = 1 << 20
+
+var errRvMalformed = errors.New("malformed rendezvous message")
+var errRvTooLarge = errors.New("rendezvous message too large")
+
+// rvRegistration is a Register message, also used for the registrations in a
+// DiscoverResponse.
+type rvRegistration struct {
+	ns     string
+	record []byte
+	ttl    uint64
+}
+
+// rvMessage is the union of all rendezvous messages. Only the fields of the
+// message type are used.
+type rvMessage struct {
+	kind uint64
+
+	// Register
+	register rvRegistration
+
+	// Unregister, Discover
+	ns string
+
+	// Discover, DiscoverResponse
+	limit  uint64
+	cookie []byte
+
+	// RegisterResponse, DiscoverResponse
+	status     uint64
+	statusText string
+	ttl        uint64
+
+	// DiscoverResponse
+	registrations []rvRegistration
+}
+
+func appendRvRegistration(b []byte, r rvRegistration) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, r.ns)
+	if len(r.record) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.record)
+	}
+	if r.ttl > 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, r.ttl)
+	}
+	return b
+}
+
+func appendRvStatus(b []byte, status uint64, statusNum protowire.Number, text string, textNum protowire.Number) []byte {
+	b = protowire.AppendTag(b, statusNum, protowire.VarintType)
+	b = protowire.AppendVarint(b, status)
+	if text != "" {
+		b = protowire.AppendTag(b, textNum, protowire.BytesType)
+		b = protowire.AppendString(b, text)
+	}
+	return b
+}
+
+func marshalRvMessage(m *rvMessage) []byte {
+	var inner []byte
+	switch m.kind {
+	case rvMsgRegister:
+		inner = appendRvRegistration(nil, m.register)
+	case rvMsgRegisterResponse:
+		inner = appendRvStatus(nil, m.status, 1, m.statusText, 2)
+		if m.ttl > 0 {
+			inner = protowire.AppendTag(inner, 3, protowire.VarintType)
+			inner = protowire.AppendVarint(inner, m.ttl)
+		}
+	case rvMsgUnregister:
+		inner = protowire.AppendTag(nil, 1, protowire.BytesType)
+		inner = protowire.AppendString(inner, m.ns)
+	case rvMsgDiscover:
+		inner = protowire.AppendTag(nil, 1, protowire.BytesType)
+		inner = protowire.AppendString(inner, m.ns)
+		if m.limit > 0 {
+			inner = protowire.AppendTag(inner, 2, protowire.VarintType)
+			inner = protowire.AppendVarint(inner, m.limit)
+		}
+		if len(m.cookie) > 0 {
+			inner = protowire.AppendTag(inner, 3, protowire.BytesType)
+			inner = protowire.AppendBytes(inner, m.cookie)
+		}
+	case rvMsgDiscoverResponse:
+		for _, r := range m.registrations {
+			inner = protowire.AppendTag(inner, 1, protowire.BytesType)
+			inner = protowire.AppendBytes(inner, appendRvRegistration(nil, r))
+		}
+		if len(m.cookie) > 0 {
+			inner = protowire.AppendTag(inner, 2, protowire.BytesType)
+			inner = protowire.AppendBytes(inner, m.cookie)
+		}
+		inner = appendRvStatus(inner, m.status, 3, m.statusText, 4)
+	}
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.kind)
+	b = protowire.AppendTag(b, protowire.Number(m.kind+2), protowire.BytesType)
+	return protowire.AppendBytes(b, inner)
+}
+
+// rvFields calls f for each field in a protobuf message. Varint fields are
+// passed in v, length-delimited fields in b. Other wire types are skipped.
+func rvFields(data []byte, f func(num protowire.Number, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return errRvMalformed
+		}
+		data = data[n:]
+		var v uint64
+		var b []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return errRvMalformed
+		}
+		data = data[n:]
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			if err := f(num, v, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func unmarshalRvRegistration(data []byte) (r rvRegistration, err error) {
+	err = rvFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			r.ns = string(b)
+		case 2:
+			r.record = b
+		case 3:
+			r.ttl = v
+		}
+		return nil
+	})
+	return
+}
+
+func unmarshalRvMessage(data []byte) (*rvMessage, error) {
+	m := &rvMessage{}
+	// The type may come after the message it belongs to, or be left out
+	// for Register.
+	var inners [rvMsgDiscoverResponse + 1][]byte
+	err := rvFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			m.kind = v
+		case 2, 3, 4, 5, 6:
+			inners[num-2] = b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m.kind >= uint64(len(inners)) {
+		return nil, errRvMalformed
+	}
+	inner := inners[m.kind]
+	switch m.kind {
+	case rvMsgRegister:
+		m.register, err = unmarshalRvRegistration(inner)
+	case rvMsgRegisterResponse:
+		err = rvFields(inner, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				m.status = v
+			case 2:
+				m.statusText = string(b)
+			case 3:
+				m.ttl = v
+			}
+			return nil
+		})
+	case rvMsgUnregister:
+		err = rvFields(inner, func(num protowire.Number, v uint64, b []byte) error {
+			if num == 1 {
+				m.ns = string(b)
+			}
+			return nil
+		})
+	case rvMsgDiscover:
+		err = rvFields(inner, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				m.ns = string(b)
+			case 2:
+				m.limit = v
+			case 3:
+				m.cookie = b
+			}
+			return nil
+		})
+	case rvMsgDiscoverResponse:
+		err = rvFields(inner, func(num protowire.Number, v uint64, b []byte) error {
+			switch num {
+			case 1:
+				r, err := unmarshalRvRegistration(b)
+				if err != nil {
+					return err
+				}
+				m.registrations = append(m.registrations, r)
+			case 2:
+				m.cookie = b
+			case 3:
+				m.status = v
+			case 4:
+				m.statusText = string(b)
+			}
+			return nil
+		})
+	default:
+		return nil, errRvMalformed
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeRvMessage(w io.Writer, m *rvMessage) error {
+	data := marshalRvMessage(m)
+	if len(data) > rvMaxMessageSize {
+		return errRvTooLarge
+	}
+	buf := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(data)), uint64(len(data)))
+	_, err := w.Write(append(buf, data...))
+	return err
+}
+
+func readRvMessage(r *bufio.Reader) (*rvMessage, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > rvMaxMessageSize {
+		return nil, errRvTooLarge
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return unmarshalRvMessage(buf)
+}
@@ -0,0 +1,140 @@
+package p2p
+
+import (
+	"slices"
+	"syscall"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/hyprspace/hyprspace/svc"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+// ResourceScope is the usage and limits of one resource manager scope.
+type ResourceScope struct {
+	Name  string
+	Stat  network.ScopeStat
+	Limit rcmgr.BaseLimit
+}
+
+// ResourceUsage is what the resource and connection managers track.
+type ResourceUsage struct {
+	Scopes    []ResourceScope
+	Conns     int
+	LowWater  int
+	HighWater int
+}
+
+// limitedProtocols returns the hyprspace protocols with their stream limits.
+func limitedProtocols(cfg *config.Config) map[protocol.ID]int {
+	limits := map[protocol.ID]int{svc.Protocol: cfg.Resources.Streams.Service}
+	for _, p := range Protocols {
+		limits[p] = cfg.Resources.Streams.Hyprspace
+	}
+	for _, p := range PeXProtocols {
+		limits[p] = cfg.Resources.Streams.PeX
+	}
+	return limits
+}
+
+// resourceLimits scales the libp2p default limits to the configured memory
+// and file descriptors, and applies the stream limits.
+func resourceLimits(cfg *config.Config) rcmgr.ConcreteLimitConfig {
+	limits := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&limits)
+	for p, streams := range limitedProtocols(cfg) {
+		if streams == 0 {
+			continue
+		}
+		base := limits.ProtocolBaseLimit
+		base.Streams, base.StreamsInbound, base.StreamsOutbound = streams, streams, streams
+		inc := limits.ProtocolLimitIncrease
+		inc.Streams, inc.StreamsInbound, inc.StreamsOutbound = 0, 0, 0
+		limits.AddProtocolLimit(p, base, inc)
+	}
+
+	if cfg.Resources.MaxMemory == 0 && cfg.Resources.MaxFileDescriptors == 0 {
+		return limits.AutoScale()
+	}
+	mem := cfg.Resources.MaxMemory
+	if mem == 0 {
+		var info syscall.Sysinfo_t
+		if err := syscall.Sysinfo(&info); err == nil {
+			mem = int64(info.Totalram) * int64(info.Unit) / 8
+		}
+	}
+	fds := cfg.Resources.MaxFileDescriptors
+	if fds == 0 {
+		var rlimit syscall.Rlimit
+		if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err == nil {
+			fds = int(rlimit.Cur) / 2
+		}
+	}
+	scaled := limits.Scale(mem, fds)
+	if cfg.Resources.MaxMemory == 0 {
+		return scaled
+	}
+	// Scaling never goes below the base limits, which are more than a small
+	// configured maximum.
+	limiter := rcmgr.NewFixedLimiter(scaled)
+	partial := scaled.ToPartialLimitConfig()
+	partial.System.Memory = rcmgr.LimitVal64(min(limiter.GetSystemLimits().GetMemoryLimit(), mem))
+	partial.Transient.Memory = rcmgr.LimitVal64(min(limiter.GetTransientLimits().GetMemoryLimit(), mem))
+	return partial.Build(scaled)
+}
+
+func resourceManager(cfg *config.Config) (network.ResourceManager, error) {
+	return rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(resourceLimits(cfg)))
+}
+
+func connManager(cfg *config.Config) (*connmgr.BasicConnMgr, error) {
+	return connmgr.NewConnManager(
+		cfg.ConnManager.LowWater,
+		cfg.ConnManager.HighWater,
+		connmgr.WithGracePeriod(cfg.ConnManager.GracePeriod),
+	)
+}
+
+func baseLimit(l rcmgr.Limit) rcmgr.BaseLimit {
+	return rcmgr.BaseLimit{
+		Streams:         l.GetStreamTotalLimit(),
+		StreamsInbound:  l.GetStreamLimit(network.DirInbound),
+		StreamsOutbound: l.GetStreamLimit(network.DirOutbound),
+		Conns:           l.GetConnTotalLimit(),
+		ConnsInbound:    l.GetConnLimit(network.DirInbound),
+		ConnsOutbound:   l.GetConnLimit(network.DirOutbound),
+		FD:              l.GetFDLimit(),
+		Memory:          l.GetMemoryLimit(),
+	}
+}
+
+// Resources reports the system wide usage and that of the hyprspace protocols.
+func Resources(h host.Host, cfg *config.Config) ResourceUsage {
+	limiter := rcmgr.NewFixedLimiter(resourceLimits(cfg))
+	rm := h.Network().ResourceManager()
+	usage := ResourceUsage{
+		Conns:     len(h.Network().Conns()),
+		LowWater:  cfg.ConnManager.LowWater,
+		HighWater: cfg.ConnManager.HighWater,
+	}
+
+	rm.ViewSystem(func(s network.ResourceScope) error {
+		usage.Scopes = append(usage.Scopes, ResourceScope{"system", s.Stat(), baseLimit(limiter.GetSystemLimits())})
+		return nil
+	})
+	rm.ViewTransient(func(s network.ResourceScope) error {
+		usage.Scopes = append(usage.Scopes, ResourceScope{"transient", s.Stat(), baseLimit(limiter.GetTransientLimits())})
+		return nil
+	})
+	for _, p := range slices.Concat(Protocols, PeXProtocols, []protocol.ID{svc.Protocol}) {
+		rm.ViewProtocol(p, func(s network.ProtocolScope) error {
+			usage.Scopes = append(usage.Scopes, ResourceScope{string(p), s.Stat(), baseLimit(limiter.GetProtocolLimits(p))})
+			return nil
+		})
+	}
+	return usage
+}
@@ -0,0 +1,44 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/hyprspace/hyprspace/svc"
+	"github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_resourceLimits(t *testing.T) {
+	cfg := makeTestConfig()
+	cfg.Resources = config.Resources{
+		MaxMemory:          64 << 20,
+		MaxFileDescriptors: 256,
+		Streams:            config.StreamLimits{PeX: 8},
+	}
+	limiter := rcmgr.NewFixedLimiter(resourceLimits(cfg))
+
+	system := limiter.GetSystemLimits()
+	assert.EqualValues(t, 64<<20, system.GetMemoryLimit(), "clamped to the configured maximum")
+	assert.LessOrEqual(t, limiter.GetTransientLimits().GetMemoryLimit(), int64(64<<20))
+	assert.Equal(t, 256*rcmgr.DefaultLimits.SystemLimitIncrease.FDFraction, float64(system.GetFDLimit()))
+
+	for _, p := range PeXProtocols {
+		assert.Equal(t, 8, limiter.GetProtocolLimits(p).GetStreamLimit(network.DirInbound))
+	}
+	assert.Equal(t, rcmgr.DefaultLimits.ProtocolBaseLimit.StreamsInbound, limiter.GetProtocolLimits(svc.Protocol).GetStreamLimit(network.DirInbound))
+}
+
+func Test_resourceLimits_large(t *testing.T) {
+	cfg := makeTestConfig()
+	cfg.Resources = config.Resources{
+		MaxMemory:          4 << 30,
+		MaxFileDescriptors: 4096,
+	}
+	limiter := rcmgr.NewFixedLimiter(resourceLimits(cfg))
+	scaled := rcmgr.NewFixedLimiter(rcmgr.DefaultLimits.Scale(4<<30, 4096))
+
+	assert.EqualValues(t, 4<<30, limiter.GetSystemLimits().GetMemoryLimit())
+	assert.Equal(t, scaled.GetTransientLimits().GetMemoryLimit(), limiter.GetTransientLimits().GetMemoryLimit(), "scaled limits below the maximum stay")
+}
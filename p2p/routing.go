@@ -2,7 +2,6 @@ package p2p
 
 import (
 	"context"
-	"net"
 	"sync"
 	"time"
 
@@ -13,11 +12,16 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	routedhost "github.com/libp2p/go-libp2p/p2p/host/routed"
 	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
 )
 
+// ParallelRouting queries all routings at once. Addresses remembered in the
+// address book are offered first.
 type ParallelRouting struct {
 	routings []routedhost.Routing
+	book     *AddrBook
 }
 
 func (pr ParallelRouting) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
@@ -26,6 +30,9 @@ func (pr ParallelRouting) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInf
 
 	var info peer.AddrInfo
 	info.ID = p
+	if pr.book != nil {
+		info.Addrs = pr.book.Addrs(p)
+	}
 	subCtx, cancelSubCtx := context.WithTimeout(ctx, 30*time.Second)
 	for _, r := range pr.routings {
 		wg.Add(1)
@@ -45,6 +52,7 @@ func (pr ParallelRouting) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInf
 
 	wg.Wait()
 	cancelSubCtx()
+	info.Addrs = ma.Unique(info.Addrs)
 	return info, nil
 }
 
@@ -64,21 +72,29 @@ func NewRecursionGater(config *config.Config) connmgr.ConnectionGater {
 	}
 }
 
-func (rg RecursionGater) InterceptAddrDial(pid peer.ID, addr ma.Multiaddr) bool {
-	if ip4str, err := addr.ValueForProtocol(ma.P_IP4); err == nil {
-		ip4 := net.ParseIP(ip4str)
-		if rte, ok := rg.config.FindRouteForIP(ip4); ok {
-			if rte.Target.ID == pid {
-				routes, err := netlink.RouteGet(ip4)
-				if err == nil {
-					if len(routes) > 0 && routes[0].LinkIndex == rg.ifindex {
-						return false
-					}
-				}
-			}
-		}
+// recursive reports whether connecting with a would go through the tunnel to
+// pid, or to any peer if pid is empty. DNS addresses are let through, the
+// swarm resolves them and asks again for each of the IP addresses.
+func (rg RecursionGater) recursive(pid peer.ID, a ma.Multiaddr) bool {
+	ip, err := manet.ToIP(a)
+	if err != nil {
+		return false
 	}
-	return true
+	rte, ok := rg.config.FindRouteForIP(ip)
+	if !ok || (pid != "" && rte.Target.ID != pid) {
+		return false
+	}
+	routes, err := netlink.RouteGet(ip)
+	if err == nil && len(routes) > 0 && routes[0].LinkIndex == rg.ifindex {
+		logger.With(zap.String("addr", a.String()), zap.String("via", rte.Target.ID.String())).Debug("Prevented tunnel recursion")
+		gaterRejected.WithLabelValues("recursion").Inc()
+		return true
+	}
+	return false
+}
+
+func (rg RecursionGater) InterceptAddrDial(pid peer.ID, addr ma.Multiaddr) bool {
+	return !rg.recursive(pid, addr)
 }
 
 func (rg RecursionGater) InterceptPeerDial(pid peer.ID) bool {
@@ -86,7 +102,7 @@ func (rg RecursionGater) InterceptPeerDial(pid peer.ID) bool {
 }
 
 func (rg RecursionGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
-	return true
+	return !rg.recursive("", addrs.RemoteMultiaddr())
 }
 
 func (rg RecursionGater) InterceptSecured(direction network.Direction, pid peer.ID, addrs network.ConnMultiaddrs) bool {
@@ -0,0 +1,47 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/yl2chen/cidranger"
+)
+
+// Test_RecursionGater pretends loopback is the tunnel, with routes through it
+// to one peer.
+func Test_RecursionGater(t *testing.T) {
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface:", err)
+	}
+	ids := makeTestIDs(t, 2)
+	target, other := ids[0], ids[1]
+	cfg := makeTestConfig(target, other)
+	cfg.PeerLookup.ByRoute = cidranger.NewPCTrieRanger()
+	for _, cidr := range []string{"127.0.0.0/8", "::1/128"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		require.NoError(t, cfg.PeerLookup.ByRoute.Insert(&config.RouteTableEntry{Net: *n, Target: cfg.PeerLookup.ByID[target]}))
+	}
+	rg := RecursionGater{config: cfg, ifindex: lo.Attrs().Index}
+
+	for _, addr := range []string{"/ip4/127.0.0.1/tcp/8001", "/ip6/::1/udp/8001/quic-v1"} {
+		a := multiaddr.StringCast(addr)
+		assert.False(t, rg.InterceptAddrDial(target, a), addr)
+		assert.True(t, rg.InterceptAddrDial(other, a), addr)
+		assert.False(t, rg.InterceptAccept(testConnAddrs{a}), addr)
+	}
+
+	// checked again once the swarm resolved it
+	dns := multiaddr.StringCast("/dns4/localhost/tcp/8001")
+	assert.True(t, rg.InterceptAddrDial(target, dns))
+
+	public := multiaddr.StringCast("/ip4/203.0.113.1/tcp/8001")
+	assert.True(t, rg.InterceptAddrDial(target, public))
+	assert.True(t, rg.InterceptSecured(network.DirInbound, target, testConnAddrs{public}))
+}
@@ -0,0 +1,309 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/hyprspace/hyprspace/config"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	routedhost "github.com/libp2p/go-libp2p/p2p/host/routed"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// SharedHost is a libp2p host that several networks with the same key use.
+// The network that creates it sets up everything that isn't tied to a
+// network from its config: listen addresses, transports, routing, the relay
+// service, hole punching and resource limits. Streams and connections are
+// handled by the network the remote peer belongs to, so networks on the same
+// host can't have peers in common. Those of other peers go to the network that
+// created the host.
+type SharedHost struct {
+	host      host.Host
+	dht       *dht.IpfsDHT
+	holePunch *holepunch.Service
+	lock      sync.RWMutex
+	networks  []*sharedNetwork
+	protocols map[protocol.ID]bool
+}
+
+// sharedNetwork is one of the networks on a shared host.
+type sharedNetwork struct {
+	config   *config.Config
+	gater    connmgr.ConnectionGater
+	acl      relay.ACLFilter
+	upgrader *Upgrader
+	handlers map[protocol.ID]network.StreamHandler
+}
+
+func NewSharedHost() *SharedHost {
+	return &SharedHost{
+		protocols: make(map[protocol.ID]bool),
+	}
+}
+
+// add adds a network to the host, unless it has peers in common with one of
+// the networks on it already.
+func (sh *SharedHost) add(cfg *config.Config, gater connmgr.ConnectionGater, acl relay.ACLFilter, upgrader *Upgrader) (*sharedNetwork, error) {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	for _, n := range sh.networks {
+		for _, p := range cfg.Peers {
+			if _, found := n.config.PeerByID(p.ID); found {
+				return nil, fmt.Errorf("peer %s is also a peer of network %s, networks sharing a host can't have peers in common", p.ID, n.config.Interface)
+			}
+		}
+	}
+	n := &sharedNetwork{
+		config:   cfg,
+		gater:    gater,
+		acl:      acl,
+		upgrader: upgrader,
+		handlers: make(map[protocol.ID]network.StreamHandler),
+	}
+	sh.networks = append(sh.networks, n)
+	return n, nil
+}
+
+// Leave removes the network of cfg from the host, and closes the connections
+// to its peers. The host itself keeps running for the other networks.
+func (sh *SharedHost) Leave(cfg *config.Config) {
+	sh.remove(cfg)
+	for _, p := range cfg.Peers {
+		sh.host.ConnManager().Unprotect(p.ID, "/hyprspace/peer")
+		sh.host.Network().ClosePeer(p.ID)
+	}
+}
+
+// remove removes the network of cfg from the host.
+func (sh *SharedHost) remove(cfg *config.Config) {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	sh.networks = slices.DeleteFunc(sh.networks, func(n *sharedNetwork) bool {
+		return n.config == cfg
+	})
+}
+
+// networkOf returns the network p is a peer of, if any.
+func (sh *SharedHost) networkOf(p peer.ID) *sharedNetwork {
+	sh.lock.RLock()
+	defer sh.lock.RUnlock()
+	for _, n := range sh.networks {
+		if _, found := n.config.PeerByID(p); found {
+			return n
+		}
+	}
+	return nil
+}
+
+// handler returns the handler of proto in the network of p, or in the network
+// that created the host if p isn't a peer of any.
+func (sh *SharedHost) handler(p peer.ID, proto protocol.ID) network.StreamHandler {
+	n := sh.networkOf(p)
+	sh.lock.RLock()
+	defer sh.lock.RUnlock()
+	if n == nil && len(sh.networks) > 0 {
+		n = sh.networks[0]
+	}
+	if n == nil {
+		return nil
+	}
+	return n.handlers[proto]
+}
+
+func (sh *SharedHost) setHandler(n *sharedNetwork, proto protocol.ID, handler network.StreamHandler) {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	n.handlers[proto] = handler
+	if sh.protocols[proto] {
+		return
+	}
+	sh.protocols[proto] = true
+	sh.host.SetStreamHandler(proto, func(s network.Stream) {
+		h := sh.handler(s.Conn().RemotePeer(), proto)
+		if h == nil {
+			s.Reset()
+			return
+		}
+		h(s)
+	})
+}
+
+func (sh *SharedHost) removeHandler(n *sharedNetwork, proto protocol.ID) {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	delete(n.handlers, proto)
+}
+
+// gaters returns the gaters that decide on connections with p: that of its
+// network, or those of all networks if p isn't a peer of any.
+func (sh *SharedHost) gaters(p peer.ID) []connmgr.ConnectionGater {
+	if n := sh.networkOf(p); n != nil {
+		return []connmgr.ConnectionGater{n.gater}
+	}
+	sh.lock.RLock()
+	defer sh.lock.RUnlock()
+	var gaters []connmgr.ConnectionGater
+	for _, n := range sh.networks {
+		gaters = append(gaters, n.gater)
+	}
+	return gaters
+}
+
+// Trace hands the hole punching events to the upgraders of all networks.
+// Each of them only records those of its own peers.
+func (sh *SharedHost) Trace(evt *holepunch.Event) {
+	sh.lock.RLock()
+	defer sh.lock.RUnlock()
+	for _, n := range sh.networks {
+		n.upgrader.Trace(evt)
+	}
+}
+
+// sharedGater lets the network of the remote peer decide on a connection.
+// Connections with other peers need to be allowed by all networks.
+type sharedGater struct {
+	sh *SharedHost
+}
+
+func (g sharedGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	// The remote peer isn't known yet, so all networks decide.
+	return NewMultiGater(g.sh.gaters("")...).InterceptAccept(addrs)
+}
+
+func (g sharedGater) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) bool {
+	return NewMultiGater(g.sh.gaters(p)...).InterceptAddrDial(p, addr)
+}
+
+func (g sharedGater) InterceptPeerDial(p peer.ID) bool {
+	return NewMultiGater(g.sh.gaters(p)...).InterceptPeerDial(p)
+}
+
+func (g sharedGater) InterceptSecured(d network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	return NewMultiGater(g.sh.gaters(p)...).InterceptSecured(d, p, addrs)
+}
+
+func (g sharedGater) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+	return NewMultiGater(g.sh.gaters(conn.RemotePeer())...).InterceptUpgraded(conn)
+}
+
+// sharedRelayACL lets the network of the peers decide on relaying for them.
+// Peers of different networks aren't relayed to each other.
+type sharedRelayACL struct {
+	sh *SharedHost
+}
+
+func (a sharedRelayACL) acls(p peer.ID) []relay.ACLFilter {
+	if n := a.sh.networkOf(p); n != nil {
+		return []relay.ACLFilter{n.acl}
+	}
+	a.sh.lock.RLock()
+	defer a.sh.lock.RUnlock()
+	var acls []relay.ACLFilter
+	for _, n := range a.sh.networks {
+		acls = append(acls, n.acl)
+	}
+	return acls
+}
+
+func (a sharedRelayACL) AllowReserve(p peer.ID, addr ma.Multiaddr) bool {
+	for _, acl := range a.acls(p) {
+		if !acl.AllowReserve(p, addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a sharedRelayACL) AllowConnect(src peer.ID, srcAddr ma.Multiaddr, dest peer.ID) bool {
+	srcNet, destNet := a.sh.networkOf(src), a.sh.networkOf(dest)
+	if srcNet != nil && destNet != nil && srcNet != destNet {
+		return false
+	}
+	p := src
+	if srcNet == nil {
+		p = dest
+	}
+	for _, acl := range a.acls(p) {
+		if !acl.AllowConnect(src, srcAddr, dest) {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedNetworkHost is the shared host as seen by one of its networks. Its
+// stream handlers only get streams of the peers of that network, and it
+// doesn't open streams to the peers of other networks on the host.
+type sharedNetworkHost struct {
+	host.Host
+	sh      *SharedHost
+	network *sharedNetwork
+}
+
+func (h sharedNetworkHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.sh.setHandler(h.network, pid, handler)
+}
+
+// SetStreamHandlerMatch only handles pid itself, other protocols that match
+// aren't dispatched to the networks.
+func (h sharedNetworkHost) SetStreamHandlerMatch(pid protocol.ID, _ func(protocol.ID) bool, handler network.StreamHandler) {
+	h.sh.setHandler(h.network, pid, handler)
+}
+
+func (h sharedNetworkHost) RemoveStreamHandler(pid protocol.ID) {
+	h.sh.removeHandler(h.network, pid)
+}
+
+func (h sharedNetworkHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if n := h.sh.networkOf(p); n != nil && n != h.network {
+		return nil, fmt.Errorf("%s is a peer of network %s", p, n.config.Interface)
+	}
+	return h.Host.NewStream(ctx, p, pids...)
+}
+
+// owner reports whether the network of h created the host.
+func (h sharedNetworkHost) owner() bool {
+	h.sh.lock.RLock()
+	defer h.sh.lock.RUnlock()
+	return len(h.sh.networks) > 0 && h.sh.networks[0] == h.network
+}
+
+// JoinSharedHost adds the network of cfg to a host created by another network
+// with CreateNode. It returns the host as seen by the network, and the DHT of
+// the host. Peers are looked up with the DHT of the host and the other
+// routing settings of cfg.
+func JoinSharedHost(sh *SharedHost, cfg *config.Config, handler network.StreamHandler, pex *PeX, upgrader *Upgrader, book *AddrBook, rv *Rendezvous, acl relay.ACLFilter, gater connmgr.ConnectionGater) (host.Host, *dht.IpfsDHT, error) {
+	pr, err := networkRouting(cfg, pex, rv, book, sh.dht)
+	if err != nil {
+		return nil, nil, err
+	}
+	n, err := sh.add(cfg, gater, acl, upgrader)
+	if err != nil {
+		return nil, nil, err
+	}
+	h := sharedNetworkHost{routedhost.Wrap(sh.host, pr), sh, n}
+	upgrader.host = h
+	upgrader.service = sh.holePunch
+	upgrader.shared = true
+	book.start(h)
+	addPeerAddrs(h, cfg)
+	pex.host = h
+	rv.host = h
+	for _, proto := range Protocols {
+		h.SetStreamHandler(proto, handler)
+	}
+	for _, proto := range PeXProtocols {
+		h.SetStreamHandler(proto, pex.streamHandler)
+	}
+	return h, sh.dht, nil
+}
@@ -0,0 +1,121 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProtocol = protocol.ID("/hyprspace/test/0.0.1")
+
+func joinTestSharedHost(t *testing.T, sh *SharedHost, cfg *config.Config) sharedNetworkHost {
+	n, err := sh.add(cfg, NewMemberGater(cfg), NewClosedCircuitRelayFilter(cfg), NewUpgrader(cfg))
+	require.NoError(t, err)
+	return sharedNetworkHost{sh.host, sh, n}
+}
+
+// handledBy returns which network handles the test stream opened by h to the
+// shared host, or "" if the stream was reset.
+func handledBy(t *testing.T, h host.Host, sh *SharedHost) string {
+	ctx := context.Background()
+	require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: sh.host.ID(), Addrs: sh.host.Addrs()}))
+	s, err := h.NewStream(ctx, sh.host.ID(), testProtocol)
+	if err != nil {
+		return ""
+	}
+	defer s.Close()
+	buf := make([]byte, 4)
+	n, _ := s.Read(buf)
+	return string(buf[:n])
+}
+
+func Test_SharedHost_add(t *testing.T) {
+	ids := makeTestIDs(t, 3)
+	sh := NewSharedHost()
+	_, err := sh.add(makeTestConfig(ids[0], ids[1]), nil, nil, nil)
+	require.NoError(t, err)
+	_, err = sh.add(makeTestConfig(ids[2]), nil, nil, nil)
+	assert.NoError(t, err)
+	_, err = sh.add(makeTestConfig(ids[1]), nil, nil, nil)
+	assert.Error(t, err, "networks with peers in common can't share a host")
+}
+
+func Test_SharedHost_streams(t *testing.T) {
+	a, b, c, stranger := makeTestListeningHost(t), makeTestListeningHost(t), makeTestListeningHost(t), makeTestListeningHost(t)
+	sh := NewSharedHost()
+	sh.host = a
+	cfg1, cfg2 := makeTestConfig(b.ID()), makeTestConfig(c.ID())
+	h1, h2 := joinTestSharedHost(t, sh, cfg1), joinTestSharedHost(t, sh, cfg2)
+	reply := func(name string) network.StreamHandler {
+		return func(s network.Stream) {
+			s.Write([]byte(name))
+			s.Close()
+		}
+	}
+	h1.SetStreamHandler(testProtocol, reply("net1"))
+	h2.SetStreamHandler(testProtocol, reply("net2"))
+
+	assert.Equal(t, "net1", handledBy(t, b, sh))
+	assert.Equal(t, "net2", handledBy(t, c, sh))
+	assert.Equal(t, "net1", handledBy(t, stranger, sh), "streams of other peers go to the first network")
+
+	t.Run("no handler", func(t *testing.T) {
+		h2.RemoveStreamHandler(testProtocol)
+		assert.Empty(t, handledBy(t, c, sh))
+		h2.SetStreamHandler(testProtocol, reply("net2"))
+	})
+
+	t.Run("streams to other networks", func(t *testing.T) {
+		_, err := h1.NewStream(context.Background(), c.ID(), testProtocol)
+		assert.Error(t, err)
+		assert.True(t, h1.owner())
+		assert.False(t, h2.owner())
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		sh.Leave(cfg2)
+		c.Network().ClosePeer(sh.host.ID())
+		assert.Equal(t, "net1", handledBy(t, c, sh), "peers of a network that left are strangers")
+	})
+}
+
+func Test_sharedGater(t *testing.T) {
+	ids := makeTestIDs(t, 3)
+	sh := NewSharedHost()
+	sh.host = makeTestHost(t)
+	joinTestSharedHost(t, sh, makeTestConfig(ids[0]))
+	joinTestSharedHost(t, sh, makeTestConfig(ids[1]))
+	gater := sharedGater{sh}
+
+	assert.True(t, gater.InterceptPeerDial(ids[0]), "the network of the peer decides")
+	assert.True(t, gater.InterceptPeerDial(ids[1]), "the network of the peer decides")
+	assert.False(t, gater.InterceptPeerDial(ids[2]), "all networks decide on other peers")
+}
+
+func Test_sharedRelayACL(t *testing.T) {
+	ids := makeTestIDs(t, 3)
+	sh := NewSharedHost()
+	sh.host = makeTestHost(t)
+	cfg1, cfg2 := makeTestConfig(ids[0]), makeTestConfig(ids[1])
+	for _, cfg := range []*config.Config{cfg1, cfg2} {
+		for i := range cfg.Peers {
+			cfg.Peers[i].AllowRelay = true
+			cfg.PeerLookup.ByID[cfg.Peers[i].ID] = cfg.Peers[i]
+		}
+		joinTestSharedHost(t, sh, cfg)
+	}
+	acl := sharedRelayACL{sh}
+
+	assert.True(t, acl.AllowReserve(ids[0], nil))
+	assert.True(t, acl.AllowReserve(ids[1], nil))
+	assert.False(t, acl.AllowReserve(ids[2], nil))
+	assert.True(t, acl.AllowConnect(ids[2], nil, ids[1]))
+	assert.False(t, acl.AllowConnect(ids[0], nil, ids[1]), "peers of different networks aren't relayed to each other")
+}
@@ -0,0 +1,83 @@
+package p2p
+
+import (
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var gaterRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "hyprspace",
+	Subsystem: "gater",
+	Name:      "rejected_total",
+	Help:      "Connections rejected by the connection gaters.",
+}, []string{"gater"})
+
+// SourceGater only lets VPN peers with allowed sources connect from those
+// networks. Relayed connections to them are rejected, their source can't be
+// told.
+type SourceGater struct {
+	config *config.Config
+}
+
+func NewSourceGater(cfg *config.Config) connmgr.ConnectionGater {
+	return SourceGater{
+		config: cfg,
+	}
+}
+
+func (sg SourceGater) allowed(p peer.ID, addr multiaddr.Multiaddr) bool {
+	cfgPeer, found := sg.config.PeerByID(p)
+	if !found || len(cfgPeer.AllowedSources) == 0 {
+		return true
+	}
+	if IsRelayedAddr(addr) {
+		return false
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return false
+	}
+	for _, network := range cfgPeer.AllowedSources {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sg SourceGater) InterceptAccept(network.ConnMultiaddrs) (allow bool) {
+	return true
+}
+
+func (sg SourceGater) InterceptAddrDial(peer.ID, multiaddr.Multiaddr) (allow bool) {
+	return true
+}
+
+func (sg SourceGater) InterceptPeerDial(peer.ID) (allow bool) {
+	return true
+}
+
+func (sg SourceGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) (allow bool) {
+	if sg.allowed(p, addrs.RemoteMultiaddr()) {
+		return true
+	}
+	logger.With(
+		zap.String("peer", p.String()),
+		zap.String("addr", addrs.RemoteMultiaddr().String()),
+		zap.Stringer("direction", dir),
+	).Warn("Rejected connection from a source that isn't allowed")
+	gaterRejected.WithLabelValues("source").Inc()
+	return false
+}
+
+func (sg SourceGater) InterceptUpgraded(network.Conn) (allow bool, reason control.DisconnectReason) {
+	return true, 0
+}
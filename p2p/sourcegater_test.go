@@ -0,0 +1,43 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+type testConnAddrs struct {
+	remote multiaddr.Multiaddr
+}
+
+func (c testConnAddrs) LocalMultiaddr() multiaddr.Multiaddr {
+	return multiaddr.StringCast("/ip4/192.0.2.1/tcp/8001")
+}
+
+func (c testConnAddrs) RemoteMultiaddr() multiaddr.Multiaddr {
+	return c.remote
+}
+
+func Test_SourceGater(t *testing.T) {
+	pinned, free, stranger := peer.ID("pinned"), peer.ID("free"), peer.ID("stranger")
+	cfg := makeTestConfig(pinned, free)
+	_, office, _ := net.ParseCIDR("203.0.113.0/24")
+	p := cfg.PeerLookup.ByID[pinned]
+	p.AllowedSources = []net.IPNet{*office}
+	cfg.PeerLookup.ByID[pinned] = p
+	gater := NewSourceGater(cfg)
+
+	secured := func(p peer.ID, addr string) bool {
+		return gater.InterceptSecured(network.DirInbound, p, testConnAddrs{multiaddr.StringCast(addr)})
+	}
+	assert.True(t, secured(pinned, "/ip4/203.0.113.7/udp/8001/quic-v1"))
+	assert.False(t, secured(pinned, "/ip4/198.51.100.7/tcp/8001"))
+	assert.False(t, secured(pinned, "/ip4/203.0.113.1/tcp/8001/p2p/12D3KooWQWsHPUUeFhe4b6pyCaD1hBoj8j6Z7S7kTznRTh1p1eVt/p2p-circuit"), "relayed connections can't be pinned")
+	assert.False(t, secured(pinned, "/dns4/vpn.example.com/tcp/8001"))
+	assert.True(t, secured(free, "/ip4/198.51.100.7/tcp/8001"))
+	assert.True(t, secured(stranger, "/ip4/198.51.100.7/tcp/8001"))
+}
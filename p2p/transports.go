@@ -0,0 +1,88 @@
+package p2p
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/hyprspace/hyprspace/config"
+	"github.com/libp2p/go-libp2p"
+	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	libp2pwebrtc "github.com/libp2p/go-libp2p/p2p/transport/webrtc"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	webtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// listenTransports reports which of the optional transports are used by the
+// given listen addresses.
+type listenTransports struct {
+	websocket       bool
+	secureWebsocket bool
+	webtransport    bool
+	webrtc          bool
+}
+
+func findListenTransports(addrs []ma.Multiaddr) (lt listenTransports) {
+	for _, addr := range addrs {
+		for _, c := range addr {
+			switch c.Code() {
+			case ma.P_WS:
+				lt.websocket = true
+			case ma.P_WSS:
+				lt.websocket = true
+				lt.secureWebsocket = true
+			case ma.P_TLS:
+				if _, err := addr.ValueForProtocol(ma.P_WS); err == nil {
+					lt.secureWebsocket = true
+				}
+			case ma.P_WEBTRANSPORT:
+				lt.webtransport = true
+			case ma.P_WEBRTC_DIRECT:
+				lt.webrtc = true
+			}
+		}
+	}
+	return
+}
+
+// transportOptions enables QUIC and TCP, and any other transport that is used
+// by one of the listen addresses. Outgoing WebSocket connections go through
+// the HTTP proxy set in the environment, if any.
+func transportOptions(cfg *config.Config, privateNet bool) (libp2p.Option, error) {
+	lt := findListenTransports(cfg.ListenAddresses)
+	opts := []libp2p.Option{
+		libp2p.Transport(libp2pquic.NewTransport),
+		libp2p.Transport(tcp.NewTCPTransport),
+	}
+	if lt.websocket {
+		var wsOpts []any
+		if lt.secureWebsocket {
+			if len(cfg.TLSCertificates) == 0 {
+				return nil, errors.New("listening on /wss requires tlsCertificateFile and tlsKeyFile")
+			}
+			wsOpts = append(wsOpts, websocket.WithTLSConfig(&tls.Config{
+				Certificates: cfg.TLSCertificates,
+			}))
+		}
+		opts = append(opts, libp2p.Transport(websocket.New, wsOpts...))
+		// Allow WebSocket and TCP to listen on the same port. This
+		// doesn't work in private networks, where they need separate ports.
+		if !privateNet {
+			opts = append(opts, libp2p.ShareTCPListener())
+		}
+	}
+	if lt.webtransport {
+		if privateNet {
+			return nil, errors.New("WebTransport can't be used with a swarm key")
+		}
+		opts = append(opts, libp2p.Transport(webtransport.New))
+	}
+	if lt.webrtc {
+		if privateNet {
+			return nil, errors.New("WebRTC can't be used with a swarm key")
+		}
+		opts = append(opts, libp2p.Transport(libp2pwebrtc.New))
+	}
+	return libp2p.ChainOptions(opts...), nil
+}
@@ -0,0 +1,54 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/hyprspace/hyprspace/config"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_findListenTransports(t *testing.T) {
+	cases := []struct {
+		addr     string
+		expected listenTransports
+	}{
+		{"/ip4/0.0.0.0/tcp/8001", listenTransports{}},
+		{"/ip4/0.0.0.0/udp/8001/quic-v1", listenTransports{}},
+		{"/ip4/0.0.0.0/tcp/8001/ws", listenTransports{websocket: true}},
+		{"/ip4/0.0.0.0/tcp/443/wss", listenTransports{websocket: true, secureWebsocket: true}},
+		{"/ip4/0.0.0.0/tcp/443/tls/ws", listenTransports{websocket: true, secureWebsocket: true}},
+		{"/ip4/0.0.0.0/udp/8001/quic-v1/webtransport", listenTransports{webtransport: true}},
+		{"/ip6/::/udp/8002/webrtc-direct", listenTransports{webrtc: true}},
+	}
+	for _, c := range cases {
+		t.Run(c.addr, func(t *testing.T) {
+			assert.Equal(t, c.expected, findListenTransports([]ma.Multiaddr{ma.StringCast(c.addr)}))
+		})
+	}
+}
+
+func Test_transportOptions(t *testing.T) {
+	listen := func(addrs ...string) *config.Config {
+		cfg := &config.Config{}
+		for _, a := range addrs {
+			cfg.ListenAddresses = append(cfg.ListenAddresses, ma.StringCast(a))
+		}
+		return cfg
+	}
+
+	_, err := transportOptions(listen("/ip4/0.0.0.0/tcp/8001", "/ip4/0.0.0.0/tcp/8001/ws"), true)
+	assert.NoError(t, err)
+
+	_, err = transportOptions(listen("/ip4/0.0.0.0/tcp/443/wss"), false)
+	assert.Error(t, err, "wss without a certificate")
+
+	_, err = transportOptions(listen("/ip4/0.0.0.0/udp/8001/quic-v1/webtransport"), true)
+	assert.Error(t, err, "webtransport with a swarm key")
+
+	_, err = transportOptions(listen("/ip4/0.0.0.0/udp/8002/webrtc-direct"), true)
+	assert.Error(t, err, "webrtc with a swarm key")
+
+	_, err = transportOptions(listen("/ip4/0.0.0.0/udp/8002/webrtc-direct"), false)
+	assert.NoError(t, err)
+}
@@ -14,6 +14,23 @@ func connect(ifname string) *rpc.Client {
 	return client
 }
 
+// Running reports whether the daemon of ifname can be reached.
+func Running(ifname string) bool {
+	client, err := rpc.Dial("unix", fmt.Sprintf("/run/hyprspace-rpc.%s.sock", ifname))
+	if err != nil {
+		return false
+	}
+	client.Close()
+	return true
+}
+
+func Reload(ifname string) {
+	client := connect(ifname)
+	if err := client.Call("HyprspaceRPC.Reload", new(Args), new(Args)); err != nil {
+		log.Fatal("[!] RPC call failed: ", err)
+	}
+}
+
 func Status(ifname string) StatusReply {
 	client := connect(ifname)
 	var reply StatusReply
@@ -32,6 +49,67 @@ func Peers(ifname string) PeersReply {
 	return reply
 }
 
+func Network(ifname string) NetworkReply {
+	client := connect(ifname)
+	var reply NetworkReply
+	if err := client.Call("HyprspaceRPC.Network", new(Args), &reply); err != nil {
+		log.Fatal("[!] RPC call failed: ", err)
+	}
+	return reply
+}
+
+func HolePunch(ifname string) HolePunchReply {
+	client := connect(ifname)
+	var reply HolePunchReply
+	if err := client.Call("HyprspaceRPC.HolePunch", new(Args), &reply); err != nil {
+		log.Fatal("[!] RPC call failed: ", err)
+	}
+	return reply
+}
+
+func Discovery(ifname string) DiscoveryReply {
+	client := connect(ifname)
+	var reply DiscoveryReply
+	if err := client.Call("HyprspaceRPC.Discovery", new(Args), &reply); err != nil {
+		log.Fatal("[!] RPC call failed: ", err)
+	}
+	return reply
+}
+
+func Resources(ifname string) ResourcesReply {
+	client := connect(ifname)
+	var reply ResourcesReply
+	if err := client.Call("HyprspaceRPC.Resources", new(Args), &reply); err != nil {
+		log.Fatal("[!] RPC call failed: ", err)
+	}
+	return reply
+}
+
+func Invite(ifname string, args InviteArgs) InviteReply {
+	client := connect(ifname)
+	var reply InviteReply
+	if err := client.Call("HyprspaceRPC.Invite", args, &reply); err != nil {
+		log.Fatal("[!] RPC call failed: ", err)
+	}
+	return reply
+}
+
+func JoinRequests(ifname string) JoinRequestsReply {
+	client := connect(ifname)
+	var reply JoinRequestsReply
+	if err := client.Call("HyprspaceRPC.JoinRequests", new(Args), &reply); err != nil {
+		log.Fatal("[!] RPC call failed: ", err)
+	}
+	return reply
+}
+
+func DecideJoin(ifname string, args DecideJoinArgs) {
+	client := connect(ifname)
+	if err := client.Call("HyprspaceRPC.DecideJoin", args, new(Args)); err != nil {
+		log.Fatal("[!] RPC call failed: ", err)
+	}
+}
+
 func Route(ifname string, args RouteArgs) RouteReply {
 	client := connect(ifname)
 	var reply RouteReply
@@ -10,6 +10,7 @@ import (
 	"slices"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/hyprspace/hyprspace/config"
 	"github.com/hyprspace/hyprspace/p2p"
@@ -25,9 +26,15 @@ import (
 var logger = log.Logger("hyprspace/rpc")
 
 type HyprspaceRPC struct {
-	host   host.Host
-	config config.Config
-	tunDev tun.TUN
+	host      host.Host
+	config    config.Config
+	tunDev    tun.TUN
+	gossip    *p2p.Gossip
+	upgrader  *p2p.Upgrader
+	discovery *p2p.Discovery
+	prober    *p2p.Prober
+	invites   *p2p.Invites
+	reload    func()
 }
 
 func (hsr *HyprspaceRPC) Status(args *Args, reply *StatusReply) error {
@@ -36,10 +43,22 @@ func (hsr *HyprspaceRPC) Status(args *Args, reply *StatusReply) error {
 	for _, p := range hsr.config.Peers {
 		if hsr.host.Network().Connectedness(p.ID) == network.Connected {
 			netPeersCurrent = netPeersCurrent + 1
+			stats := map[string]p2p.LinkStats{}
+			for _, ls := range hsr.prober.Stats(p.ID) {
+				stats[ls.Addr] = ls
+			}
 			for _, c := range hsr.host.Network().ConnsToPeer(p.ID) {
+				quality := hsr.host.Peerstore().LatencyEWMA(p.ID).String()
+				if ls, ok := stats[c.RemoteMultiaddr().String()]; ok && ls.RTT > 0 {
+					quality = fmt.Sprintf("%s, jitter %s, loss %.0f%%",
+						ls.RTT.Truncate(100*time.Microsecond),
+						ls.Jitter.Truncate(100*time.Microsecond),
+						ls.Loss*100,
+					)
+				}
 				netPeerAddrsCurrent = append(netPeerAddrsCurrent, fmt.Sprintf("@%s (%s) %s/p2p/%s",
 					p.Name,
-					hsr.host.Peerstore().LatencyEWMA(p.ID).String(),
+					quality,
 					c.RemoteMultiaddr().String(),
 					p.ID.String(),
 				))
@@ -50,6 +69,14 @@ func (hsr *HyprspaceRPC) Status(args *Args, reply *StatusReply) error {
 	for _, ma := range hsr.host.Addrs() {
 		addrStrings = append(addrStrings, ma.String())
 	}
+	var relays []string
+	for _, r := range p2p.ActiveRelays(hsr.host) {
+		if p, found := hsr.config.PeerByID(r); found {
+			relays = append(relays, fmt.Sprintf("@%s /p2p/%s", p.Name, r))
+		} else {
+			relays = append(relays, fmt.Sprintf("/p2p/%s", r))
+		}
+	}
 	*reply = StatusReply{
 		hsr.host.ID().String(),
 		len(hsr.host.Network().Conns()),
@@ -57,6 +84,7 @@ func (hsr *HyprspaceRPC) Status(args *Args, reply *StatusReply) error {
 		netPeerAddrsCurrent,
 		len(hsr.config.Peers),
 		addrStrings,
+		relays,
 	}
 	return nil
 }
@@ -121,7 +149,7 @@ func (hsr *HyprspaceRPC) Route(args *RouteArgs, reply *RouteReply) error {
 		if err != nil {
 			return err
 		}
-		target, err := config.FindPeerByCLIRef(hsr.config.Peers, args.Args[1])
+		target, err := hsr.config.PeerByCLIRef(args.Args[1])
 		if err != nil {
 			return err
 		}
@@ -171,11 +199,113 @@ func (hsr *HyprspaceRPC) Peers(args *Args, reply *PeersReply) error {
 	return nil
 }
 
-func RpcServer(ctx context.Context, wg *sync.WaitGroup, ma multiaddr.Multiaddr, host host.Host, config config.Config, tunDev tun.TUN) {
+func (hsr *HyprspaceRPC) Network(args *Args, reply *NetworkReply) error {
+	states := hsr.gossip.States()
+	var peers []NetworkPeerInfo
+	for _, p := range hsr.config.Peers {
+		info := NetworkPeerInfo{
+			ID:          p.ID,
+			Name:        p.Name,
+			IsConnected: hsr.host.Network().Connectedness(p.ID) == network.Connected,
+		}
+		if state, ok := states[p.ID]; ok {
+			info.HasState = true
+			info.AdvertisedName = state.Name
+			info.Services = state.Services
+			info.Routes = state.Routes
+			info.Version = state.Version
+			info.IsOnline = state.IsOnline()
+			info.LastSeen = state.LastSeen
+		}
+		peers = append(peers, info)
+	}
+	*reply = NetworkReply{peers}
+	return nil
+}
+
+func (hsr *HyprspaceRPC) HolePunch(args *Args, reply *HolePunchReply) error {
+	status := hsr.upgrader.Status()
+	var peers []HolePunchPeerInfo
+	for _, p := range hsr.config.Peers {
+		connected := hsr.host.Network().Connectedness(p.ID) == network.Connected
+		st, ok := status[p.ID]
+		if !connected && !ok {
+			continue
+		}
+		peers = append(peers, HolePunchPeerInfo{
+			ID:          p.ID,
+			Name:        p.Name,
+			IsConnected: connected,
+			IsRelay:     connected && !p2p.HasDirectConn(hsr.host, p.ID),
+			Failures:    st.Failures,
+			NextRetry:   st.NextRetry,
+			Attempts:    st.Attempts,
+		})
+	}
+	*reply = HolePunchReply{peers}
+	return nil
+}
+
+func (hsr *HyprspaceRPC) Discovery(args *Args, reply *DiscoveryReply) error {
+	status := hsr.discovery.Status()
+	var peers []DiscoveryPeerInfo
+	for _, p := range hsr.config.Peers {
+		st := status[p.ID]
+		peers = append(peers, DiscoveryPeerInfo{
+			ID:            p.ID,
+			Name:          p.Name,
+			State:         st.State,
+			Failures:      st.Failures,
+			LastError:     st.LastError,
+			LastErrorTime: st.LastErrorTime,
+			NextRetry:     st.NextRetry,
+		})
+	}
+	*reply = DiscoveryReply{peers}
+	return nil
+}
+
+func (hsr *HyprspaceRPC) Invite(args *InviteArgs, reply *InviteReply) error {
+	token, err := hsr.invites.Create(args.TTL, args.Approve)
+	if err != nil {
+		return err
+	}
+	*reply = InviteReply{token}
+	return nil
+}
+
+func (hsr *HyprspaceRPC) JoinRequests(args *Args, reply *JoinRequestsReply) error {
+	*reply = JoinRequestsReply{hsr.invites.Pending()}
+	return nil
+}
+
+func (hsr *HyprspaceRPC) DecideJoin(args *DecideJoinArgs, reply *Args) error {
+	return hsr.invites.Decide(args.Peer, args.Approve)
+}
+
+// Reload restarts the network with its current config. The reply is sent
+// before the restart, which closes this RPC server.
+func (hsr *HyprspaceRPC) Reload(args *Args, reply *Args) error {
+	if hsr.reload == nil {
+		return errors.New("reloading isn't supported")
+	}
+	go hsr.reload()
+	return nil
+}
+
+func (hsr *HyprspaceRPC) Resources(args *Args, reply *ResourcesReply) error {
+	*reply = ResourcesReply{p2p.Resources(hsr.host, &hsr.config)}
+	return nil
+}
+
+var umaskLock sync.Mutex
+
+func RpcServer(ctx context.Context, wg *sync.WaitGroup, ma multiaddr.Multiaddr, host host.Host, config config.Config, tunDev tun.TUN, gossip *p2p.Gossip, upgrader *p2p.Upgrader, discovery *p2p.Discovery, prober *p2p.Prober, invites *p2p.Invites, reload func()) {
 	wg.Add(1)
 	defer wg.Done()
-	hsr := HyprspaceRPC{host, config, tunDev}
-	rpc.Register(&hsr)
+	hsr := HyprspaceRPC{host, config, tunDev, gossip, upgrader, discovery, prober, invites, reload}
+	server := rpc.NewServer()
+	server.Register(&hsr)
 
 	addr, err := ma.ValueForProtocol(multiaddr.P_UNIX)
 	if err != nil {
@@ -183,6 +313,8 @@ func RpcServer(ctx context.Context, wg *sync.WaitGroup, ma multiaddr.Multiaddr,
 	}
 
 	var l net.Listener
+	// The umask is shared by all networks of the process.
+	umaskLock.Lock()
 	oldUmask := syscall.Umask(0o007)
 
 	err = os.Remove(addr)
@@ -193,6 +325,7 @@ func RpcServer(ctx context.Context, wg *sync.WaitGroup, ma multiaddr.Multiaddr,
 	var lc net.ListenConfig
 	l, err = lc.Listen(ctx, "unix", addr)
 	syscall.Umask(oldUmask)
+	umaskLock.Unlock()
 
 	if err != nil {
 		logger.With(err).Fatal("Failed to launch RPC server")
@@ -200,7 +333,7 @@ func RpcServer(ctx context.Context, wg *sync.WaitGroup, ma multiaddr.Multiaddr,
 
 	logger.Info("RPC server ready")
 	defer l.Close()
-	go rpc.Accept(l)
+	go server.Accept(l)
 	<-ctx.Done()
 	logger.Info("Closing RPC server")
 }
@@ -2,7 +2,9 @@ package rpc
 
 import (
 	"net"
+	"time"
 
+	"github.com/hyprspace/hyprspace/p2p"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
@@ -16,6 +18,7 @@ type StatusReply struct {
 	NetPeerAddrsCurrent []string
 	NetPeersMax         int
 	ListenAddrs         []string
+	Relays              []string
 }
 
 type PeersReply struct {
@@ -49,3 +52,70 @@ type RouteReply struct {
 	Routes []RouteInfo
 	Err    error
 }
+
+type NetworkPeerInfo struct {
+	ID             peer.ID
+	Name           string
+	AdvertisedName string
+	Services       []string
+	Routes         []string
+	Version        string
+	HasState       bool
+	IsOnline       bool
+	IsConnected    bool
+	LastSeen       time.Time
+}
+
+type NetworkReply struct {
+	Peers []NetworkPeerInfo
+}
+
+type HolePunchPeerInfo struct {
+	ID          peer.ID
+	Name        string
+	IsConnected bool
+	IsRelay     bool
+	Failures    int
+	NextRetry   time.Time
+	Attempts    []p2p.HolePunchAttempt
+}
+
+type HolePunchReply struct {
+	Peers []HolePunchPeerInfo
+}
+
+type DiscoveryPeerInfo struct {
+	ID            peer.ID
+	Name          string
+	State         string
+	Failures      int
+	LastError     string
+	LastErrorTime time.Time
+	NextRetry     time.Time
+}
+
+type DiscoveryReply struct {
+	Peers []DiscoveryPeerInfo
+}
+
+type ResourcesReply struct {
+	p2p.ResourceUsage
+}
+
+type InviteArgs struct {
+	TTL     time.Duration
+	Approve bool
+}
+
+type InviteReply struct {
+	Token string
+}
+
+type JoinRequestsReply struct {
+	Requests []p2p.JoinRequest
+}
+
+type DecideJoinArgs struct {
+	Peer    peer.ID
+	Approve bool
+}
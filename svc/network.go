@@ -121,19 +121,16 @@ func NewServiceNetwork(host host.Host, cfg *config.Config, tunDev *hstun.TUN) Se
 	logger.Info("Service Network ready")
 
 	sn := ServiceNetwork{
-		host:   host,
-		config: cfg,
-		self:   [4]byte(cfg.BuiltinAddr6[12:16]),
-		NetworkRange: net.IPNet{
-			IP:   []byte("\xfd\x00hyprspsv\x00\x00\x00\x00\x00\x00"),
-			Mask: net.CIDRMask(80, 128),
-		},
-		Tun:         &tun,
-		netx:        netx,
-		activeAddrs: make(map[[16]byte]struct{}),
-		activePorts: make(map[[16]byte]map[uint16]struct{}),
-		listeners:   make(map[[2]byte]Proxy),
-		services:    make(map[[2]byte]config.Service),
+		host:         host,
+		config:       cfg,
+		self:         cfg.NetID(),
+		NetworkRange: cfg.Prefixes.Service,
+		Tun:          &tun,
+		netx:         netx,
+		activeAddrs:  make(map[[16]byte]struct{}),
+		activePorts:  make(map[[16]byte]map[uint16]struct{}),
+		listeners:    make(map[[2]byte]Proxy),
+		services:     make(map[[2]byte]config.Service),
 	}
 
 	host.SetStreamHandler(Protocol, sn.streamHandler())
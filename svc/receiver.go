@@ -3,7 +3,6 @@ package svc
 import (
 	"fmt"
 
-	"github.com/hyprspace/hyprspace/config"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"go.uber.org/zap"
@@ -18,7 +17,7 @@ func (sn *ServiceNetwork) isRemoteBlocked(svcId [2]byte, remotePeer peer.ID) boo
 
 func (sn *ServiceNetwork) streamHandler() func(network.Stream) {
 	return func(stream network.Stream) {
-		if _, ok := config.FindPeer(sn.config.Peers, stream.Conn().RemotePeer()); !ok {
+		if _, ok := sn.config.PeerByID(stream.Conn().RemotePeer()); !ok {
 			logger.Debug("Connection attempt from untrusted peer")
 			stream.Reset()
 			return